@@ -0,0 +1,30 @@
+REM ###########################################################################
+REM
+REM  Tables used by run_rman when CoordinationBackend=database
+REM
+REM  Run as the owner of the schema used by CatalogConnection
+REM
+REM ###########################################################################
+
+CREATE TABLE run_rman_mutex (
+	set_name      VARCHAR2(512) NOT NULL,
+	CONSTRAINT run_rman_mutex_pk PRIMARY KEY (set_name)
+);
+
+CREATE SEQUENCE run_rman_entry_seq;
+
+CREATE TABLE run_rman_entries (
+	entry_id      NUMBER         NOT NULL,
+	set_name      VARCHAR2(512)  NOT NULL,
+	entry         VARCHAR2(1024) NOT NULL,
+	CONSTRAINT run_rman_entries_pk PRIMARY KEY (entry_id)
+);
+
+CREATE INDEX run_rman_entries_set ON run_rman_entries (set_name);
+
+CREATE TABLE run_rman_leases (
+	run_id        VARCHAR2(36)   NOT NULL,
+	process_entry VARCHAR2(1024) NOT NULL,
+	heartbeat     TIMESTAMP      NOT NULL,
+	CONSTRAINT run_rman_leases_pk PRIMARY KEY (run_id)
+);
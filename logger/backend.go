@@ -0,0 +1,206 @@
+package logger
+
+// standard imports
+
+import "bufio"
+import "fmt"
+import "os"
+import "path/filepath"
+import "strings"
+import "sync"
+import "time"
+
+// local imports
+
+import "github.com/daviesluke/romana/rlog"
+
+// Local variables
+
+// logEntry is a message with the fields sent to the backends
+
+type logEntry struct {
+	Time     time.Time
+	Level    string
+	Function string
+	Message  string
+	Database string
+	Script   string
+	PID      int
+}
+
+// backend is somewhere messages are sent as well as the log file
+
+type backend interface {
+	name() string
+	write(entry logEntry) error
+	close() error
+}
+
+var backends      []backend
+var backendLevel  = "INFO"
+var backendFailed = make(map[string]bool)
+var backendMutex  sync.Mutex
+
+// Lower levels are more severe
+
+var levelRanks = map[string]int{ "CRITICAL": 1, "ERROR": 2, "WARN": 3, "INFO": 4, "DEBUG": 5 }
+
+// Local functions
+
+func readBackendConfig(logConfigFileName string) map[string]string {
+	// The backends share run_rman.logcfg with rlog which ignores settings not starting RLOG_
+
+	settings := map[string]string{
+		"LOG_BACKENDS"        : "",
+		"LOG_BACKEND_LEVEL"   : "INFO",
+		"LOG_SYSLOG_SOCKET"   : "/dev/log",
+		"LOG_SYSLOG_FACILITY" : "user",
+		"LOG_JOURNALD_SOCKET" : "/run/systemd/journal/socket",
+		"LOG_JSON_FILE"       : "",
+	}
+
+	configFile, err := os.Open(logConfigFileName)
+	if err != nil {
+		return settings
+	}
+
+	defer configFile.Close()
+
+	scanner := bufio.NewScanner(configFile)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if tokens := strings.SplitN(line, "=", 2); len(tokens) == 2 && strings.HasPrefix(strings.TrimSpace(tokens[0]), "LOG_") {
+			settings[strings.TrimSpace(tokens[0])] = strings.TrimSpace(tokens[1])
+		}
+	}
+
+	return settings
+}
+
+func closeBackends(oldList []backend) {
+	for _, oldBackend := range oldList {
+		oldBackend.close()
+	}
+}
+
+func newBackends(settings map[string]string, logDir string) ([]backend, error) {
+	var newList []backend
+
+	if _, valid := levelRanks[strings.ToUpper(settings["LOG_BACKEND_LEVEL"])]; !valid {
+		return nil, fmt.Errorf("LOG_BACKEND_LEVEL %s is not one of CRITICAL, ERROR, WARN, INFO or DEBUG", settings["LOG_BACKEND_LEVEL"])
+	}
+
+	for _, backendName := range strings.Split(settings["LOG_BACKENDS"], ",") {
+		switch strings.ToLower(strings.TrimSpace(backendName)) {
+		case "":
+			continue
+		case "syslog":
+			syslog, err := newSyslogBackend(settings["LOG_SYSLOG_SOCKET"], settings["LOG_SYSLOG_FACILITY"])
+			if err != nil {
+				closeBackends(newList)
+				return nil, err
+			}
+
+			newList = append(newList, syslog)
+		case "journald":
+			newList = append(newList, newJournaldBackend(settings["LOG_JOURNALD_SOCKET"]))
+		case "json":
+			jsonFileName := settings["LOG_JSON_FILE"]
+
+			if jsonFileName == "" {
+				jsonFileName = filepath.Join(logDir, strings.Join( []string{ getBaseName(), "jsonl" }, "."))
+			}
+
+			jsonLog, err := newJSONBackend(jsonFileName)
+			if err != nil {
+				closeBackends(newList)
+				return nil, err
+			}
+
+			newList = append(newList, jsonLog)
+		default:
+			closeBackends(newList)
+			return nil, fmt.Errorf("unknown log backend %s in LOG_BACKENDS. Use syslog, journald or json", backendName)
+		}
+	}
+
+	return newList, nil
+}
+
+func setBackends(logConfigFileName string, logDir string) {
+	trace2("Setting log backends ...")
+
+	settings := readBackendConfig(logConfigFileName)
+
+	newList, err := newBackends(settings, logDir)
+	if err != nil {
+		Warnf("Log backends not used - %s", err)
+		return
+	}
+
+	// Each Initialize sets the backends again so close the files and sockets of the old ones
+
+	backendMutex.Lock()
+
+	oldList := backends
+
+	backends     = newList
+	backendLevel = strings.ToUpper(settings["LOG_BACKEND_LEVEL"])
+
+	closeBackends(oldList)
+
+	backendMutex.Unlock()
+
+	for _, newBackend := range newList {
+		Debugf("Sending log messages at level %s and above to %s", strings.ToUpper(settings["LOG_BACKEND_LEVEL"]), newBackend.name())
+	}
+}
+
+func sends(level string) bool {
+	// Saves working out the caller for debug messages no backend wants
+
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+
+	return len(backends) > 0 && levelRanks[level] <= levelRanks[backendLevel]
+}
+
+func send(level string, function string, message string) {
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+
+	if len(backends) == 0 || levelRanks[level] > levelRanks[backendLevel] {
+		return
+	}
+
+	entry := logEntry{
+		Time     : time.Now(),
+		Level    : level,
+		Function : function,
+		Message  : message,
+		Database : database,
+		Script   : scriptName,
+		PID      : os.Getpid(),
+	}
+
+	for _, logBackend := range backends {
+		// Only the first failure of each backend is reported so the log file is not flooded
+		// rlog is used directly as reporting through this package would send again
+
+		if err := logBackend.write(entry); err != nil {
+			if !backendFailed[logBackend.name()] {
+				rlog.Warnf("Unable to send log messages to %s - %s", logBackend.name(), err)
+			}
+
+			backendFailed[logBackend.name()] = true
+		} else {
+			backendFailed[logBackend.name()] = false
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return dir
+}
+
+func listenDatagram(t *testing.T, socketPath string) *net.UnixConn {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return conn
+}
+
+func readDatagram(t *testing.T, conn *net.UnixConn) string {
+	buffer := make([]byte, 65536)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return string(buffer[:n])
+}
+
+func testEntry(level string, message string) logEntry {
+	return logEntry{
+		Time:     time.Date(2026, 10, 19, 2, 30, 0, 123456000, time.UTC),
+		Level:    level,
+		Function: "main.main",
+		Message:  message,
+		Database: "ORCL",
+		Script:   "backup_full",
+		PID:      4242,
+	}
+}
+
+func TestSyslogDatagram(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "log")
+
+	conn := listenDatagram(t, socketPath)
+	defer conn.Close()
+
+	syslog, err := newSyslogBackend(socketPath, "local3")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	syslog.hostName = "dbhost"
+	syslog.appName = "run_rman"
+
+	if err := syslog.write(testEntry("ERROR", `ORA-01017 for "sys"`)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `<155>1 2026-10-19T02:30:00.123456Z dbhost run_rman 4242 - [run_rman@32473 database="ORCL" script="backup_full" function="main.main" level="ERROR"] ORA-01017 for "sys"`
+
+	if message := readDatagram(t, conn); message != expected {
+		t.Fatalf("bad: %s", message)
+	}
+}
+
+func TestSyslogStream(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "log")
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	syslog, err := newSyslogBackend(socketPath, "user")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := syslog.write(testEntry("INFO", "Process complete")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	select {
+	case line := <-received:
+		if !strings.HasPrefix(line, "<14>1 ") || !strings.HasSuffix(line, "] Process complete\n") {
+			t.Fatalf("bad: %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+
+	if _, err := newSyslogBackend(socketPath, "nosuch"); err == nil {
+		t.Fatal("expected error for unknown facility")
+	}
+}
+
+func TestJournald(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "socket")
+
+	conn := listenDatagram(t, socketPath)
+	defer conn.Close()
+
+	journald := newJournaldBackend(socketPath)
+
+	if err := journald.write(testEntry("WARN", "first line\nsecond line")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	payload := readDatagram(t, conn)
+
+	for _, field := range []string{"PRIORITY=4\n", "CODE_FUNC=main.main\n", "RUN_RMAN_DATABASE=ORCL\n", "RUN_RMAN_SCRIPT=backup_full\n", "RUN_RMAN_LEVEL=WARN\n", "SYSLOG_PID=4242\n"} {
+		if !strings.Contains(payload, field) {
+			t.Fatalf("missing %q in %q", field, payload)
+		}
+	}
+
+	// A message with a new line is sent with its length
+	var message bytes.Buffer
+
+	message.WriteString("MESSAGE\n")
+	binary.Write(&message, binary.LittleEndian, uint64(len("first line\nsecond line")))
+	message.WriteString("first line\nsecond line\n")
+
+	if !strings.HasPrefix(payload, message.String()) {
+		t.Fatalf("bad: %q", payload)
+	}
+
+	// Empty fields are left out
+	entry := testEntry("INFO", "starting")
+	entry.Database = ""
+
+	if strings.Contains(string(journald.format(entry)), "RUN_RMAN_DATABASE") {
+		t.Fatal("empty database should not be sent")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	jsonFileName := filepath.Join(dir, "run_rman.jsonl")
+
+	jsonLog, err := newJSONBackend(jsonFileName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	jsonLog.write(testEntry("INFO", "first"))
+	jsonLog.write(testEntry("ERROR", "second"))
+
+	contents, err := ioutil.ReadFile(jsonFileName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("bad: %s", contents)
+	}
+
+	var entry jsonEntry
+
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := jsonEntry{Time: "2026-10-19T02:30:00.123456Z", Level: "ERROR", Host: jsonLog.hostName, PID: 4242, Database: "ORCL", Script: "backup_full", Function: "main.main", Message: "second"}
+
+	if entry != expected {
+		t.Fatalf("bad: %#v", entry)
+	}
+}
+
+func TestSetBackends(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	defer func() { backends = nil }()
+
+	syslogConn := listenDatagram(t, filepath.Join(dir, "log"))
+	defer syslogConn.Close()
+
+	journalConn := listenDatagram(t, filepath.Join(dir, "journal"))
+	defer journalConn.Close()
+
+	logConfig := strings.Join([]string{
+		"RLOG_TIME_FORMAT = 2006-01-02:15:04:05",
+		"LOG_BACKENDS = syslog, journald ,json",
+		"LOG_BACKEND_LEVEL = warn",
+		"LOG_SYSLOG_SOCKET = " + filepath.Join(dir, "log"),
+		"LOG_JOURNALD_SOCKET = " + filepath.Join(dir, "journal"),
+	}, "\n")
+
+	logConfigFileName := filepath.Join(dir, "run_rman.logcfg")
+
+	if err := ioutil.WriteFile(logConfigFileName, []byte(logConfig), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	setBackends(logConfigFileName, dir)
+
+	if len(backends) != 3 || backendLevel != "WARN" {
+		t.Fatalf("bad: %d %s", len(backends), backendLevel)
+	}
+
+	// Messages below the level are not sent
+	send("INFO", "main.main", "not sent")
+	send("WARN", "main.main", "sent")
+
+	if message := readDatagram(t, syslogConn); !strings.HasSuffix(message, "] sent") {
+		t.Fatalf("bad: %s", message)
+	}
+
+	if payload := readDatagram(t, journalConn); !strings.Contains(payload, "MESSAGE=sent\n") {
+		t.Fatalf("bad: %s", payload)
+	}
+
+	// The JSON file defaults to the log directory
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+
+	if len(files) != 1 {
+		t.Fatalf("bad: %v", files)
+	}
+
+	if contents, _ := ioutil.ReadFile(files[0]); strings.Count(string(contents), "\n") != 1 {
+		t.Fatalf("bad: %s", contents)
+	}
+
+	// A backend that has gone away does not stop the others
+	journalConn.Close()
+	os.Remove(filepath.Join(dir, "journal"))
+
+	send("ERROR", "main.main", "still sent")
+
+	if message := readDatagram(t, syslogConn); !strings.HasSuffix(message, "] still sent") {
+		t.Fatalf("bad: %s", message)
+	}
+
+	// Setting the backends again closes the old ones
+	oldJSON := backends[2].(*jsonBackend)
+
+	setBackends(logConfigFileName, dir)
+
+	if _, err := oldJSON.file.Write([]byte("x")); err == nil {
+		t.Fatal("old JSON file should be closed")
+	}
+
+	if !sends("WARN") || sends("DEBUG") {
+		t.Fatal("bad levels")
+	}
+}
+
+func TestNewBackendsErrors(t *testing.T) {
+	cases := []map[string]string{
+		{"LOG_BACKENDS": "kafka", "LOG_BACKEND_LEVEL": "INFO"},
+		{"LOG_BACKENDS": "syslog", "LOG_BACKEND_LEVEL": "VERBOSE", "LOG_SYSLOG_FACILITY": "user"},
+		{"LOG_BACKENDS": "syslog", "LOG_BACKEND_LEVEL": "INFO", "LOG_SYSLOG_FACILITY": "nosuch"},
+	}
+
+	for _, settings := range cases {
+		if _, err := newBackends(settings, os.TempDir()); err == nil {
+			t.Fatalf("expected error for %v", settings)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package logger
+
+// standard imports
+
+import "bytes"
+import "encoding/binary"
+import "net"
+import "strconv"
+import "strings"
+
+// Local variables
+
+// journaldBackend sends messages to systemd-journald using its native protocol
+
+type journaldBackend struct {
+	socketPath string
+	conn       net.Conn
+}
+
+// Local functions
+
+func newJournaldBackend(socketPath string) *journaldBackend {
+	return &journaldBackend{ socketPath: socketPath }
+}
+
+func addJournalField(payload *bytes.Buffer, name string, value string) {
+	// Values with a new line are sent as the name, the length as 64 bit little endian then the value
+
+	if !strings.Contains(value, "\n") {
+		payload.WriteString(name)
+		payload.WriteByte('=')
+		payload.WriteString(value)
+		payload.WriteByte('\n')
+		return
+	}
+
+	payload.WriteString(name)
+	payload.WriteByte('\n')
+
+	binary.Write(payload, binary.LittleEndian, uint64(len(value)))
+
+	payload.WriteString(value)
+	payload.WriteByte('\n')
+}
+
+func (j *journaldBackend) format(entry logEntry) []byte {
+	var payload bytes.Buffer
+
+	addJournalField(&payload, "MESSAGE", entry.Message)
+	addJournalField(&payload, "PRIORITY", strconv.Itoa(syslogSeverities[entry.Level]))
+	addJournalField(&payload, "SYSLOG_IDENTIFIER", getBaseName())
+	addJournalField(&payload, "SYSLOG_PID", strconv.Itoa(entry.PID))
+	addJournalField(&payload, "CODE_FUNC", entry.Function)
+	addJournalField(&payload, "RUN_RMAN_LEVEL", entry.Level)
+
+	// Empty fields are left out so journalctl matches only runs that set them
+
+	if entry.Database != "" {
+		addJournalField(&payload, "RUN_RMAN_DATABASE", entry.Database)
+	}
+
+	if entry.Script != "" {
+		addJournalField(&payload, "RUN_RMAN_SCRIPT", entry.Script)
+	}
+
+	return payload.Bytes()
+}
+
+func (j *journaldBackend) name() string {
+	return strings.Join( []string{ "journald", j.socketPath }, " ")
+}
+
+func (j *journaldBackend) close() error {
+	if j.conn == nil {
+		return nil
+	}
+
+	err := j.conn.Close()
+
+	j.conn = nil
+
+	return err
+}
+
+func (j *journaldBackend) write(entry logEntry) error {
+	if j.conn == nil {
+		conn, err := net.Dial("unixgram", j.socketPath)
+		if err != nil {
+			return err
+		}
+
+		j.conn = conn
+	}
+
+	if _, err := j.conn.Write(j.format(entry)); err != nil {
+		// Connect again next time in case journald was restarted
+
+		j.conn.Close()
+		j.conn = nil
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package logger
+
+// standard imports
+
+import "encoding/json"
+import "os"
+import "strings"
+import "time"
+
+// Local variables
+
+// jsonBackend appends a JSON object per message to a file for log shippers
+
+type jsonBackend struct {
+	fileName string
+	hostName string
+	file     *os.File
+}
+
+type jsonEntry struct {
+	Time     string `json:"time"`
+	Level    string `json:"level"`
+	Host     string `json:"host"`
+	PID      int    `json:"pid"`
+	Database string `json:"database,omitempty"`
+	Script   string `json:"script,omitempty"`
+	Function string `json:"function"`
+	Message  string `json:"message"`
+}
+
+// Local functions
+
+func newJSONBackend(fileName string) (*jsonBackend, error) {
+	// Runs for many databases may share the file. Each line is a single append so lines are not mixed
+
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	hostName, _ := os.Hostname()
+
+	return &jsonBackend{ fileName: fileName, hostName: hostName, file: file }, nil
+}
+
+func (j *jsonBackend) format(entry logEntry) ([]byte, error) {
+	line, err := json.Marshal(jsonEntry{
+		Time     : entry.Time.Format(time.RFC3339Nano),
+		Level    : entry.Level,
+		Host     : j.hostName,
+		PID      : entry.PID,
+		Database : entry.Database,
+		Script   : entry.Script,
+		Function : entry.Function,
+		Message  : entry.Message,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(line, '\n'), nil
+}
+
+func (j *jsonBackend) name() string {
+	return strings.Join( []string{ "json", j.fileName }, " ")
+}
+
+func (j *jsonBackend) close() error {
+	return j.file.Close()
+}
+
+func (j *jsonBackend) write(entry logEntry) error {
+	line, err := j.format(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.file.Write(line)
+
+	return err
+}
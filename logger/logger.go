@@ -26,6 +26,14 @@ var database    string
 var historyFile string
 var scriptName  string
 
+// Size of the backups written by the run. Recorded in the history when known
+
+var historyBytes int64
+
+// Anything else to record about the run e.g. the window a restore test proved
+
+var historyDetail string
+
 var emailServer string
 
 var successEmails []string
@@ -33,6 +41,14 @@ var errorEmails   []string
 
 var currentLog string
 
+// Applied to every message so passwords never reach the logs
+
+var maskFunction func(string) string
+
+// Called with each outcome written to the history e.g. to record metrics
+
+var historyFunction func(string, string, string, time.Duration, int64)
+
 // Local functions
 
 func copyLog(oldLog, newLog string) {
@@ -61,12 +77,20 @@ func copyLog(oldLog, newLog string) {
 	trace2("File copied")
 }
 
+func mask(message string) string {
+	if maskFunction == nil {
+		return message
+	}
+
+	return maskFunction(message)
+}
+
 func info(message string) {
-	rlog.Info(message)
+	rlog.Info(mask(message))
 }
 
 func infof(messageFormat string, message ...interface{}) {
-	rlog.Infof(messageFormat, message)
+	rlog.Info(mask(fmt.Sprintf(messageFormat, message...)))
 }
 
 func trace2(message string) {
@@ -95,6 +119,82 @@ func getFunctionName() string {
         return callingFuncName
 }
 
+func getBaseName() string {
+	baseName, _ := os.Executable()
+	baseName = filepath.Base(baseName)
+	baseSplit := strings.SplitN(baseName,".",2)
+
+	return baseSplit[0]
+}
+
+func openMail(recipientList []string, subject string) (*smtp.Client, io.WriteCloser, error) {
+	// Connect to the SMTP server.
+	emailConnect, err := smtp.Dial(emailServer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to connect to the mail server %s", emailServer)
+	}
+
+	// Set the sender 
+
+	userInfo, err := user.Current()
+	if err != nil {
+		emailConnect.Close()
+		return nil, nil, fmt.Errorf("Unable to get the current user information")
+	}
+
+	hostName, err := os.Hostname()
+	if err != nil {
+		emailConnect.Close()
+		return nil, nil, fmt.Errorf("Unable to get the host name")
+	}
+
+	sender := strings.Join( []string{ userInfo.Username , hostName }, "@" )
+
+	if err := emailConnect.Mail(sender); err != nil {
+		emailConnect.Close()
+		return nil, nil, fmt.Errorf("Unable to set the sender address - %s", sender)
+	}
+
+	for _, receiver := range recipientList {
+		if err := emailConnect.Rcpt(receiver); err != nil {
+			emailConnect.Close()
+			return nil, nil, fmt.Errorf("Unable to set the receiver address - %s", receiver)
+		}
+	}
+
+	// Open up the body writer
+
+	body, err := emailConnect.Data()
+	if err != nil {
+		emailConnect.Close()
+		return nil, nil, fmt.Errorf("Unable to open writer for e-mail")
+	}
+
+	// Set up the To list and subject
+
+	for _, receiver := range recipientList {
+		fmt.Fprintf(body, "To: %s\r\n", receiver)
+	}
+
+	fmt.Fprintf(body, "Subject: %s\r\n\r\n", subject)
+
+	return emailConnect, body, nil
+}
+
+func closeMail(emailConnect *smtp.Client, body io.WriteCloser) error {
+	if err := body.Close(); err != nil {
+		return fmt.Errorf("Unable to close writer - %s", err)
+	}
+
+	// now send the final quit to send the mail
+
+	if err := emailConnect.Quit(); err != nil {
+		return fmt.Errorf("Unable to finalize e-mail - %s", err)
+	}
+
+	return nil
+}
+
 func setConfFile (logConfigFileName string) {
 	//
 	// Check for any Logging Configuration 
@@ -131,13 +231,21 @@ func init () {
 func Info(message string) {
 	callingFuncName := getFunctionName()
 
-	rlog.Infof("%s - %s", callingFuncName, message)
+	masked := mask(message)
+
+	rlog.Infof("%s - %s", callingFuncName, masked)
+
+	send("INFO", callingFuncName, masked)
 }
 
 func Warn(message string) {
 	callingFuncName := getFunctionName()
 
-	rlog.Warnf("%s - %s", callingFuncName, message)
+	masked := mask(message)
+
+	rlog.Warnf("%s - %s", callingFuncName, masked)
+
+	send("WARN", callingFuncName, masked)
 }
 
 func Error(message string) {
@@ -148,7 +256,11 @@ func Error(message string) {
 	os.Setenv("RLOG_LOG_STREAM","stderr")
 	rlog.UpdateEnv()
 
-	rlog.Errorf("%s - %s", callingFuncName, message)
+	masked := mask(message)
+
+	rlog.Errorf("%s - %s", callingFuncName, masked)
+
+	send("ERROR", callingFuncName, masked)
 
 	SendLog("FAILURE")
 
@@ -165,7 +277,11 @@ func Critical(message string) {
 	os.Setenv("RLOG_LOG_STREAM","stderr")
 	rlog.UpdateEnv()
 
-	rlog.Criticalf("%s - %s", callingFuncName, message)
+	masked := mask(message)
+
+	rlog.Criticalf("%s - %s", callingFuncName, masked)
+
+	send("CRITICAL", callingFuncName, masked)
 
 	SendLog("FAILURE")
 
@@ -175,27 +291,37 @@ func Critical(message string) {
 }
 
 func Debug(message string) {
-	rlog.Debug(message)
+	masked := mask(message)
+
+	rlog.Debug(masked)
+
+	if sends("DEBUG") {
+		send("DEBUG", getFunctionName(), masked)
+	}
 }
 
 func Trace(message string) {
-	rlog.Trace(1, message)
+	rlog.Trace(1, mask(message))
 }
 
 func Infof(messageFormat string, message ...interface{}) {
 	callingFuncName := getFunctionName()
 
-	messageFormat = callingFuncName + " - " + messageFormat
+	masked := mask(fmt.Sprintf(messageFormat, message...))
+
+	rlog.Infof("%s - %s", callingFuncName, masked)
 
-	rlog.Infof(messageFormat, message...)
+	send("INFO", callingFuncName, masked)
 }
 
 func Warnf(messageFormat string, message ...interface{}) {
 	callingFuncName := getFunctionName()
 	
-	messageFormat = callingFuncName + " - " + messageFormat
+	masked := mask(fmt.Sprintf(messageFormat, message...))
+
+	rlog.Warnf("%s - %s", callingFuncName, masked)
 
-	rlog.Warnf(messageFormat, message...)
+	send("WARN", callingFuncName, masked)
 }
 
 func Errorf(messageFormat string, message ...interface{}) {
@@ -206,9 +332,11 @@ func Errorf(messageFormat string, message ...interface{}) {
 	os.Setenv("RLOG_LOG_STREAM","stderr")
 	rlog.UpdateEnv()
 
-	messageFormat = callingFuncName + " - " + messageFormat
+	masked := mask(fmt.Sprintf(messageFormat, message...))
 
-	rlog.Errorf(messageFormat, message...)
+	rlog.Errorf("%s - %s", callingFuncName, masked)
+
+	send("ERROR", callingFuncName, masked)
 
 	SendLog("FAILURE")
 
@@ -225,9 +353,11 @@ func Criticalf(messageFormat string, message ...interface{}) {
 	os.Setenv("RLOG_LOG_STREAM","stderr")
 	rlog.UpdateEnv()
 
-	messageFormat = callingFuncName + " - " + messageFormat
+	masked := mask(fmt.Sprintf(messageFormat, message...))
+
+	rlog.Criticalf("%s - %s", callingFuncName, masked)
 
-	rlog.Criticalf(messageFormat, message...)
+	send("CRITICAL", callingFuncName, masked)
 
 	SendLog("FAILURE")
 
@@ -237,11 +367,25 @@ func Criticalf(messageFormat string, message ...interface{}) {
 }
 
 func Debugf(messageFormat string, message ...interface{}) {
-	rlog.Debugf(messageFormat, message...)
+	masked := mask(fmt.Sprintf(messageFormat, message...))
+
+	rlog.Debug(masked)
+
+	if sends("DEBUG") {
+		send("DEBUG", getFunctionName(), masked)
+	}
 }
 
 func Tracef(messageFormat string, message ...interface{}) {
-	rlog.Tracef(1, messageFormat, message...)
+	rlog.Trace(1, mask(fmt.Sprintf(messageFormat, message...)))
+}
+
+func SetMaskFunction(newMaskFunction func(string) string) {
+	maskFunction = newMaskFunction
+}
+
+func SetHistoryFunction(newHistoryFunction func(string, string, string, time.Duration, int64)) {
+	historyFunction = newHistoryFunction
 }
 
 func Initialize(logDir string, logFileName string, logConfigFileName string) {
@@ -348,6 +492,10 @@ func Initialize(logDir string, logFileName string, logConfigFileName string) {
 
 	setConfFile(logConfigFileName)
 
+	// Send messages on to syslog, journald or a JSON file as configured
+
+	setBackends(logConfigFileName, logDir)
+
 	currentLog = logFileName
 }
 
@@ -436,6 +584,18 @@ func SetHistoryVars ( fileName string, db string, funcName string ) {
 	Trace("Process complete")
 }
 	
+func SetHistoryBytes ( backupBytes int64 ) {
+	Tracef("Setting history backup size to %d", backupBytes)
+
+	historyBytes = backupBytes
+}
+
+func SetHistoryDetail ( detail string ) {
+	Tracef("Setting history detail to %s", detail)
+
+	historyDetail = detail
+}
+
 func WriteHistory (status string) {
 	Trace("Writing history file ...")
 
@@ -444,6 +604,14 @@ func WriteHistory (status string) {
 		timeDiff := time.Since(startTime)
 
 		writeString := strings.Join ( []string{ time.Now().Format("2006/01/02:15:04:05"), database, scriptName, strconv.FormatFloat(timeDiff.Seconds(),'f',0,64), status }, " ")
+
+		if historyBytes > 0 || historyDetail != "" {
+			writeString = strings.Join ( []string{ writeString, strconv.FormatInt(historyBytes, 10) }, " ")
+		}
+
+		if historyDetail != "" {
+			writeString = strings.Join ( []string{ writeString, historyDetail }, " ")
+		}
 	
 		Tracef("Writing - %s", writeString)
 
@@ -456,6 +624,10 @@ func WriteHistory (status string) {
 		Tracef("Unable to open file %s - %s", historyFile, err)
 	}
 
+	if historyFunction != nil {
+		historyFunction(database, scriptName, status, time.Since(startTime), historyBytes)
+	}
+
 	Trace("Process complete")
 }
 
@@ -497,60 +669,19 @@ func SendLog (status string) {
 		rlog.SetOutput(os.Stdout)
 		trace2("Redirected output to stderr")
 
-		// Connect to the SMTP server.
-		emailConnect, err := smtp.Dial(emailServer)
-		if err != nil {
-			Errorf("Unable to connect to the mail server %s", emailServer)
-		}
-
-		// Set the sender 
-
-		userInfo, err := user.Current()
-		if err != nil {
-			Error("Unable to get the current user information")
-		}
-
-		hostName, err := os.Hostname()
-		if err != nil {
-			Error("Unable to get the host name")
-		}
-
-		sender := strings.Join( []string{ userInfo.Username , hostName }, "@" )
+		// Set up the header
 
-		if err := emailConnect.Mail(sender); err != nil {
-			Errorf("Unable to set the sender address - %s", sender)
-		}
+		baseName := getBaseName()
 
-		for _, receiver := range recipientList {
-			if err := emailConnect.Rcpt(receiver); err != nil {
-				Errorf("Unable to set the receiver address - %s", receiver)
-			}
-		}
+		timeDiff := time.Since(startTime)
 
-		// Open up the body writer
+		subject := fmt.Sprintf("%s for DB %s. Script %s. Completed with status %s in %0.2f hours", baseName, database, scriptName, status, timeDiff.Hours())
 
-		body, err := emailConnect.Data()
+		emailConnect, body, err := openMail(recipientList, subject)
 		if err != nil {
-			Error("Unable to open writer for e-mail")
+			Errorf("%s", err)
 		}
 
-		// Set up the To list
-
-		for _, receiver := range recipientList {
-			fmt.Fprintf(body, "To: %s\r\n", receiver)
-		}
-
-		// Set up the header
-
-		baseName, err := os.Executable()
-		baseName = filepath.Base(baseName)
-		baseSplit := strings.SplitN(baseName,".",2)
-		baseName = baseSplit[0]
-
-		timeDiff := time.Since(startTime)
-
-		fmt.Fprintf(body, "Subject: %s for DB %s. Script %s. Completed with status %s in %0.2f hours\r\n\r\n", baseName, database, scriptName, status, timeDiff.Hours())
-
 		// Send the log file 
 
 		if currentLogFile, err := os.Open(currentLog); err == nil {
@@ -567,14 +698,36 @@ func SendLog (status string) {
 			Errorf("Unable to open log file %s", currentLog)
 		}
 
-		if err := body.Close(); err != nil {
-			Errorf("Unable to close writer - %s", err)
+		if err := closeMail(emailConnect, body); err != nil {
+			Errorf("%s", err)
 		}
+	}
+}
 
-		// now send the final quit to send the mail
+func NotifyErrors (subject string, message string) {
+	Debug("Notifying error recipients ...")
 
-		if err := emailConnect.Quit(); err != nil {
-			Errorf("Unable to finalize e-mail - %s", err)
-		}
+	// Notifications are advisory so failures are only warned about
+
+	if len(errorEmails) == 0 {
+		Debug("No error recipients set.  Nothing to notify")
+		return
 	}
+
+	emailConnect, body, err := openMail(errorEmails, getBaseName() + " - " + subject)
+	if err != nil {
+		Warnf("Unable to send notification - %s", err)
+		return
+	}
+
+	if _, err := fmt.Fprintf(body, "%s\n", mask(message)); err != nil {
+		Warnf("Unable to write to e-mail body - %s", err)
+	}
+
+	if err := closeMail(emailConnect, body); err != nil {
+		Warnf("Unable to send notification - %s", err)
+		return
+	}
+
+	Debug("Process complete")
 }
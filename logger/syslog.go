@@ -0,0 +1,138 @@
+package logger
+
+// standard imports
+
+import "fmt"
+import "net"
+import "os"
+import "strings"
+
+// Local variables
+
+// syslogBackend sends RFC 5424 messages to the local syslog socket
+
+type syslogBackend struct {
+	socketPath string
+	facility   int
+	hostName   string
+	appName    string
+	conn       net.Conn
+	stream     bool
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{ "CRITICAL": 2, "ERROR": 3, "WARN": 4, "INFO": 6, "DEBUG": 7 }
+
+// Structured data uses the example enterprise number from RFC 5612 as run_rman has none registered
+
+const syslogSDID = "run_rman@32473"
+
+// Local functions
+
+func newSyslogBackend(socketPath string, facilityName string) (*syslogBackend, error) {
+	facility, valid := syslogFacilities[strings.ToLower(facilityName)]
+	if !valid {
+		return nil, fmt.Errorf("unknown syslog facility %s", facilityName)
+	}
+
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = "-"
+	}
+
+	return &syslogBackend{ socketPath: socketPath, facility: facility, hostName: hostName, appName: getBaseName() }, nil
+}
+
+func escapeSDValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+func syslogField(value string) string {
+	// Header fields may not be empty or hold spaces
+
+	if value == "" {
+		return "-"
+	}
+
+	return strings.Replace(value, " ", "_", -1)
+}
+
+func (s *syslogBackend) format(entry logEntry) string {
+	priority := s.facility * 8 + syslogSeverities[entry.Level]
+
+	structuredData := fmt.Sprintf(`[%s database="%s" script="%s" function="%s" level="%s"]`, syslogSDID, escapeSDValue(entry.Database), escapeSDValue(entry.Script), escapeSDValue(entry.Function), entry.Level)
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d - %s %s", priority, entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"), syslogField(s.hostName), syslogField(s.appName), entry.PID, structuredData, entry.Message)
+}
+
+func (s *syslogBackend) connect() error {
+	// /dev/log is normally a datagram socket but some systems use a stream
+
+	var err error
+
+	if s.conn, err = net.Dial("unixgram", s.socketPath); err == nil {
+		s.stream = false
+		return nil
+	}
+
+	if s.conn, err = net.Dial("unix", s.socketPath); err == nil {
+		s.stream = true
+		return nil
+	}
+
+	return err
+}
+
+func (s *syslogBackend) name() string {
+	return strings.Join( []string{ "syslog", s.socketPath }, " ")
+}
+
+func (s *syslogBackend) close() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+
+	s.conn = nil
+
+	return err
+}
+
+func (s *syslogBackend) write(entry logEntry) error {
+	message := s.format(entry)
+
+	var err error
+
+	// Reconnect once in case syslog was restarted
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				return err
+			}
+		}
+
+		// Messages on a stream are ended by a new line
+
+		payload := message
+
+		if s.stream {
+			payload = strings.Join( []string{ message, "\n" }, "")
+		}
+
+		if _, err = s.conn.Write([]byte(payload)); err == nil {
+			return nil
+		}
+
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return err
+}
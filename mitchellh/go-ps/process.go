@@ -7,6 +7,15 @@
 // are interested.
 package ps
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned by Process methods that the current platform
+// does not implement.
+var ErrNotSupported = errors.New("not supported on this platform")
+
 // Process is the generic interface that is implemented on every platform
 // and provides common operations for processes.
 type Process interface {
@@ -19,6 +28,26 @@ type Process interface {
 	// Executable name running this process. This is not a path to the
 	// executable.
 	Executable() string
+
+	// StartTime is the wall clock time the process was started.
+	StartTime() (time.Time, error)
+
+	// CommandLine is the full argument list of the process, including the
+	// program name as the first element.
+	CommandLine() ([]string, error)
+
+	// Uid is the real user ID the process is running as.
+	Uid() (int, error)
+
+	// Username is the name of the user the process is running as.
+	Username() (string, error)
+
+	// State is the single character scheduler state of the process, such
+	// as R (running), S (sleeping) or Z (zombie).
+	State() (rune, error)
+
+	// RSS is the resident set size of the process in bytes.
+	RSS() (int64, error)
 }
 
 // Processes returns all processes.
@@ -38,3 +67,55 @@ func Processes() ([]Process, error) {
 func FindProcess(pid int) (Process, error) {
 	return findProcess(pid)
 }
+
+// Children returns the processes whose parent is the given pid.
+func Children(pid int) ([]Process, error) {
+	ps, err := processes()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Process, 0, 10)
+	for _, p := range ps {
+		if p.PPid() == pid && p.Pid() != pid {
+			results = append(results, p)
+		}
+	}
+
+	return results, nil
+}
+
+// Ancestors returns the chain of parents of the given pid, starting with its
+// immediate parent and ending with the last parent that could be found.
+//
+// Processes will be nil and error will be nil if a matching process is
+// not found.
+func Ancestors(pid int) ([]Process, error) {
+	p, err := findProcess(pid)
+	if p == nil || err != nil {
+		return nil, err
+	}
+
+	results := make([]Process, 0, 10)
+	seen := map[int]bool{pid: true}
+	for {
+		ppid := p.PPid()
+		if ppid <= 0 || seen[ppid] {
+			break
+		}
+		seen[ppid] = true
+
+		// The parent may exit while we are walking the chain
+		p, err = findProcess(ppid)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			break
+		}
+
+		results = append(results, p)
+	}
+
+	return results, nil
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -27,6 +28,30 @@ func (p *DarwinProcess) Executable() string {
 	return p.binary
 }
 
+func (p *DarwinProcess) StartTime() (time.Time, error) {
+	return time.Time{}, ErrNotSupported
+}
+
+func (p *DarwinProcess) CommandLine() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *DarwinProcess) Uid() (int, error) {
+	return -1, ErrNotSupported
+}
+
+func (p *DarwinProcess) Username() (string, error) {
+	return "", ErrNotSupported
+}
+
+func (p *DarwinProcess) State() (rune, error) {
+	return 0, ErrNotSupported
+}
+
+func (p *DarwinProcess) RSS() (int64, error) {
+	return 0, ErrNotSupported
+}
+
 func findProcess(pid int) (Process, error) {
 	ps, err := processes()
 	if err != nil {
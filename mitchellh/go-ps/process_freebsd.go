@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -125,6 +126,30 @@ func (p *UnixProcess) Executable() string {
 	return p.binary
 }
 
+func (p *UnixProcess) StartTime() (time.Time, error) {
+	return time.Time{}, ErrNotSupported
+}
+
+func (p *UnixProcess) CommandLine() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *UnixProcess) Uid() (int, error) {
+	return -1, ErrNotSupported
+}
+
+func (p *UnixProcess) Username() (string, error) {
+	return "", ErrNotSupported
+}
+
+func (p *UnixProcess) State() (rune, error) {
+	return 0, ErrNotSupported
+}
+
+func (p *UnixProcess) RSS() (int64, error) {
+	return 0, ErrNotSupported
+}
+
 // Refresh reloads all the data associated with this process.
 func (p *UnixProcess) Refresh() error {
 
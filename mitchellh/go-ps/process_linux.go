@@ -3,11 +3,22 @@
 package ps
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// clockTicks is the kernel USER_HZ value used to express times in /proc.
+// It is fixed at 100 on all supported architectures and can only be read
+// with sysconf, which would require cgo.
+const clockTicks = 100
+
 // Refresh reloads all the data associated with this process.
 func (p *UnixProcess) Refresh() error {
 	statPath := fmt.Sprintf("/proc/%d/stat", p.pid)
@@ -16,10 +27,11 @@ func (p *UnixProcess) Refresh() error {
 		return err
 	}
 
-	// First, parse out the image name
+	// First, parse out the image name. The name may itself contain
+	// parentheses so the last closing one ends it.
 	data := string(dataBytes)
 	binStart := strings.IndexRune(data, '(') + 1
-	binEnd := strings.IndexRune(data[binStart:], ')')
+	binEnd := strings.LastIndex(data[binStart:], ")")
 	p.binary = data[binStart : binStart+binEnd]
 
 	// Move past the image name and start parsing the rest
@@ -30,6 +42,123 @@ func (p *UnixProcess) Refresh() error {
 		&p.ppid,
 		&p.pgrp,
 		&p.sid)
+	if err != nil {
+		return err
+	}
+
+	// The start time is field 22 and the resident pages field 24 of the
+	// stat file, which are fields 20 and 22 once the pid and image name
+	// have been removed
+	fields := strings.Fields(data)
+	if len(fields) < 22 {
+		return fmt.Errorf("unexpected format in %s", statPath)
+	}
+	p.startTime, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return err
+	}
+	rssPages, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return err
+	}
+	p.rss = rssPages * int64(os.Getpagesize())
+
+	return nil
+}
+
+func (p *UnixProcess) StartTime() (time.Time, error) {
+	bootTime, err := readBootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	offset := time.Duration(p.startTime) * time.Second / clockTicks
+	return bootTime.Add(offset), nil
+}
+
+func (p *UnixProcess) CommandLine() ([]string, error) {
+	cmdPath := fmt.Sprintf("/proc/%d/cmdline", p.pid)
+	dataBytes, err := ioutil.ReadFile(cmdPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Arguments are NUL terminated. Kernel threads have no arguments.
+	dataBytes = bytes.TrimRight(dataBytes, "\x00")
+	if len(dataBytes) == 0 {
+		return []string{}, nil
+	}
+
+	return strings.Split(string(dataBytes), "\x00"), nil
+}
+
+func (p *UnixProcess) Uid() (int, error) {
+	statusPath := fmt.Sprintf("/proc/%d/status", p.pid)
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	// The Uid line holds the real, effective, saved and filesystem ids
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "Uid:" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return -1, err
+	}
+
+	return -1, fmt.Errorf("no Uid entry in %s", statusPath)
+}
+
+func (p *UnixProcess) Username() (string, error) {
+	uid, err := p.Uid()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", err
+	}
+
+	return u.Username, nil
+}
+
+func (p *UnixProcess) State() (rune, error) {
+	return p.state, nil
+}
+
+func (p *UnixProcess) RSS() (int64, error) {
+	return p.rss, nil
+}
+
+// readBootTime returns the time the system booted from /proc/stat.
+func readBootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			btime, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(btime, 0), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
 
-	return err
+	return time.Time{}, fmt.Errorf("no btime entry in /proc/stat")
 }
@@ -0,0 +1,120 @@
+// +build linux
+
+package ps
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUnixProcess_StartTime(t *testing.T) {
+	p, err := FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	start, err := p.StartTime()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Allow for the boot time only having a resolution of one second
+	if start.After(time.Now().Add(time.Second)) {
+		t.Fatalf("bad: %s", start)
+	}
+	if time.Since(start) > time.Hour {
+		t.Fatalf("bad: %s", start)
+	}
+}
+
+func TestUnixProcess_CommandLine(t *testing.T) {
+	p, err := FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	args, err := p.CommandLine()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(args) != len(os.Args) {
+		t.Fatalf("bad: %#v", args)
+	}
+	for i := range args {
+		if args[i] != os.Args[i] {
+			t.Fatalf("bad: %#v", args)
+		}
+	}
+}
+
+func TestUnixProcess_User(t *testing.T) {
+	p, err := FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	uid, err := p.Uid()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if uid != os.Getuid() {
+		t.Fatalf("bad: %d", uid)
+	}
+
+	// The user may not have a passwd entry in minimal containers
+	if _, err := p.Username(); err != nil {
+		t.Logf("username lookup failed: %s", err)
+	}
+}
+
+func TestUnixProcess_StateRSS(t *testing.T) {
+	p, err := FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	state, err := p.State()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if state != 'R' && state != 'S' {
+		t.Fatalf("bad: %c", state)
+	}
+
+	rss, err := p.RSS()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if rss <= 0 {
+		t.Fatalf("bad: %d", rss)
+	}
+}
+
+func TestAncestorsChildren(t *testing.T) {
+	ancestors, err := Ancestors(os.Getpid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(ancestors) == 0 || ancestors[0].Pid() != os.Getppid() {
+		t.Fatalf("bad: %#v", ancestors)
+	}
+
+	children, err := Children(os.Getppid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	found := false
+	for _, c := range children {
+		if c.Pid() == os.Getpid() {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		t.Fatal("should have current process as child of parent")
+	}
+}
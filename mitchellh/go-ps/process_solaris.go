@@ -6,8 +6,21 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+	"time"
+	"unsafe"
 )
 
+// nativeEndian is the byte order of psinfo on this host. SPARC is big
+// endian and x86 is little endian.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
 type ushort_t uint16
 
 type id_t int32
@@ -76,16 +89,41 @@ func (p *UnixProcess) Refresh() error {
 	}
 	defer fh.Close()
 
-	err = binary.Read(fh, binary.LittleEndian, &psinfo)
+	err = binary.Read(fh, nativeEndian, &psinfo)
 	if err != nil {
 		return err
 	}
 
 	p.ppid = int(psinfo.Pr_ppid)
+	p.startTime = nativeEndian.Uint64(psinfo.Pr_start[:8])
 	p.binary = toString(psinfo.Pr_fname[:], 16)
 	return nil
 }
 
+func (p *UnixProcess) StartTime() (time.Time, error) {
+	return time.Time{}, ErrNotSupported
+}
+
+func (p *UnixProcess) CommandLine() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *UnixProcess) Uid() (int, error) {
+	return -1, ErrNotSupported
+}
+
+func (p *UnixProcess) Username() (string, error) {
+	return "", ErrNotSupported
+}
+
+func (p *UnixProcess) State() (rune, error) {
+	return 0, ErrNotSupported
+}
+
+func (p *UnixProcess) RSS() (int64, error) {
+	return 0, ErrNotSupported
+}
+
 func toString(array []byte, len int) string {
 	for i := 0; i < len; i++ {
 		if array[i] == 0 {
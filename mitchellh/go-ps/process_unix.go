@@ -18,6 +18,9 @@ type UnixProcess struct {
 	pgrp  int
 	sid   int
 
+	startTime uint64
+	rss       int64
+
 	binary string
 }
 
@@ -33,6 +36,13 @@ func (p *UnixProcess) Executable() string {
 	return p.binary
 }
 
+// StartTicks is the time the process started. On Linux this is expressed in
+// clock ticks since boot and on Solaris in seconds since the epoch. Together
+// with the pid this identifies a process even when pids are reused.
+func (p *UnixProcess) StartTicks() uint64 {
+	return p.startTime
+}
+
 func findProcess(pid int) (Process, error) {
 	dir := fmt.Sprintf("/proc/%d", pid)
 	_, err := os.Stat(dir)
@@ -3,9 +3,31 @@
 package ps
 
 import (
+	"os"
 	"testing"
 )
 
 func TestUnixProcess_impl(t *testing.T) {
 	var _ Process = new(UnixProcess)
 }
+
+func TestUnixProcess_StartTicks(t *testing.T) {
+	p, err := newUnixProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.StartTicks() == 0 {
+		t.Fatal("should have start time")
+	}
+
+	// Start time must be stable across refreshes
+	q, err := newUnixProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.StartTicks() != q.StartTicks() {
+		t.Fatalf("bad: %d != %d", p.StartTicks(), q.StartTicks())
+	}
+}
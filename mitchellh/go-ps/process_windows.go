@@ -5,6 +5,7 @@ package ps
 import (
 	"fmt"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -57,6 +58,30 @@ func (p *WindowsProcess) Executable() string {
 	return p.exe
 }
 
+func (p *WindowsProcess) StartTime() (time.Time, error) {
+	return time.Time{}, ErrNotSupported
+}
+
+func (p *WindowsProcess) CommandLine() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *WindowsProcess) Uid() (int, error) {
+	return -1, ErrNotSupported
+}
+
+func (p *WindowsProcess) Username() (string, error) {
+	return "", ErrNotSupported
+}
+
+func (p *WindowsProcess) State() (rune, error) {
+	return 0, ErrNotSupported
+}
+
+func (p *WindowsProcess) RSS() (int64, error) {
+	return 0, ErrNotSupported
+}
+
 func newWindowsProcess(e *PROCESSENTRY32) *WindowsProcess {
 	// Find when the string ends for decoding
 	end := 0
@@ -347,8 +347,11 @@ func updateConfigFromFile(config *rlogConfig) {
 		case "RLOG_GOROUTINE_ID":
 			config.showGoroutineID = updateIfNeeded(config.showGoroutineID, val, priority)
 		default:
-			rlogIssue("Unknown or illegal setting name in config file %s:%d. Ignored.",
-				settingConfFile, i)
+			// Settings for other loggers may share the file
+			if strings.HasPrefix(name, "RLOG_") {
+				rlogIssue("Unknown or illegal setting name in config file %s:%d. Ignored.",
+					settingConfFile, i)
+			}
 		}
 	}
 }
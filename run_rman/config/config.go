@@ -18,19 +18,57 @@ import "github.com/daviesluke/utils"
 // Initially set the defaults
 
 var ConfigValues = map[string]string {
-	"LogKeepTime"       : "14",
-	"NLS_DATE_FORMAT"   : "DD_MON_YYYY HH24:MI:SS",
-	"OraTabPath"        : "/etc/oratab:/var/opt/oracle/oratab",
-	"RMANConfig"        : "",
-	"CatalogConnection" : "",
-	"TargetConnection"  : "/",
-	"CheckLockMins"     : "5",
-	"CheckResourceMins" : "5",
-	"ParallelSlaves"    : "1",
-	"ChannelDevice"     : "DISK",
-	"FileFormat"        : "",
-	"RMANIgnoreCodes"   : "",
-	"EmailServer"       : "localhost:25",
+	"LogKeepTime"           : "14",
+	"NLS_DATE_FORMAT"       : "DD_MON_YYYY HH24:MI:SS",
+	"OraTabPath"            : "/etc/oratab:/var/opt/oracle/oratab",
+	"EnvFile"               : "",
+	"RMANHome"              : "",
+	"MetricsDir"            : "",
+	"MetricsPushURL"        : "",
+	"RMANConfig"            : "",
+	"CatalogConnection"     : "",
+	"TargetConnection"      : "/",
+	"CheckLockMins"         : "5",
+	"CheckResourceMins"     : "5",
+	"ParallelSlaves"        : "1",
+	"ChannelDevice"         : "DISK",
+	"FileFormat"            : "",
+	"RMANIgnoreCodes"       : "",
+	"EmailServer"           : "localhost:25",
+	"CoordinationBackend"   : "local",
+	"CoordinationDir"       : "",
+	"CoordinationLeaseSecs" : "300",
+	"HeartbeatSecs"         : "60",
+	"LeaseExpiryMins"       : "0",
+	"BreakExpiredLeases"    : "N",
+	"CredentialFile"        : "",
+	"CredentialCommand"     : "",
+	"VaultFile"             : "",
+	"VaultKeyFile"          : "",
+	"ConnectTimeoutSecs"    : "30",
+	"ConnectRetries"        : "2",
+	"ConnectRetryDelaySecs" : "10",
+	"PreflightChecks"       : "",
+	"SpaceMarginPct"        : "10",
+	"FRAMaxPct"             : "90",
+	"RunOnRole"             : "ANY",
+	"ReportDays"            : "7",
+	"RestoreTestDatafiles"  : "0",
+	"RestoreTestScratch"    : "",
+	"AuxiliaryConnection"   : "",
+	"DuplicateDatabase"     : "",
+	"DuplicateUntilTime"    : "",
+	"DuplicatePreSQL"       : "",
+	"DuplicatePostSQL"      : "",
+	"WatchDatabases"        : "",
+	"WatchPollSecs"         : "300",
+	"WatchFRAPct"           : "80",
+	"WatchArchiveMB"        : "0",
+	"WatchMinIntervalMins"  : "60",
+	"ScheduleFile"          : "",
+	"ScheduleCatchUp"       : "ONCE",
+	"ScheduleCatchUpHours"  : "24",
+	"FanOutParallel"        : "2",
 }
 
 var ConfigFileValues      map[string]string
@@ -0,0 +1,103 @@
+package coordinator
+
+// Standard imports
+
+import "database/sql"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/run_rman/config"
+
+// Global Variables
+
+//
+// A Backend holds named sets of entries used by the locker and resource packages.
+// A set name is the full path of the file that would hold the entries on the local host.
+// Backends shared between hosts only use the base name of the set.
+//
+// Lock and Unlock give exclusive access to a set while it is read and changed.
+// Only one set is locked at a time by a process so the heartbeat can safely lock sets too.
+// Start and Stop register the current process with the backend so that other hosts
+// can tell whether it is still alive.
+//
+
+type Backend interface {
+	Start()
+	Stop()
+	Lock(setName string, waitSecs int)
+	Unlock(setName string)
+	ReadEntries(setName string) []string
+	AddEntry(setName string, entry string)
+	RemoveEntry(setName string, entry string)
+	ReplaceEntry(setName string, oldEntry string, newEntry string) bool
+	ListSets(prefix string) []string
+	CheckProcess(processEntry string, processName string) (bool, bool)
+}
+
+// The local file backend is used until Initialize is called
+
+var Current Backend = NewLocalBackend()
+
+// Local functions
+
+func getLeaseTime() time.Duration {
+	logger.Debug("Getting lease time ...")
+
+	leaseSecs, err := strconv.Atoi(config.ConfigValues["CoordinationLeaseSecs"])
+	if err != nil || leaseSecs <= 0 {
+		logger.Errorf("CoordinationLeaseSecs must be a positive integer - %s", config.ConfigValues["CoordinationLeaseSecs"])
+	}
+
+	logger.Debugf("Lease time set to %d seconds", leaseSecs)
+
+	return time.Duration(leaseSecs) * time.Second
+}
+
+func sharedSetName(setName string) string {
+	return filepath.Base(setName)
+}
+
+// Global functions
+
+func Initialize(openDB func() *sql.DB) {
+	logger.Info("Setting coordination backend ...")
+
+	backendName := strings.ToLower(config.ConfigValues["CoordinationBackend"])
+
+	if backendName == "" || backendName == "local" {
+		Current = NewLocalBackend()
+	} else if backendName == "shared" {
+		if config.ConfigValues["CoordinationDir"] == "" {
+			logger.Errorf("CoordinationDir must be set for the shared coordination backend")
+		}
+
+		Current = NewSharedBackend(config.ConfigValues["CoordinationDir"], getLeaseTime())
+	} else if backendName == "database" {
+		Current = NewDatabaseBackend(openDB(), getLeaseTime())
+	} else {
+		logger.Errorf("Invalid CoordinationBackend %s - must be local, shared or database", config.ConfigValues["CoordinationBackend"])
+	}
+
+	logger.Infof("Coordination backend set to %s", backendName)
+
+	Current.Start()
+
+	StartHeartbeat()
+
+	logger.Info("Process complete")
+}
+
+func Shutdown() {
+	logger.Info("Shutting down coordination ...")
+
+	StopHeartbeat()
+
+	Current.Stop()
+
+	logger.Info("Process complete")
+}
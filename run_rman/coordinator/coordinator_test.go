@@ -0,0 +1,476 @@
+package coordinator
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/setup"
+	"github.com/daviesluke/utils"
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/run_rman/internal/testdb"
+)
+
+func init() {
+	setup.CurrentPID = fmt.Sprintf("%d", os.Getpid())
+	setup.HostName, _ = os.Hostname()
+	setup.RunID = "11111111-1111-4111-8111-111111111111"
+	setup.ProcessEntry = fmt.Sprintf("%d:1:%s:%s", os.Getpid(), setup.HostName, setup.RunID)
+	setup.BaseName = "run_rman"
+
+	tables := &testTables{}
+
+	sql.Register("coordinatortest", &testdb.Driver{Exec: tables.exec, Query: tables.query})
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "coordinator")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return dir
+}
+
+// testBackend runs the operations common to all backends against a set
+func testBackend(t *testing.T, b Backend, setName string) {
+	b.Lock(setName, 1)
+	b.AddEntry(setName, "TAPE:2")
+	b.AddEntry(setName, "DISK:1")
+	b.AddEntry(setName, "TAPE:2")
+	b.Unlock(setName)
+
+	if entries := b.ReadEntries(setName); !reflect.DeepEqual(entries, []string{"TAPE:2", "DISK:1", "TAPE:2"}) {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	// Only the first matching entry is removed
+	b.Lock(setName, 1)
+	b.RemoveEntry(setName, "TAPE:2")
+	b.Unlock(setName)
+
+	if entries := b.ReadEntries(setName); !reflect.DeepEqual(entries, []string{"DISK:1", "TAPE:2"}) {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	// Only the first matching entry is replaced
+	b.Lock(setName, 1)
+	replaced := b.ReplaceEntry(setName, "TAPE:2", "TAPE:3")
+	missing  := b.ReplaceEntry(setName, "TAPE:9", "TAPE:1")
+	b.Unlock(setName)
+
+	if !replaced || missing {
+		t.Fatalf("bad: %t %t", replaced, missing)
+	}
+
+	if entries := b.ReadEntries(setName); !reflect.DeepEqual(entries, []string{"DISK:1", "TAPE:3"}) {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	b.Lock(setName, 1)
+	b.RemoveEntry(setName, "DISK:1")
+	b.RemoveEntry(setName, "TAPE:3")
+	b.Unlock(setName)
+
+	if entries := b.ReadEntries(setName); len(entries) != 0 {
+		t.Fatalf("bad: %#v", entries)
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	b := NewLocalBackend()
+	b.Start()
+	defer b.Stop()
+
+	setName := filepath.Join(dir, "run_rman.resources.used")
+	testBackend(t, b, setName)
+
+	if _, err := os.Stat(setName); !os.IsNotExist(err) {
+		t.Fatalf("empty set should be removed: %s", err)
+	}
+
+	if _, err := os.Stat(setName + ".locker"); !os.IsNotExist(err) {
+		t.Fatalf("lock should be released: %s", err)
+	}
+
+	obtained := filepath.Join(dir, "run_rman.resources.obtained.")
+	b.AddEntry(obtained+setup.RunID, "#"+setup.ProcessEntry)
+	b.AddEntry(obtained+setup.RunID+".123", "temp")
+
+	if sets := b.ListSets(obtained); !reflect.DeepEqual(sets, []string{obtained + setup.RunID}) {
+		t.Fatalf("bad: %#v", sets)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	oldCurrent := Current
+	defer func() { Current = oldCurrent }()
+
+	Current = NewLocalBackend()
+	config.ConfigValues["LeaseExpiryMins"] = "30"
+	defer func() { config.ConfigValues["LeaseExpiryMins"] = "0" }()
+
+	setName := filepath.Join(dir, "run_rman.lock")
+
+	entry := AddHeartbeat(setName, setup.ProcessEntry)
+	defer RemoveHeartbeat(setName)
+
+	if _, expired := LeaseExpired(entry); expired {
+		t.Fatalf("fresh entry should not be expired: %s", entry)
+	}
+
+	// Pretend the heartbeat has not been refreshed for an hour
+	staleEntry := utils.SetEntryHeartbeat(setup.ProcessEntry, time.Now().Add(-time.Hour)) + " lockname"
+	heartbeatEntries[setName] = staleEntry
+	Current.AddEntry(setName, staleEntry)
+
+	if leaseAge, expired := LeaseExpired(staleEntry); !expired || leaseAge < time.Hour {
+		t.Fatalf("bad: %s %t", leaseAge, expired)
+	}
+
+	refreshHeartbeats()
+
+	entries := Current.ReadEntries(setName)
+	if len(entries) != 1 || !strings.HasSuffix(entries[0], " lockname") {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	if _, expired := LeaseExpired(entries[0]); expired {
+		t.Fatalf("refreshed entry should not be expired: %s", entries[0])
+	}
+
+	if heartbeatEntries[setName] != entries[0] {
+		t.Fatalf("bad: %s", heartbeatEntries[setName])
+	}
+}
+
+func TestSharedBackend(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	b := NewSharedBackend(dir, 3*time.Second)
+	b.Start()
+
+	if _, err := os.Stat(b.processLease(setup.RunID)); err != nil {
+		t.Fatalf("lease should exist: %s", err)
+	}
+
+	// Sets are shared by base name whatever directory the caller uses
+	testBackend(t, b, "/local/config/run_rman.lock")
+
+	b.AddEntry("/other/config/run_rman.resources.obtained.abc", "#x")
+	if sets := b.ListSets("/local/config/run_rman.resources.obtained."); !reflect.DeepEqual(sets, []string{filepath.Join(dir, "run_rman.resources.obtained.abc")}) {
+		t.Fatalf("bad: %#v", sets)
+	}
+
+	// A lease held by a dead host is broken once it expires
+	leaseFile := filepath.Join(dir, "run_rman.lock.lease")
+	if err := ioutil.WriteFile(leaseFile, []byte("1:1:deadhost:x\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(leaseFile, old, old)
+
+	b.Lock("run_rman.lock", 1)
+	b.Unlock("run_rman.lock")
+
+	if _, err := os.Stat(leaseFile); !os.IsNotExist(err) {
+		t.Fatalf("lease should be released: %s", err)
+	}
+
+	// Processes on other hosts are alive while their lease is refreshed
+	remoteEntry := "1234:99:otherhost:22222222-2222-4222-8222-222222222222"
+	remoteLease := b.processLease("22222222-2222-4222-8222-222222222222")
+	if err := ioutil.WriteFile(remoteLease, []byte(remoteEntry+"\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if alive, isName := b.CheckProcess(remoteEntry, "run_rman"); !alive || !isName {
+		t.Fatal("remote process with current lease should be alive")
+	}
+
+	os.Chtimes(remoteLease, old, old)
+
+	if alive, _ := b.CheckProcess(remoteEntry, "run_rman"); alive {
+		t.Fatal("remote process with expired lease should be dead")
+	}
+
+	// The heartbeat keeps our own lease current
+	os.Chtimes(b.processLease(setup.RunID), old, old)
+	time.Sleep(1500 * time.Millisecond)
+
+	if leaseInfo, err := os.Stat(b.processLease(setup.RunID)); err != nil || b.leaseExpired(leaseInfo) {
+		t.Fatal("lease should be refreshed by heartbeat")
+	}
+
+	b.Stop()
+
+	if _, err := os.Stat(b.processLease(setup.RunID)); !os.IsNotExist(err) {
+		t.Fatalf("lease should be removed: %s", err)
+	}
+}
+
+func TestSharedBreakLease(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	b := NewSharedBackend(dir, 3*time.Second)
+
+	leaseFile := filepath.Join(dir, "run_rman.lock.lease")
+	old := time.Now().Add(-time.Minute)
+
+	if err := ioutil.WriteFile(leaseFile, []byte("1:1:deadhost:x\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	os.Chtimes(leaseFile, old, old)
+
+	staleInfo, err := os.Stat(leaseFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	breakAll := func() int {
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+
+		broken := 0
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if isBroken, err := b.breakLease(leaseFile, staleInfo); err != nil {
+					t.Errorf("err: %s", err)
+				} else if isBroken {
+					mutex.Lock()
+					broken++
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		return broken
+	}
+
+	// Only one of several concurrent breakers removes the expired lease
+	if broken := breakAll(); broken != 1 {
+		t.Fatalf("bad: %d breakers", broken)
+	}
+
+	if _, err := os.Stat(leaseFile); !os.IsNotExist(err) {
+		t.Fatalf("lease should be broken: %s", err)
+	}
+
+	// Breakers that saw the expired lease must not remove a new one taken since
+	if err := ioutil.WriteFile(leaseFile, []byte(setup.ProcessEntry+"\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if broken := breakAll(); broken != 0 {
+		t.Fatalf("bad: %d breakers", broken)
+	}
+
+	if data, err := ioutil.ReadFile(leaseFile); err != nil || string(data) != setup.ProcessEntry+"\n" {
+		t.Fatalf("new lease should be kept: %q %v", data, err)
+	}
+
+	if files, _ := filepath.Glob(leaseFile + ".broken.*"); len(files) != 0 {
+		t.Fatalf("bad: %v", files)
+	}
+
+	// A lease moved aside by mistake is not removed when another has been taken before it is put back
+	brokenFile := leaseFile + ".broken.test"
+
+	if err := os.Rename(leaseFile, brokenFile); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	brokenInfo, _ := os.Stat(brokenFile)
+
+	if err := ioutil.WriteFile(leaseFile, []byte("1:1:thirdhost:y\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := b.restoreLease(brokenFile, brokenInfo, leaseFile); err == nil {
+		t.Fatal("should error")
+	}
+
+	if data, err := ioutil.ReadFile(leaseFile); err != nil || string(data) != "1:1:thirdhost:y\n" {
+		t.Fatalf("new lease should be kept: %q %v", data, err)
+	}
+
+	if _, err := os.Stat(brokenFile); err != nil {
+		t.Fatalf("moved lease should be kept: %s", err)
+	}
+}
+
+func TestSharedUnlockBrokenLease(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	b := NewSharedBackend(dir, 3*time.Second)
+
+	leaseFile := filepath.Join(dir, "run_rman.lock.lease")
+
+	b.Lock("run_rman.lock", 1)
+
+	// Another host broke our lease and took a new one
+	os.Remove(leaseFile)
+
+	if err := ioutil.WriteFile(leaseFile, []byte("1:1:otherhost:z\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b.Unlock("run_rman.lock")
+
+	if data, err := ioutil.ReadFile(leaseFile); err != nil || string(data) != "1:1:otherhost:z\n" {
+		t.Fatalf("other lease should be kept: %q %v", data, err)
+	}
+}
+
+func TestDatabaseBackend(t *testing.T) {
+	db, err := sql.Open("coordinatortest", "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+
+	b := NewDatabaseBackend(db, time.Minute)
+	b.Start()
+
+	testBackend(t, b, "/local/config/run_rman.lock")
+
+	b.AddEntry("/local/config/run_rman.resources.obtained.abc", "#x")
+	if sets := b.ListSets("/other/config/run_rman.resources.obtained."); !reflect.DeepEqual(sets, []string{"run_rman.resources.obtained.abc"}) {
+		t.Fatalf("bad: %#v", sets)
+	}
+
+	remoteEntry := "1234:99:otherhost:22222222-2222-4222-8222-222222222222"
+	if alive, _ := b.CheckProcess(remoteEntry, "run_rman"); alive {
+		t.Fatal("remote process without lease should be dead")
+	}
+
+	if alive, isName := b.CheckProcess(strings.Replace(remoteEntry, "22222222-2222-4222-8222-222222222222", setup.RunID, 1), "run_rman"); !alive || !isName {
+		t.Fatal("remote process with lease should be alive")
+	}
+
+	b.Stop()
+
+	if alive, _ := b.CheckProcess(strings.Replace(remoteEntry, "22222222-2222-4222-8222-222222222222", setup.RunID, 1), "run_rman"); alive {
+		t.Fatal("lease should be removed")
+	}
+}
+
+// testTables is an in memory stand in for the coordination tables. It only
+// understands the statements used by DatabaseBackend.
+type testTables struct {
+	mutex   sync.Mutex
+	nextID  int
+	mutexes map[string]bool
+	entries []testEntry
+	leases  map[string]time.Time
+}
+
+type testEntry struct {
+	setName string
+	entry   string
+}
+
+func (d *testTables) exec(query string, args []driver.Value) (driver.Result, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.leases == nil {
+		d.leases = make(map[string]time.Time)
+		d.mutexes = make(map[string]bool)
+	}
+
+	switch query {
+	case insertMutexSQL:
+		// Lost the race to create the row with another process
+		if d.mutexes[args[0].(string)] {
+			return nil, fmt.Errorf("ORA-00001: unique constraint (RUN_RMAN_MUTEX_PK) violated")
+		}
+		d.mutexes[args[0].(string)] = true
+	case insertEntrySQL:
+		d.entries = append(d.entries, testEntry{args[0].(string), args[1].(string)})
+	case deleteEntrySQL:
+		for i, e := range d.entries {
+			if e.setName == args[0].(string) && e.entry == args[1].(string) {
+				d.entries = append(d.entries[:i], d.entries[i+1:]...)
+				break
+			}
+		}
+	case updateEntrySQL:
+		for i, e := range d.entries {
+			if e.setName == args[1].(string) && e.entry == args[2].(string) {
+				d.entries[i].entry = args[0].(string)
+				return driver.RowsAffected(1), nil
+			}
+		}
+		return driver.RowsAffected(0), nil
+	case insertLeaseSQL, updateLeaseSQL:
+		d.leases[args[0].(string)] = time.Now()
+	case deleteLeaseSQL:
+		delete(d.leases, args[0].(string))
+	default:
+		return nil, fmt.Errorf("unexpected statement %s", query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (d *testTables) query(query string, args []driver.Value) ([][]driver.Value, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var rows [][]driver.Value
+
+	switch {
+	case strings.HasPrefix(query, "SELECT set_name FROM run_rman_mutex"):
+		rows = append(rows, []driver.Value{args[0]})
+	case query == selectEntriesSQL:
+		for _, e := range d.entries {
+			if e.setName == args[0].(string) {
+				rows = append(rows, []driver.Value{e.entry})
+			}
+		}
+	case query == selectSetsSQL:
+		prefix := args[0].(string)
+		if args[1].(string) != prefix {
+			return nil, fmt.Errorf("prefix bound as %s and %s", args[0], args[1])
+		}
+		seen := make(map[string]bool)
+		for _, e := range d.entries {
+			if strings.HasPrefix(e.setName, prefix) && !seen[e.setName] {
+				seen[e.setName] = true
+				rows = append(rows, []driver.Value{e.setName})
+			}
+		}
+	case query == checkLeaseSQL:
+		count := int64(0)
+		if heartbeat, ok := d.leases[args[0].(string)]; ok && time.Since(heartbeat) < time.Duration(args[1].(int64))*time.Second {
+			count = 1
+		}
+		rows = append(rows, []driver.Value{count})
+	default:
+		return nil, fmt.Errorf("unexpected query %s", query)
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,328 @@
+package coordinator
+
+// Standard imports
+
+import "database/sql"
+import "fmt"
+import "strings"
+import "sync"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+
+// Global Variables
+
+//
+// DatabaseBackend keeps each set as rows in a table in the catalog database.
+//
+// Sets are locked with SELECT ... FOR UPDATE on a row per set, held until Unlock commits.
+// Each process keeps a lease row with a heartbeat so that other hosts can tell it is alive.
+// The tables are created by deploy/sql/run_rman_coordination.sql
+//
+
+type DatabaseBackend struct {
+	db        *sql.DB
+	leaseTime time.Duration
+
+	lockMutex sync.Mutex
+	mutex     sync.Mutex
+	held      map[string]*sql.Tx
+	stop      chan bool
+	stopped   sync.WaitGroup
+}
+
+// Statements used by the backend
+
+const (
+	insertMutexSQL   string = "INSERT INTO run_rman_mutex (set_name) SELECT :1 FROM dual WHERE NOT EXISTS (SELECT 1 FROM run_rman_mutex WHERE set_name = :2)"
+	lockMutexSQL     string = "SELECT set_name FROM run_rman_mutex WHERE set_name = :1 FOR UPDATE WAIT %d"
+	selectEntriesSQL string = "SELECT entry FROM run_rman_entries WHERE set_name = :1 ORDER BY entry_id"
+	insertEntrySQL   string = "INSERT INTO run_rman_entries (entry_id, set_name, entry) VALUES (run_rman_entry_seq.NEXTVAL, :1, :2)"
+	deleteEntrySQL   string = "DELETE FROM run_rman_entries WHERE set_name = :1 AND entry = :2 AND ROWNUM = 1"
+	updateEntrySQL   string = "UPDATE run_rman_entries SET entry = :1 WHERE set_name = :2 AND entry = :3 AND ROWNUM = 1"
+	selectSetsSQL    string = "SELECT DISTINCT set_name FROM run_rman_entries WHERE SUBSTR(set_name, 1, LENGTH(:1)) = :2 ORDER BY set_name"
+	insertLeaseSQL   string = "INSERT INTO run_rman_leases (run_id, process_entry, heartbeat) VALUES (:1, :2, SYSTIMESTAMP)"
+	updateLeaseSQL   string = "UPDATE run_rman_leases SET heartbeat = SYSTIMESTAMP WHERE run_id = :1"
+	deleteLeaseSQL   string = "DELETE FROM run_rman_leases WHERE run_id = :1"
+	checkLeaseSQL    string = "SELECT COUNT(*) FROM run_rman_leases WHERE run_id = :1 AND heartbeat > SYSTIMESTAMP - NUMTODSINTERVAL(:2, 'SECOND')"
+)
+
+// Another process creating the same lock row first is not an error
+
+const uniqueViolation = "ORA-00001"
+
+// Statements run either inside a set lock or on their own
+
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Local functions
+
+func (b *DatabaseBackend) getQueryer(setName string) queryer {
+	b.mutex.Lock()
+	tx, isHeld := b.held[setName]
+	b.mutex.Unlock()
+
+	if isHeld {
+		return tx
+	}
+
+	return b.db
+}
+
+func (b *DatabaseBackend) fail(messageFormat string, message ...interface{}) {
+	// Roll back any set locks before exiting so other processes are not blocked
+
+	b.mutex.Lock()
+	heldTxs := make([]*sql.Tx, 0, len(b.held))
+	for setName, tx := range b.held {
+		heldTxs = append(heldTxs, tx)
+		delete(b.held, setName)
+	}
+	b.mutex.Unlock()
+
+	for _, tx := range heldTxs {
+		tx.Rollback()
+	}
+
+	logger.Errorf(messageFormat, message...)
+}
+
+func (b *DatabaseBackend) heartbeat() {
+	defer b.stopped.Done()
+
+	ticker := time.NewTicker(b.leaseTime / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if _, err := b.db.Exec(updateLeaseSQL, setup.RunID); err != nil {
+				logger.Warnf("Unable to refresh lease for run %s - %s", setup.RunID, err)
+			}
+		}
+	}
+}
+
+// Global functions
+
+func NewDatabaseBackend(db *sql.DB, leaseTime time.Duration) *DatabaseBackend {
+	return &DatabaseBackend{
+		db        : db,
+		leaseTime : leaseTime,
+		held      : make(map[string]*sql.Tx),
+	}
+}
+
+func (b *DatabaseBackend) Start() {
+	logger.Info("Registering with coordination tables ...")
+
+	if _, err := b.db.Exec(insertLeaseSQL, setup.RunID, setup.ProcessEntry); err != nil {
+		logger.Errorf("Unable to register lease for run %s - %s", setup.RunID, err)
+	}
+
+	b.stop = make(chan bool)
+	b.stopped.Add(1)
+
+	go b.heartbeat()
+
+	logger.Info("Process complete")
+}
+
+func (b *DatabaseBackend) Stop() {
+	logger.Info("Deregistering from coordination tables ...")
+
+	if b.stop != nil {
+		close(b.stop)
+		b.stopped.Wait()
+		b.stop = nil
+	}
+
+	if _, err := b.db.Exec(deleteLeaseSQL, setup.RunID); err != nil {
+		logger.Warnf("Unable to remove lease for run %s - %s", setup.RunID, err)
+	}
+
+	logger.Info("Process complete")
+}
+
+func (b *DatabaseBackend) Lock(setName string, waitSecs int) {
+	logger.Infof("Locking %s ...", setName)
+
+	b.lockMutex.Lock()
+
+	dbSetName := sharedSetName(setName)
+
+	if _, err := b.db.Exec(insertMutexSQL, dbSetName, dbSetName); err != nil && !strings.Contains(err.Error(), uniqueViolation) {
+		b.fail("Unable to create lock row for %s - %s", dbSetName, err)
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		b.fail("Unable to start transaction - %s", err)
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(lockMutexSQL, waitSecs), dbSetName)
+	if err != nil {
+		tx.Rollback()
+		b.fail("Unable to lock %s after %d seconds - %s", dbSetName, waitSecs, err)
+	}
+
+	rows.Close()
+
+	b.mutex.Lock()
+	b.held[setName] = tx
+	b.mutex.Unlock()
+
+	logger.Debug("Process complete")
+}
+
+func (b *DatabaseBackend) Unlock(setName string) {
+	logger.Infof("Unlocking %s ...", setName)
+
+	b.mutex.Lock()
+	tx, isHeld := b.held[setName]
+	delete(b.held, setName)
+	b.mutex.Unlock()
+
+	if !isHeld {
+		logger.Warnf("Lock on %s is not held", setName)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		b.fail("Unable to commit changes to %s - %s", setName, err)
+	}
+
+	b.lockMutex.Unlock()
+
+	logger.Debug("Process complete")
+}
+
+func (b *DatabaseBackend) ReadEntries(setName string) []string {
+	logger.Debugf("Reading entries for %s ...", setName)
+
+	var entries []string
+
+	rows, err := b.getQueryer(setName).Query(selectEntriesSQL, sharedSetName(setName))
+	if err != nil {
+		b.fail("Unable to read entries for %s - %s", setName, err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry string
+
+		if err := rows.Scan(&entry); err != nil {
+			b.fail("Unable to read entry for %s - %s", setName, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	logger.Debugf("Returning %d entries", len(entries))
+
+	return entries
+}
+
+func (b *DatabaseBackend) AddEntry(setName string, entry string) {
+	logger.Debugf("Adding entry %s to %s ...", entry, setName)
+
+	if _, err := b.getQueryer(setName).Exec(insertEntrySQL, sharedSetName(setName), entry); err != nil {
+		b.fail("Unable to add entry to %s - %s", setName, err)
+	}
+
+	logger.Debug("Process complete")
+}
+
+func (b *DatabaseBackend) RemoveEntry(setName string, entry string) {
+	logger.Debugf("Removing entry %s from %s ...", entry, setName)
+
+	if _, err := b.getQueryer(setName).Exec(deleteEntrySQL, sharedSetName(setName), entry); err != nil {
+		b.fail("Unable to remove entry from %s - %s", setName, err)
+	}
+
+	logger.Debug("Process complete")
+}
+
+func (b *DatabaseBackend) ReplaceEntry(setName string, oldEntry string, newEntry string) bool {
+	logger.Debugf("Replacing entry %s in %s ...", oldEntry, setName)
+
+	result, err := b.getQueryer(setName).Exec(updateEntrySQL, newEntry, sharedSetName(setName), oldEntry)
+	if err != nil {
+		b.fail("Unable to replace entry in %s - %s", setName, err)
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		b.fail("Unable to get rows replaced in %s - %s", setName, err)
+	}
+
+	logger.Debugf("Returning %t", rowCount > 0)
+
+	return rowCount > 0
+}
+
+func (b *DatabaseBackend) ListSets(prefix string) []string {
+	logger.Debugf("Listing sets starting %s ...", prefix)
+
+	var setNames []string
+
+	// Compared as a string as _ in set names would be a wildcard to LIKE
+
+	rows, err := b.db.Query(selectSetsSQL, sharedSetName(prefix), sharedSetName(prefix))
+	if err != nil {
+		b.fail("Unable to list sets - %s", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var setName string
+
+		if err := rows.Scan(&setName); err != nil {
+			b.fail("Unable to read set name - %s", err)
+		}
+
+		setNames = append(setNames, setName)
+	}
+
+	logger.Debugf("Returning %d sets", len(setNames))
+
+	return setNames
+}
+
+func (b *DatabaseBackend) CheckProcess(processEntry string, processName string) (bool, bool) {
+	logger.Debugf("Checking process entry %s ...", processEntry)
+
+	pid, _, hostName, runID := utils.SplitProcessEntry(processEntry)
+
+	// Processes on this host, or from older versions without a run ID, can be checked directly
+
+	if hostName == setup.HostName || runID == "" {
+		return utils.CheckProcess(processEntry, processName)
+	}
+
+	leaseCount := 0
+
+	if err := b.db.QueryRow(checkLeaseSQL, runID, int(b.leaseTime.Seconds())).Scan(&leaseCount); err != nil {
+		b.fail("Unable to check lease for run %s - %s", runID, err)
+	}
+
+	if leaseCount == 0 {
+		logger.Warnf("PID %d on host %s has no current lease", pid, hostName)
+		return false, false
+	}
+
+	logger.Infof("PID %d on host %s holds a current lease", pid, hostName)
+
+	return true, true
+}
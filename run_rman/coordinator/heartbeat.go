@@ -0,0 +1,176 @@
+package coordinator
+
+// Standard imports
+
+import "strconv"
+import "sync"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+import "github.com/daviesluke/run_rman/config"
+
+// local Variables
+
+//
+// Entries held by this process carry a heartbeat that is refreshed in the background.
+// An entry whose heartbeat has not been refreshed within LeaseExpiryMins belongs to a process
+// that is hung or has lost contact, even if the process itself is still alive.
+//
+
+var heartbeatEntries = make(map[string]string)
+var heartbeatMutex   sync.Mutex
+var heartbeatStop    chan bool
+var heartbeatStopped sync.WaitGroup
+
+var reportedEntries  = make(map[string]bool)
+
+// Local functions
+
+func refreshHeartbeats() {
+	logger.Debug("Refreshing heartbeats ...")
+
+	heartbeatMutex.Lock()
+	refreshEntries := make(map[string]string)
+	for setName, entry := range heartbeatEntries {
+		refreshEntries[setName] = entry
+	}
+	heartbeatMutex.Unlock()
+
+	for setName, entry := range refreshEntries {
+		newEntry := utils.SetEntryHeartbeat(entry, time.Now())
+
+		Current.Lock(setName, 10)
+		replaced := Current.ReplaceEntry(setName, entry, newEntry)
+		Current.Unlock(setName)
+
+		heartbeatMutex.Lock()
+		if heartbeatEntries[setName] == entry {
+			if replaced {
+				heartbeatEntries[setName] = newEntry
+			} else {
+				logger.Warnf("Entry %s no longer found in %s. Unable to refresh heartbeat", entry, setName)
+			}
+		}
+		heartbeatMutex.Unlock()
+	}
+
+	logger.Debug("Process complete")
+}
+
+func heartbeat(interval time.Duration) {
+	defer heartbeatStopped.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-heartbeatStop:
+			return
+		case <-ticker.C:
+			refreshHeartbeats()
+		}
+	}
+}
+
+// Global functions
+
+func StartHeartbeat() {
+	logger.Info("Starting heartbeat ...")
+
+	heartbeatSecs, err := strconv.Atoi(config.ConfigValues["HeartbeatSecs"])
+	if err != nil || heartbeatSecs <= 0 {
+		logger.Errorf("HeartbeatSecs must be a positive integer - %s", config.ConfigValues["HeartbeatSecs"])
+	}
+
+	heartbeatStop = make(chan bool)
+	heartbeatStopped.Add(1)
+
+	go heartbeat(time.Duration(heartbeatSecs) * time.Second)
+
+	logger.Infof("Heartbeat refreshed every %d seconds", heartbeatSecs)
+}
+
+func StopHeartbeat() {
+	logger.Info("Stopping heartbeat ...")
+
+	if heartbeatStop != nil {
+		close(heartbeatStop)
+		heartbeatStopped.Wait()
+		heartbeatStop = nil
+	}
+
+	logger.Info("Process complete")
+}
+
+func AddHeartbeat(setName string, entry string) string {
+	logger.Debugf("Adding heartbeat for %s ...", setName)
+
+	heartbeatEntry := utils.SetEntryHeartbeat(entry, time.Now())
+
+	heartbeatMutex.Lock()
+	heartbeatEntries[setName] = heartbeatEntry
+	heartbeatMutex.Unlock()
+
+	logger.Debugf("Returning %s", heartbeatEntry)
+
+	return heartbeatEntry
+}
+
+func RemoveHeartbeat(setName string) {
+	logger.Debugf("Removing heartbeat for %s ...", setName)
+
+	heartbeatMutex.Lock()
+	delete(heartbeatEntries, setName)
+	heartbeatMutex.Unlock()
+}
+
+func LeaseExpired(entry string) (time.Duration, bool) {
+	logger.Debugf("Checking lease for entry %s ...", entry)
+
+	heartbeat, hasHeartbeat := utils.GetEntryHeartbeat(entry)
+
+	if !hasHeartbeat {
+		logger.Debug("Entry has no heartbeat. Unable to check lease")
+		return 0, false
+	}
+
+	leaseAge := time.Since(heartbeat)
+
+	leaseMins, _ := strconv.Atoi(config.ConfigValues["LeaseExpiryMins"])
+
+	if leaseMins <= 0 {
+		logger.Debug("Lease expiry not set")
+		return leaseAge, false
+	}
+
+	expired := leaseAge > time.Duration(leaseMins) * time.Minute
+
+	logger.Debugf("Lease age %s, expired %t", leaseAge, expired)
+
+	return leaseAge, expired
+}
+
+func ReportExpiredLease(setName string, entry string, leaseAge time.Duration) {
+	pid, _, hostName, _ := utils.SplitProcessEntry(entry)
+
+	message := "PID " + strconv.Itoa(pid) + " on host " + hostName + " has not refreshed its entry in " + setName + " for " + leaseAge.Truncate(time.Second).String()
+
+	logger.Warnf("%s. Process may be hung", message)
+
+	// Only notify once per run for each entry
+
+	if !reportedEntries[entry] {
+		reportedEntries[entry] = true
+
+		logger.NotifyErrors("Expired lease on " + setup.HostName, message + ". Entry " + entry)
+	}
+}
+
+func BreakExpiredLeases() bool {
+	return config.ConfigValues["BreakExpiredLeases"] == "Y"
+}
@@ -0,0 +1,191 @@
+package coordinator
+
+// Standard imports
+
+import "bufio"
+import "os"
+import "path/filepath"
+import "regexp"
+import "strings"
+import "sync"
+
+// Local imports
+
+import "github.com/daviesluke/filelock"
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+
+// Global Variables
+
+// LocalBackend keeps each set in a file on the local host, one entry per line
+
+type LocalBackend struct {
+	mutex sync.Mutex
+	held  map[string]bool
+}
+
+// Local functions
+
+func (b *LocalBackend) fail(messageFormat string, message ...interface{}) {
+	// Release any file locks before exiting so other processes are not blocked
+
+	for setName := range b.held {
+		filelock.UnlockFile(setName)
+	}
+
+	logger.Errorf(messageFormat, message...)
+}
+
+func (b *LocalBackend) rewriteEntry(setName string, entry string, newEntry *string) bool {
+	// Rewrite the file replacing the first matching entry, or without it if there is no new entry
+
+	newSetName := strings.Join( []string{ setName, setup.CurrentPID }, ".")
+
+	newSetFile, err := os.OpenFile(newSetName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		b.fail("Unable to create temp file %s", newSetName)
+	}
+
+	found         := false
+	fileWriteSize := 0
+
+	for _, setEntry := range b.ReadEntries(setName) {
+		if !found && setEntry == entry {
+			found = true
+
+			if newEntry == nil {
+				logger.Debugf("Skipping entry %s", setEntry)
+				continue
+			}
+
+			setEntry = *newEntry
+		}
+
+		if bytesWritten, err := newSetFile.WriteString(setEntry+"\n"); err != nil {
+			b.fail("Unable to write to temp file %s", newSetName)
+		} else {
+			fileWriteSize += bytesWritten
+		}
+	}
+
+	newSetFile.Close()
+
+	if err := os.Rename(newSetName, setName); err != nil {
+		b.fail("Unable to move %s to %s", newSetName, setName)
+	}
+
+	// Do not leave empty files behind
+
+	if fileWriteSize == 0 {
+		logger.Debugf("File %s now empty - removing ...", setName)
+		if err := os.Remove(setName); err != nil {
+			b.fail("Unable to remove file %s", setName)
+		}
+	}
+
+	return found
+}
+
+// Global functions
+
+func NewLocalBackend() *LocalBackend {
+	return &LocalBackend{ held: make(map[string]bool) }
+}
+
+func (b *LocalBackend) Start() {
+	logger.Debug("Local backend needs no registration")
+}
+
+func (b *LocalBackend) Stop() {
+	logger.Debug("Local backend needs no deregistration")
+}
+
+func (b *LocalBackend) Lock(setName string, waitSecs int) {
+	b.mutex.Lock()
+
+	filelock.LockFile(setName, waitSecs)
+
+	b.held[setName] = true
+}
+
+func (b *LocalBackend) Unlock(setName string) {
+	if !b.held[setName] {
+		logger.Warnf("Lock on %s is not held", setName)
+		return
+	}
+
+	delete(b.held, setName)
+
+	filelock.UnlockFile(setName)
+
+	b.mutex.Unlock()
+}
+
+func (b *LocalBackend) ReadEntries(setName string) []string {
+	logger.Debugf("Reading entries from file %s ...", setName)
+
+	var entries []string
+
+	if setFile, err := os.Open(setName); err == nil {
+		setScanner := bufio.NewScanner(setFile)
+
+		for setScanner.Scan() {
+			entries = append(entries, setScanner.Text())
+		}
+
+		setFile.Close()
+	} else {
+		logger.Debugf("Unable to open file %s", setName)
+	}
+
+	logger.Debugf("Returning %d entries", len(entries))
+
+	return entries
+}
+
+func (b *LocalBackend) AddEntry(setName string, entry string) {
+	logger.Debugf("Adding entry %s to file %s ...", entry, setName)
+
+	if setFile, err := os.OpenFile(setName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err == nil {
+		if _, err := setFile.WriteString(entry+"\n"); err != nil {
+			b.fail("Unable to write to file %s", setName)
+		}
+
+		setFile.Close()
+	} else {
+		b.fail("Unable to open file %s", setName)
+	}
+
+	logger.Debug("Process complete")
+}
+
+func (b *LocalBackend) RemoveEntry(setName string, entry string) {
+	logger.Debugf("Removing entry %s from file %s ...", entry, setName)
+
+	b.rewriteEntry(setName, entry, nil)
+
+	logger.Debug("Process complete")
+}
+
+func (b *LocalBackend) ReplaceEntry(setName string, oldEntry string, newEntry string) bool {
+	logger.Debugf("Replacing entry %s in file %s ...", oldEntry, setName)
+
+	replaced := b.rewriteEntry(setName, oldEntry, &newEntry)
+
+	logger.Debugf("Returning %t", replaced)
+
+	return replaced
+}
+
+func (b *LocalBackend) ListSets(prefix string) []string {
+	logger.Debugf("Listing files starting %s ...", prefix)
+
+	regEx := strings.Join( []string{ "^", regexp.QuoteMeta(filepath.Base(prefix)), "[^.]+$" }, "")
+
+	return utils.FindFiles(filepath.Dir(prefix), regEx, 0)
+}
+
+func (b *LocalBackend) CheckProcess(processEntry string, processName string) (bool, bool) {
+	return utils.CheckProcess(processEntry, processName)
+}
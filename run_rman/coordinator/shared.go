@@ -0,0 +1,459 @@
+package coordinator
+
+// Standard imports
+
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+
+// Global Variables
+
+//
+// SharedBackend keeps each set in a file in a directory shared between hosts e.g. over NFS.
+//
+// Nothing relies on O_EXCL or O_APPEND, which are not safe on all NFS versions.
+// Sets are locked by linking a unique file to a lease file and changed by renaming a new copy into place.
+// Every lease held is touched by a heartbeat and a lease not touched within the lease time is expired,
+// so a host that dies cannot hold a lock or resource for ever.
+//
+
+type SharedBackend struct {
+	dir       string
+	leaseTime time.Duration
+
+	lockMutex sync.Mutex
+	mutex     sync.Mutex
+	held      map[string]heldLease
+	stop      chan bool
+	stopped   sync.WaitGroup
+}
+
+// heldLease is a lease taken by this process. The file info tells it apart from a lease taken by another
+// host after ours expired
+
+type heldLease struct {
+	leaseFile string
+	leaseInfo os.FileInfo
+}
+
+const leaseSuffix = "lease"
+
+var breakCount uint64
+
+// Local functions
+
+func (b *SharedBackend) setPath(setName string) string {
+	return filepath.Join(b.dir, sharedSetName(setName))
+}
+
+func (b *SharedBackend) processLease(runID string) string {
+	return filepath.Join(b.dir, strings.Join( []string{ runID, leaseSuffix }, "."))
+}
+
+func (b *SharedBackend) fail(messageFormat string, message ...interface{}) {
+	// Release any leases before exiting so other hosts are not blocked
+
+	b.mutex.Lock()
+	heldSets := make([]string, 0, len(b.held))
+	for setName := range b.held {
+		heldSets = append(heldSets, setName)
+	}
+	b.mutex.Unlock()
+
+	for _, setName := range heldSets {
+		b.Unlock(setName)
+	}
+
+	logger.Errorf(messageFormat, message...)
+}
+
+func (b *SharedBackend) leaseExpired(leaseInfo os.FileInfo) bool {
+	return time.Since(leaseInfo.ModTime()) > b.leaseTime
+}
+
+func (b *SharedBackend) ownsLease(lease heldLease) bool {
+	leaseInfo, err := os.Stat(lease.leaseFile)
+	if err != nil || !os.SameFile(lease.leaseInfo, leaseInfo) {
+		return false
+	}
+
+	leaseData, err := ioutil.ReadFile(lease.leaseFile)
+
+	return err == nil && string(leaseData) == setup.ProcessEntry+"\n"
+}
+
+func (b *SharedBackend) restoreLease(brokenFile string, brokenInfo os.FileInfo, leaseFile string) error {
+	// Put back a lease moved aside by mistake. If a new lease has been taken since then both
+	// are kept as removing either would leave a holder without a lease
+
+	// Over NFS link can report failure when it worked so check the files are the same as well
+
+	if err := os.Link(brokenFile, leaseFile); err != nil {
+		if leaseInfo, err := os.Stat(leaseFile); err != nil || !os.SameFile(brokenInfo, leaseInfo) {
+			return fmt.Errorf("Lease %s was taken while restoring it from %s. Both are kept and need checking", leaseFile, brokenFile)
+		}
+	}
+
+	if err := os.Remove(brokenFile); err != nil {
+		logger.Warnf("Unable to remove file %s", brokenFile)
+	}
+
+	return nil
+}
+
+func (b *SharedBackend) breakLease(leaseFile string, leaseInfo os.FileInfo) (bool, error) {
+	// Move the lease aside first so only the lease seen to have expired is ever removed.
+	// Another host may have broken it and taken a new lease since it was checked
+
+	brokenFile := strings.Join( []string{ leaseFile, "broken", setup.HostName, setup.RunID, fmt.Sprintf("%d", atomic.AddUint64(&breakCount, 1)) }, ".")
+
+	if err := os.Rename(leaseFile, brokenFile); err != nil {
+		logger.Debugf("Unable to move lease %s - %s", leaseFile, err)
+		return false, nil
+	}
+
+	brokenInfo, err := os.Stat(brokenFile)
+	if err != nil {
+		return false, fmt.Errorf("Unable to check lease %s moved to %s - %s", leaseFile, brokenFile, err)
+	}
+
+	if os.SameFile(leaseInfo, brokenInfo) && b.leaseExpired(brokenInfo) {
+		if err := os.Remove(brokenFile); err != nil {
+			logger.Warnf("Unable to remove broken lease %s", brokenFile)
+		}
+		return true, nil
+	}
+
+	// Not the expired lease so put it back
+
+	logger.Debugf("Lease %s changed while breaking. Restoring ...", leaseFile)
+
+	return false, b.restoreLease(brokenFile, brokenInfo, leaseFile)
+}
+
+func (b *SharedBackend) heartbeat() {
+	defer b.stopped.Done()
+
+	ticker := time.NewTicker(b.leaseTime / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+
+			b.mutex.Lock()
+			leaseFiles := []string{ b.processLease(setup.RunID) }
+			for _, lease := range b.held {
+				leaseFiles = append(leaseFiles, lease.leaseFile)
+			}
+			b.mutex.Unlock()
+
+			for _, leaseFile := range leaseFiles {
+				if err := os.Chtimes(leaseFile, now, now); err != nil {
+					logger.Warnf("Unable to refresh lease %s - %s", leaseFile, err)
+				}
+			}
+		}
+	}
+}
+
+func (b *SharedBackend) writeSet(setName string, entries []string) {
+	setPath := b.setPath(setName)
+
+	// Write a new copy and rename it into place so readers never see a partial file
+
+	newSetPath := strings.Join( []string{ setPath, setup.HostName, setup.RunID }, ".")
+
+	if len(entries) == 0 {
+		logger.Debugf("File %s now empty - removing ...", setPath)
+		if err := os.Remove(setPath); err != nil && !os.IsNotExist(err) {
+			b.fail("Unable to remove file %s", setPath)
+		}
+		return
+	}
+
+	newSetFile, err := os.OpenFile(newSetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		b.fail("Unable to create temp file %s", newSetPath)
+	}
+
+	if _, err := newSetFile.WriteString(strings.Join(entries, "\n")+"\n"); err != nil {
+		newSetFile.Close()
+		b.fail("Unable to write to temp file %s", newSetPath)
+	}
+
+	if err := newSetFile.Sync(); err != nil {
+		newSetFile.Close()
+		b.fail("Unable to sync temp file %s", newSetPath)
+	}
+
+	newSetFile.Close()
+
+	if err := os.Rename(newSetPath, setPath); err != nil {
+		b.fail("Unable to move %s to %s", newSetPath, setPath)
+	}
+}
+
+func (b *SharedBackend) rewriteEntry(setName string, entry string, newEntry *string) bool {
+	// Replace the first matching entry, or remove it if there is no new entry
+
+	var entries []string
+
+	found := false
+
+	for _, setEntry := range b.ReadEntries(setName) {
+		if !found && setEntry == entry {
+			found = true
+
+			if newEntry == nil {
+				continue
+			}
+
+			setEntry = *newEntry
+		}
+
+		entries = append(entries, setEntry)
+	}
+
+	b.writeSet(setName, entries)
+
+	return found
+}
+
+// Global functions
+
+func NewSharedBackend(dir string, leaseTime time.Duration) *SharedBackend {
+	return &SharedBackend{
+		dir       : dir,
+		leaseTime : leaseTime,
+		held      : make(map[string]heldLease),
+	}
+}
+
+func (b *SharedBackend) Start() {
+	logger.Infof("Registering with shared directory %s ...", b.dir)
+
+	if dirInfo, err := os.Stat(b.dir); err != nil || !dirInfo.IsDir() {
+		logger.Errorf("Shared coordination directory %s is not a directory", b.dir)
+	}
+
+	leaseFile := b.processLease(setup.RunID)
+
+	if err := ioutil.WriteFile(leaseFile, []byte(setup.ProcessEntry+"\n"), 0600); err != nil {
+		logger.Errorf("Unable to write lease file %s", leaseFile)
+	}
+
+	b.stop = make(chan bool)
+	b.stopped.Add(1)
+
+	go b.heartbeat()
+
+	logger.Info("Process complete")
+}
+
+func (b *SharedBackend) Stop() {
+	logger.Info("Deregistering from shared directory ...")
+
+	if b.stop != nil {
+		close(b.stop)
+		b.stopped.Wait()
+		b.stop = nil
+	}
+
+	if err := os.Remove(b.processLease(setup.RunID)); err != nil {
+		logger.Warnf("Unable to remove lease file %s", b.processLease(setup.RunID))
+	}
+
+	logger.Info("Process complete")
+}
+
+func (b *SharedBackend) Lock(setName string, waitSecs int) {
+	logger.Infof("Taking lease on %s ...", setName)
+
+	b.lockMutex.Lock()
+
+	leaseFile  := strings.Join( []string{ b.setPath(setName), leaseSuffix }, ".")
+	uniqueFile := strings.Join( []string{ leaseFile, setup.HostName, setup.RunID }, ".")
+
+	if err := ioutil.WriteFile(uniqueFile, []byte(setup.ProcessEntry+"\n"), 0600); err != nil {
+		b.fail("Unable to write file %s", uniqueFile)
+	}
+
+	defer os.Remove(uniqueFile)
+
+	deadline := time.Now().Add(time.Duration(waitSecs) * time.Second)
+
+	for {
+		// The return from link is unreliable over NFS so check the files are the same instead
+
+		os.Link(uniqueFile, leaseFile)
+
+		uniqueInfo, uniqueErr := os.Stat(uniqueFile)
+		leaseInfo , leaseErr  := os.Stat(leaseFile)
+
+		if uniqueErr == nil && leaseErr == nil && os.SameFile(uniqueInfo, leaseInfo) {
+			logger.Debug("Lease successfully taken")
+
+			b.mutex.Lock()
+			b.held[setName] = heldLease{ leaseFile: leaseFile, leaseInfo: leaseInfo }
+			b.mutex.Unlock()
+
+			break
+		}
+
+		if leaseErr == nil && b.leaseExpired(leaseInfo) {
+			logger.Warnf("Lease %s last refreshed %s has expired. Breaking lease ...", leaseFile, leaseInfo.ModTime().Format("2006/01/02:15:04:05"))
+
+			if _, err := b.breakLease(leaseFile, leaseInfo); err != nil {
+				b.fail("%s", err)
+			}
+
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			b.fail("Unable to take lease %s after %d seconds.  Exiting ...", leaseFile, waitSecs)
+		}
+
+		logger.Debug("Sleeping ...")
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	logger.Debug("Process complete")
+}
+
+func (b *SharedBackend) Unlock(setName string) {
+	logger.Infof("Releasing lease on %s ...", setName)
+
+	b.mutex.Lock()
+	lease, isHeld := b.held[setName]
+	delete(b.held, setName)
+	b.mutex.Unlock()
+
+	if !isHeld {
+		logger.Warnf("Lease on %s is not held", setName)
+		return
+	}
+
+	// Our lease may have expired and been broken by another host which now holds the lease file
+	// The contents are checked as well as a new file can be given the inode of the one removed
+
+	if !b.ownsLease(lease) {
+		logger.Warnf("Lease %s is no longer ours. Leaving it in place", lease.leaseFile)
+	} else if err := os.Remove(lease.leaseFile); err != nil {
+		logger.Warnf("Unable to remove lease file %s", lease.leaseFile)
+	}
+
+	b.lockMutex.Unlock()
+
+	logger.Debug("Process complete")
+}
+
+func (b *SharedBackend) ReadEntries(setName string) []string {
+	logger.Debugf("Reading entries for %s ...", setName)
+
+	var entries []string
+
+	if setData, err := ioutil.ReadFile(b.setPath(setName)); err == nil {
+		for _, entry := range strings.Split(string(setData), "\n") {
+			if entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	} else {
+		logger.Debugf("Unable to read file %s", b.setPath(setName))
+	}
+
+	logger.Debugf("Returning %d entries", len(entries))
+
+	return entries
+}
+
+func (b *SharedBackend) AddEntry(setName string, entry string) {
+	logger.Debugf("Adding entry %s to %s ...", entry, setName)
+
+	b.writeSet(setName, append(b.ReadEntries(setName), entry))
+
+	logger.Debug("Process complete")
+}
+
+func (b *SharedBackend) RemoveEntry(setName string, entry string) {
+	logger.Debugf("Removing entry %s from %s ...", entry, setName)
+
+	b.rewriteEntry(setName, entry, nil)
+
+	logger.Debug("Process complete")
+}
+
+func (b *SharedBackend) ReplaceEntry(setName string, oldEntry string, newEntry string) bool {
+	logger.Debugf("Replacing entry %s in %s ...", oldEntry, setName)
+
+	replaced := b.rewriteEntry(setName, oldEntry, &newEntry)
+
+	logger.Debugf("Returning %t", replaced)
+
+	return replaced
+}
+
+func (b *SharedBackend) ListSets(prefix string) []string {
+	logger.Debugf("Listing sets starting %s ...", prefix)
+
+	var setNames []string
+
+	if dirList, err := ioutil.ReadDir(b.dir); err == nil {
+		for _, fileInfo := range dirList {
+			suffix := strings.TrimPrefix(fileInfo.Name(), sharedSetName(prefix))
+
+			if suffix != fileInfo.Name() && suffix != "" && !strings.Contains(suffix, ".") {
+				setNames = append(setNames, filepath.Join(b.dir, fileInfo.Name()))
+			}
+		}
+	} else {
+		logger.Warnf("Unable to read directory %s", b.dir)
+	}
+
+	logger.Debugf("Returning %d sets", len(setNames))
+
+	return setNames
+}
+
+func (b *SharedBackend) CheckProcess(processEntry string, processName string) (bool, bool) {
+	logger.Debugf("Checking process entry %s ...", processEntry)
+
+	pid, _, hostName, runID := utils.SplitProcessEntry(processEntry)
+
+	// Processes on this host, or from older versions without a run ID, can be checked directly
+
+	if hostName == setup.HostName || runID == "" {
+		return utils.CheckProcess(processEntry, processName)
+	}
+
+	leaseFile := b.processLease(runID)
+
+	if leaseInfo, err := os.Stat(leaseFile); err == nil {
+		if b.leaseExpired(leaseInfo) {
+			logger.Warnf("PID %d on host %s last refreshed its lease %s. Lease expired", pid, hostName, leaseInfo.ModTime().Format("2006/01/02:15:04:05"))
+		} else {
+			logger.Infof("PID %d on host %s holds a current lease", pid, hostName)
+			return true, true
+		}
+	} else {
+		logger.Warnf("PID %d on host %s has no lease file %s", pid, hostName, leaseFile)
+	}
+
+	return false, false
+}
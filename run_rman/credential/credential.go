@@ -0,0 +1,229 @@
+package credential
+
+// Standard imports
+
+import "bufio"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "os/exec"
+import "path/filepath"
+import "regexp"
+import "runtime"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/environment"
+
+// Global Variables
+
+// Provider looks up a connection string by name
+// Connections in the config are given as <scheme>:<name> e.g. TargetConnection=env:RMAN_TARGET
+
+type Provider interface {
+	Resolve(name string) (string, error)
+}
+
+// local Variables
+
+const credentialFileName = "run_rman.credentials"
+
+var providers = map[string]Provider {
+	"env"  : envProvider{},
+	"file" : fileProvider{},
+	"cmd"  : commandProvider{},
+}
+
+var resolvedConnections = make(map[string]string)
+
+// Local functions
+
+// envProvider reads the connection from an environment variable
+
+type envProvider struct{}
+
+func (p envProvider) Resolve(name string) (string, error) {
+	connection := os.Getenv(name)
+
+	if connection == "" {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return connection, nil
+}
+
+// fileProvider reads NAME=connection lines from a file only the owner can read
+
+type fileProvider struct{}
+
+func (p fileProvider) Resolve(name string) (string, error) {
+	fileName := config.ConfigValues["CredentialFile"]
+
+	if fileName == "" {
+		fileName = filepath.Join(setup.ConfigDir, credentialFileName)
+	}
+
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return "", fmt.Errorf("unable to find credentials file %s", fileName)
+	}
+
+	// Windows does not have unix permissions so rely on the file ACLs there
+
+	if runtime.GOOS != "windows" && fileInfo.Mode().Perm() & 0077 != 0 {
+		return "", fmt.Errorf("credentials file %s has permissions %04o and must be 0600", fileName, fileInfo.Mode().Perm())
+	}
+
+	credentialFile, err := os.Open(fileName)
+	if err != nil {
+		return "", fmt.Errorf("unable to open credentials file %s", fileName)
+	}
+
+	defer credentialFile.Close()
+
+	credentialScanner := bufio.NewScanner(credentialFile)
+
+	for credentialScanner.Scan() {
+		credentialLine := strings.TrimSpace(credentialScanner.Text())
+
+		if credentialLine == "" || credentialLine[0] == '#' {
+			continue
+		}
+
+		credentialTokens := strings.SplitN(credentialLine, "=", 2)
+
+		if len(credentialTokens) == 2 && strings.TrimSpace(credentialTokens[0]) == name {
+			return strings.TrimSpace(credentialTokens[1]), nil
+		}
+	}
+
+	return "", fmt.Errorf("credential %s not found in %s", name, fileName)
+}
+
+// commandProvider runs CredentialCommand with the name as its last argument and uses what it prints
+
+type commandProvider struct{}
+
+func (p commandProvider) Resolve(name string) (string, error) {
+	commandTokens := strings.Fields(config.ConfigValues["CredentialCommand"])
+
+	if len(commandTokens) == 0 {
+		return "", fmt.Errorf("CredentialCommand is not set")
+	}
+
+	commandOutput, err := exec.Command(commandTokens[0], append(commandTokens[1:], name)...).Output()
+	if err != nil {
+		return "", fmt.Errorf("credential command %s failed - %s", commandTokens[0], err)
+	}
+
+	connection := strings.TrimSpace(string(commandOutput))
+
+	if connection == "" {
+		return "", fmt.Errorf("credential command %s returned nothing for %s", commandTokens[0], name)
+	}
+
+	return connection, nil
+}
+
+func splitReference(connection string) (string, string, bool) {
+	// A reference is a registered scheme followed by a name
+	// Connection strings such as user/pass@host:1521/service are left alone
+
+	referenceTokens := strings.SplitN(connection, ":", 2)
+
+	if len(referenceTokens) != 2 || referenceTokens[1] == "" {
+		return "", "", false
+	}
+
+	if _, found := providers[referenceTokens[0]]; !found {
+		return "", "", false
+	}
+
+	return referenceTokens[0], referenceTokens[1], true
+}
+
+func checkWallet(connection string) {
+	logger.Debug("Checking for an Oracle wallet ...")
+
+	// Connections such as /@ALIAS take their credentials from the secure external password store
+
+	if !strings.HasPrefix(connection, "/@") {
+		logger.Debug("Not a wallet connection")
+		return
+	}
+
+	tnsAdmin := environment.Get("TNS_ADMIN")
+
+	if tnsAdmin == "" && environment.Get("ORACLE_HOME") != "" {
+		tnsAdmin = filepath.Join(environment.Get("ORACLE_HOME"), "network", "admin")
+	}
+
+	sqlnetFileName := filepath.Join(tnsAdmin, "sqlnet.ora")
+
+	sqlnetContents, err := ioutil.ReadFile(sqlnetFileName)
+	if tnsAdmin == "" || err != nil {
+		logger.Warnf("Unable to read %s to check the wallet for %s", sqlnetFileName, connection)
+		return
+	}
+
+	walletOverride := regexp.MustCompile(`(?im)^\s*SQLNET\.WALLET_OVERRIDE\s*=\s*TRUE`)
+	walletLocation := regexp.MustCompile(`(?im)^\s*WALLET_LOCATION\s*=`)
+
+	if walletOverride.Match(sqlnetContents) && walletLocation.Match(sqlnetContents) {
+		logger.Infof("Using Oracle wallet (SEPS) configured in %s for %s", sqlnetFileName, connection)
+	} else {
+		logger.Warnf("Connection %s needs WALLET_LOCATION and SQLNET.WALLET_OVERRIDE=TRUE in %s", connection, sqlnetFileName)
+	}
+}
+
+// Global functions
+
+func Register(scheme string, provider Provider) {
+	providers[scheme] = provider
+}
+
+func Resolve(connection string) (string, error) {
+	scheme, name, isReference := splitReference(connection)
+
+	if !isReference {
+		return connection, nil
+	}
+
+	return providers[scheme].Resolve(name)
+}
+
+func Connection(configName string) string {
+	logger.Debugf("Getting connection for %s ...", configName)
+
+	configValue := config.ConfigValues[configName]
+
+	if resolvedConnection, found := resolvedConnections[configValue]; found {
+		return resolvedConnection
+	}
+
+	connection, err := Resolve(configValue)
+	if err != nil {
+		logger.Errorf("Unable to resolve %s from %s - %s", configName, configValue, err)
+	}
+
+	if connection != configValue {
+		logger.Infof("Resolved %s from %s", configName, configValue)
+	}
+
+	// Make sure the password can never be logged
+
+	utils.AddConnectionSecret(connection)
+
+	checkWallet(connection)
+
+	resolvedConnections[configValue] = connection
+
+	logger.Debug("Process complete")
+
+	return connection
+}
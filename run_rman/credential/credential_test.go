@@ -0,0 +1,149 @@
+package credential
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/utils"
+)
+
+func TestResolvePlain(t *testing.T) {
+	for _, connection := range []string{"/", "/@PROD", "rman/secret@cat", "rman/secret@host:1521/svc", "nosuch:thing"} {
+		resolved, err := Resolve(connection)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if resolved != connection {
+			t.Fatalf("bad: %s -> %s", connection, resolved)
+		}
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	os.Setenv("RUN_RMAN_TEST_CONN", "rman/envpass@cat")
+	defer os.Unsetenv("RUN_RMAN_TEST_CONN")
+
+	resolved, err := Resolve("env:RUN_RMAN_TEST_CONN")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if resolved != "rman/envpass@cat" {
+		t.Fatalf("bad: %s", resolved)
+	}
+
+	if _, err := Resolve("env:RUN_RMAN_TEST_MISSING"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "creds")
+
+	if err := ioutil.WriteFile(fileName, []byte("# comment\nCATALOG = rman/filepass@cat\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config.ConfigValues["CredentialFile"] = fileName
+	defer func() { config.ConfigValues["CredentialFile"] = "" }()
+
+	resolved, err := Resolve("file:CATALOG")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if resolved != "rman/filepass@cat" {
+		t.Fatalf("bad: %s", resolved)
+	}
+
+	if _, err := Resolve("file:MISSING"); err == nil {
+		t.Fatal("should error")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// Anyone else being able to read the file is refused
+	os.Chmod(fileName, 0644)
+
+	if _, err := Resolve("file:CATALOG"); err == nil || !strings.Contains(err.Error(), "0600") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestResolveCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs echo")
+	}
+
+	config.ConfigValues["CredentialCommand"] = "echo rman/cmdpass@"
+	defer func() { config.ConfigValues["CredentialCommand"] = "" }()
+
+	resolved, err := Resolve("cmd:cat")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if resolved != "rman/cmdpass@ cat" {
+		t.Fatalf("bad: %s", resolved)
+	}
+
+	config.ConfigValues["CredentialCommand"] = ""
+
+	if _, err := Resolve("cmd:cat"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestMaskPasswords(t *testing.T) {
+	utils.AddConnectionSecret("rman/Sup3rSecret@cat")
+
+	for _, text := range []string{
+		"connect catalog rman/Sup3rSecret@cat;",
+		"CONNECT TARGET sys/other@prod",
+		"Connection string -> sys/other@prod",
+		"sqlplus -s rman/Sup3rSecret as sysdba",
+		"connect target 'rman/Sup3rSecret'",
+	} {
+		masked := utils.MaskPasswords(text)
+
+		if strings.Contains(masked, "Sup3rSecret") || strings.Contains(masked, "other") {
+			t.Fatalf("bad: %s -> %s", text, masked)
+		}
+	}
+
+	// Secrets are only masked as a password so other text is left alone
+	for _, text := range []string{
+		"Directory /u01/Sup3rSecretBackups",
+		"Password policy Sup3rSecret",
+	} {
+		if masked := utils.MaskPasswords(text); masked != text {
+			t.Fatalf("bad: %s -> %s", text, masked)
+		}
+	}
+
+	// Short passwords and ordinary words are masked too as they only match after user/
+	utils.AddConnectionSecret("sys/oracle@prod")
+	utils.AddConnectionSecret("sys/abc@prod")
+
+	if masked := utils.MaskPasswords("sqlplus -s sys/oracle as sysdba; sqlplus -s sys/abc as sysdba"); masked != "sqlplus -s sys/***** as sysdba; sqlplus -s sys/***** as sysdba" {
+		t.Fatalf("bad: %s", masked)
+	}
+
+	if text := "ORACLE_BASE /u01/app/oracle_base has abc files"; utils.MaskPasswords(text) != text {
+		t.Fatalf("bad: %s", utils.MaskPasswords(text))
+	}
+}
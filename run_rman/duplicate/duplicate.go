@@ -0,0 +1,175 @@
+package duplicate
+
+// Standard imports
+
+import "database/sql"
+import "errors"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/general"
+import "github.com/daviesluke/run_rman/oracle"
+
+// local Variables
+
+var templateFile string
+
+// Local functions
+
+func renderTemplate(template string, untilTime string, auxiliaryDatabase string) (string, error) {
+	if !strings.Contains(strings.ToUpper(template), "DUPLICATE") {
+		return "", errors.New("template has no DUPLICATE command")
+	}
+
+	if strings.Contains(template, "<until_time>") && untilTime == "" {
+		return "", errors.New("DuplicateUntilTime must be set for <until_time>")
+	}
+
+	if strings.Contains(template, "<auxiliary_db>") && auxiliaryDatabase == "" {
+		return "", errors.New("DuplicateDatabase must be set for <auxiliary_db>")
+	}
+
+	// Replaced literally as an until time may hold $ or other regular expression characters
+
+	script := strings.Replace(template, "<until_time>", untilTime, -1)
+	script  = strings.Replace(script, "<auxiliary_db>", auxiliaryDatabase, -1)
+
+	return script, nil
+}
+
+func getScripts(configName string) []string {
+	// Scripts named without a directory are found in the config directory
+
+	var scripts []string
+
+	for _, script := range strings.Split(config.ConfigValues[configName], ",") {
+		script = strings.TrimSpace(script)
+
+		if script == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(script) {
+			script = filepath.Join(setup.ConfigDir, script)
+		}
+
+		scripts = append(scripts, script)
+	}
+
+	return scripts
+}
+
+func runScripts(configName string, openDatabase func() *sql.DB, databaseName string) {
+	scripts := getScripts(configName)
+
+	if len(scripts) == 0 {
+		logger.Infof("No %s scripts to run", configName)
+		return
+	}
+
+	db := openDatabase()
+
+	for _, script := range scripts {
+		if err := oracle.RunSQLFile(db, script); err != nil {
+			db.Close()
+
+			logger.Errorf("Unable to run %s on the %s database - %s", script, databaseName, err)
+		}
+	}
+
+	db.Close()
+}
+
+// Global functions
+
+func SetScript() {
+	logger.Debug("Setting the duplicate script ...")
+
+	if len(general.CommandArgs) != 1 {
+		logger.Errorf("Usage: %s [options] duplicate <template>", setup.BaseName)
+	}
+
+	var err error
+
+	if templateFile, err = filepath.Abs(general.CommandArgs[0]); err != nil {
+		logger.Errorf("Unable to get absolute pathname for %s", general.CommandArgs[0])
+	}
+
+	if _, err := os.Stat(templateFile); err != nil {
+		logger.Errorf("Unable to find duplicate template %s", templateFile)
+	}
+
+	logger.Infof("Duplicate template -> %s", templateFile)
+
+	// The script is rendered once the config is read so only the name is set here
+
+	config.RMANScript     = filepath.Join(setup.TmpDir, strings.Join( []string{ "duplicate", setup.CurrentPID, "rman" }, "."))
+	config.RMANScriptBase = strings.SplitN(filepath.Base(templateFile), ".", 2)[0]
+
+	logger.Infof("Duplicate script set to %s", config.RMANScript)
+
+	logger.Debug("Process complete")
+}
+
+func CheckConnection() {
+	oracle.CheckAuxiliaryConnection()
+}
+
+func WriteScript() {
+	logger.Info("Rendering duplicate script ...")
+
+	template, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		logger.Errorf("Unable to read duplicate template %s", templateFile)
+	}
+
+	script, err := renderTemplate(string(template), config.ConfigValues["DuplicateUntilTime"], config.ConfigValues["DuplicateDatabase"])
+	if err != nil {
+		logger.Errorf("Unable to render duplicate template %s - %s", templateFile, err)
+	}
+
+	if err := ioutil.WriteFile(config.RMANScript, []byte(script), 0600); err != nil {
+		logger.Errorf("Unable to write duplicate script %s", config.RMANScript)
+	}
+
+	logger.Info("Process complete")
+}
+
+func RunPreSteps() {
+	logger.Info("Running pre-duplicate steps ...")
+
+	runScripts("DuplicatePreSQL", oracle.OpenTarget, "source")
+
+	logger.Info("Process complete")
+}
+
+func RunPostSteps() {
+	logger.Info("Running post-duplicate steps ...")
+
+	runScripts("DuplicatePostSQL", oracle.OpenAuxiliary, "duplicate")
+
+	logger.Info("Process complete")
+}
+
+func Finish() {
+	logger.Info("Finishing duplicate ...")
+
+	if err := os.Remove(config.RMANScript); err != nil {
+		logger.Warnf("Unable to remove duplicate script %s", config.RMANScript)
+	}
+
+	// Record the point in time the duplicate was taken to
+
+	if config.ConfigValues["DuplicateUntilTime"] != "" {
+		logger.SetHistoryDetail(strings.Join( []string{ "until", config.ConfigValues["DuplicateUntilTime"] }, "="))
+	}
+
+	logger.Info("Process complete")
+}
@@ -0,0 +1,64 @@
+package duplicate
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/setup"
+)
+
+const testTemplate = `run {
+<parallel>
+duplicate target database to <auxiliary_db> until time "<until_time>" nofilenamecheck;
+}
+`
+
+func TestRenderTemplate(t *testing.T) {
+	script, err := renderTemplate(testTemplate, "to_date('2020-01-31 06:00','YYYY-MM-DD HH24:MI')", "ORCLDUP")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `run {
+<parallel>
+duplicate target database to ORCLDUP until time "to_date('2020-01-31 06:00','YYYY-MM-DD HH24:MI')" nofilenamecheck;
+}
+`
+
+	if script != expected {
+		t.Fatalf("bad: %q", script)
+	}
+
+	// Placeholders must have values
+	if _, err := renderTemplate(testTemplate, "", "ORCLDUP"); err == nil {
+		t.Fatal("should error")
+	}
+
+	if _, err := renderTemplate(testTemplate, "sysdate-1", ""); err == nil {
+		t.Fatal("should error")
+	}
+
+	if _, err := renderTemplate("backup database;", "sysdate-1", "ORCLDUP"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestGetScripts(t *testing.T) {
+	defer func() { config.ConfigValues["DuplicatePostSQL"] = "" }()
+
+	setup.ConfigDir = filepath.FromSlash("/opt/run_rman/config")
+
+	config.ConfigValues["DuplicatePostSQL"] = "mask.sql, /u01/sql/passwords.sql,"
+
+	expected := []string{filepath.FromSlash("/opt/run_rman/config/mask.sql"), "/u01/sql/passwords.sql"}
+
+	if scripts := getScripts("DuplicatePostSQL"); !reflect.DeepEqual(scripts, expected) {
+		t.Fatalf("bad: %#v", scripts)
+	}
+
+	if scripts := getScripts("DuplicatePreSQL"); len(scripts) != 0 {
+		t.Fatalf("bad: %#v", scripts)
+	}
+}
@@ -0,0 +1,266 @@
+package environment
+
+// Standard imports
+
+import "os"
+import "path/filepath"
+import "runtime"
+import "sort"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/run_rman/config"
+
+// local Variables
+
+// variables are set for rman on top of the environment of this process
+// An empty value removes the variable
+
+var variables = make(map[string]string)
+
+// processVariables are also needed by the Oracle client for connections made by this process
+// They are only set on the process by SetClient and hold the values for the database home
+
+var processVariables = []string{ "ORACLE_SID", "ORACLE_HOME", "TNS_ADMIN", "TWO_TASK" }
+
+var clientVariables = make(map[string]string)
+
+// The environment as it was when run_rman started so one database does not pick up the settings of another
+
+// Where the database home keeps its instance files
+
+var baseConfig string
+
+var startEnvironment = os.Environ()
+var startTNSAdmin    = os.Getenv("TNS_ADMIN")
+
+// Local functions
+
+func libraryPathName() string {
+	switch runtime.GOOS {
+	case "windows":
+		// Windows finds libraries using PATH
+		return ""
+	case "aix":
+		return "LIBPATH"
+	case "darwin":
+		return "DYLD_LIBRARY_PATH"
+	default:
+		return "LD_LIBRARY_PATH"
+	}
+}
+
+func prependPath(dir string, pathList string) string {
+	for _, pathDir := range filepath.SplitList(pathList) {
+		if pathDir == dir {
+			return pathList
+		}
+	}
+
+	if pathList == "" {
+		return dir
+	}
+
+	return strings.Join( []string{ dir, pathList }, string(os.PathListSeparator))
+}
+
+func getOverrides(database string) map[string]string {
+	// ENV_<NAME> applies to all databases and <SID>_ENV_<NAME> to one database
+
+	overrides := make(map[string]string)
+
+	databasePrefix := strings.Join( []string{ database, "ENV_" }, "_")
+
+	for configKey, configValue := range config.ConfigFileValues {
+		if strings.HasPrefix(configKey, "ENV_") {
+			overrides[configKey[len("ENV_"):]] = configValue
+		}
+	}
+
+	for configKey, configValue := range config.ConfigFileValues {
+		if database != "" && strings.HasPrefix(configKey, databasePrefix) {
+			overrides[configKey[len(databasePrefix):]] = configValue
+		}
+	}
+
+	return overrides
+}
+
+func getTNSAdminDirs(homes []Home) []string {
+	var tnsAdminDirs []string
+
+	for _, home := range homes {
+		for _, baseDir := range []string{ home.BaseHome, home.BaseConfig } {
+			tnsAdmin := filepath.Join(baseDir, "network", "admin")
+
+			if len(tnsAdminDirs) == 0 || tnsAdminDirs[len(tnsAdminDirs)-1] != tnsAdmin {
+				tnsAdminDirs = append(tnsAdminDirs, tnsAdmin)
+			}
+		}
+	}
+
+	return tnsAdminDirs
+}
+
+// Global functions
+
+func Build(database string, databaseHome string, oracleHome string) {
+	// oracleHome is the home rman is run from which is usually the database home
+
+	logger.Debugf("Building environment for %s using %s ...", database, oracleHome)
+
+	variables = make(map[string]string)
+
+	variables["ORACLE_SID"] = database
+	variables["TWO_TASK"]   = ""
+
+	// Windows does not want ORACLE_HOME set
+
+	if runtime.GOOS != "windows" {
+		variables["ORACLE_HOME"] = oracleHome
+	}
+
+	variables["PATH"] = prependPath(filepath.Join(oracleHome, "bin"), os.Getenv("PATH"))
+
+	if libraryPath := libraryPathName(); libraryPath != "" {
+		variables[libraryPath] = prependPath(filepath.Join(oracleHome, "lib"), os.Getenv(libraryPath))
+	}
+
+	// Cron does not usually set TNS_ADMIN so fall back to the rman home and then the database home
+	// A read-only home keeps it under ORACLE_BASE_HOME or ORACLE_BASE_CONFIG
+
+	homes := []Home{ GetHome(oracleHome) }
+
+	if filepath.Clean(databaseHome) != filepath.Clean(oracleHome) {
+		homes = append(homes, GetHome(databaseHome))
+	}
+
+	// The database home is last whether or not rman is run from another home
+
+	baseConfig = homes[len(homes)-1].BaseConfig
+
+	variables["TNS_ADMIN"] = startTNSAdmin
+
+	if startTNSAdmin == "" {
+		for _, tnsAdmin := range getTNSAdminDirs(homes) {
+			if _, err := os.Stat(tnsAdmin); err == nil {
+				variables["TNS_ADMIN"] = tnsAdmin
+				break
+			}
+		}
+	} else {
+		logger.Debugf("Using TNS_ADMIN %s set when started", startTNSAdmin)
+	}
+
+	// NLS_DATE_FORMAT gives better output and is read once when rman starts
+
+	if config.ConfigValues["NLS_DATE_FORMAT"] != "" {
+		variables["NLS_DATE_FORMAT"] = config.ConfigValues["NLS_DATE_FORMAT"]
+	}
+
+	for name, value := range getOverrides(database) {
+		logger.Debugf("Environment %s overridden in config file", name)
+		variables[name] = value
+	}
+
+	clientVariables = make(map[string]string)
+
+	for _, name := range processVariables {
+		if value, found := variables[name]; found {
+			clientVariables[name] = value
+		}
+	}
+
+	// Local connections made by this process start the oracle binary of the database home
+
+	if runtime.GOOS != "windows" {
+		clientVariables["ORACLE_HOME"] = databaseHome
+	}
+
+	logger.Debug("Process complete")
+}
+
+func SetClient() {
+	// The Oracle client reads these from the process environment when a connection is made
+	// so they are set just before connecting to follow the database last built
+
+	for name, value := range clientVariables {
+		if value != "" {
+			os.Setenv(name, value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func BaseConfig() string {
+	// ORACLE_BASE_CONFIG of the database home, which is the home itself when it is read-write
+
+	return baseConfig
+}
+
+func Get(name string) string {
+	if value, found := variables[name]; found {
+		return value
+	}
+
+	return os.Getenv(name)
+}
+
+func Environ() []string {
+	var environment []string
+
+	for _, variable := range os.Environ() {
+		if _, found := variables[strings.SplitN(variable, "=", 2)[0]]; !found {
+			environment = append(environment, variable)
+		}
+	}
+
+	for name, value := range variables {
+		if value != "" {
+			environment = append(environment, strings.Join( []string{ name, value }, "="))
+		}
+	}
+
+	return environment
+}
+
+func GetStart(name string) string {
+	// The value when run_rman started before any database was connected to
+
+	for _, variable := range startEnvironment {
+		if tokens := strings.SplitN(variable, "=", 2); tokens[0] == name && len(tokens) == 2 {
+			return tokens[1]
+		}
+	}
+
+	return ""
+}
+
+func StartEnviron() []string {
+	return append([]string{}, startEnvironment...)
+}
+
+func Log() {
+	logger.Info("Environment for RMAN ...")
+
+	var names []string
+
+	for name := range variables {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if variables[name] == "" {
+			logger.Infof("%-18s (unset)", name)
+		} else {
+			logger.Infof("%-18s %s", name, variables[name])
+		}
+	}
+
+	logger.Info("Process complete")
+}
@@ -0,0 +1,238 @@
+package environment
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/daviesluke/run_rman/config"
+)
+
+func environMap(environment []string) map[string]string {
+	values := make(map[string]string)
+
+	for _, variable := range environment {
+		tokens := strings.SplitN(variable, "=", 2)
+		values[tokens[0]] = tokens[1]
+	}
+
+	return values
+}
+
+func TestPrependPath(t *testing.T) {
+	separator := string(os.PathListSeparator)
+
+	cases := []struct {
+		dir      string
+		pathList string
+		expected string
+	}{
+		{"/oh/bin", "", "/oh/bin"},
+		{"/oh/bin", "/usr/bin", "/oh/bin" + separator + "/usr/bin"},
+		{"/oh/bin", "/usr/bin" + separator + "/oh/bin", "/usr/bin" + separator + "/oh/bin"},
+	}
+
+	for _, c := range cases {
+		if result := prependPath(c.dir, c.pathList); result != c.expected {
+			t.Fatalf("bad: %s %s -> %s", c.dir, c.pathList, result)
+		}
+	}
+}
+
+func TestGetOverrides(t *testing.T) {
+	config.ConfigFileValues = map[string]string{
+		"ENV_NLS_LANG":       "AMERICAN_AMERICA.AL32UTF8",
+		"ENV_TNS_ADMIN":      "/etc/tns",
+		"ORCL_ENV_TNS_ADMIN": "/etc/orcl",
+		"TEST_ENV_NLS_LANG":  "ENGLISH_UNITED KINGDOM.AL32UTF8",
+		"ORCL_LogKeepTime":   "7",
+	}
+
+	defer func() { config.ConfigFileValues = nil }()
+
+	overrides := getOverrides("ORCL")
+
+	if len(overrides) != 2 || overrides["TNS_ADMIN"] != "/etc/orcl" || overrides["NLS_LANG"] != "AMERICAN_AMERICA.AL32UTF8" {
+		t.Fatalf("bad: %v", overrides)
+	}
+
+	if overrides := getOverrides(""); overrides["TNS_ADMIN"] != "/etc/tns" {
+		t.Fatalf("bad: %v", overrides)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ORACLE_HOME is not set on Windows")
+	}
+
+	oracleHome, err := ioutil.TempDir("", "environment")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(oracleHome)
+
+	if err := os.MkdirAll(filepath.Join(oracleHome, "network", "admin"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, name := range append(processVariables, "LD_LIBRARY_PATH", "NLS_LANG") {
+		if value, found := os.LookupEnv(name); found {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	os.Setenv("TWO_TASK", "REMOTE")
+	os.Setenv("LD_LIBRARY_PATH", "/usr/lib")
+	os.Setenv("NLS_LANG", "AMERICAN_AMERICA.WE8ISO8859P1")
+
+	startTNSAdmin = ""
+	config.ConfigValues["NLS_DATE_FORMAT"] = "DD-MON-YYYY HH24:MI:SS"
+	config.ConfigFileValues = map[string]string{"ORCL_ENV_NLS_LANG": ""}
+
+	defer func() { config.ConfigFileValues = nil }()
+
+	Build("ORCL", oracleHome, oracleHome)
+
+	environment := environMap(Environ())
+
+	if environment["ORACLE_SID"] != "ORCL" || environment["ORACLE_HOME"] != oracleHome {
+		t.Fatalf("bad: %v", environment)
+	}
+
+	if environment["TNS_ADMIN"] != filepath.Join(oracleHome, "network", "admin") {
+		t.Fatalf("bad: %s", environment["TNS_ADMIN"])
+	}
+
+	if runtime.GOOS == "linux" && environment["LD_LIBRARY_PATH"] != filepath.Join(oracleHome, "lib")+":/usr/lib" {
+		t.Fatalf("bad: %s", environment["LD_LIBRARY_PATH"])
+	}
+
+	if !strings.HasPrefix(environment["PATH"], filepath.Join(oracleHome, "bin")) {
+		t.Fatalf("bad: %s", environment["PATH"])
+	}
+
+	if environment["NLS_DATE_FORMAT"] != "DD-MON-YYYY HH24:MI:SS" {
+		t.Fatalf("bad: %s", environment["NLS_DATE_FORMAT"])
+	}
+
+	if _, found := environment["TWO_TASK"]; found {
+		t.Fatal("TWO_TASK should be removed")
+	}
+
+	if _, found := environment["NLS_LANG"]; found {
+		t.Fatal("NLS_LANG should be removed by the override")
+	}
+
+	// The process is left alone until a connection is made
+	if os.Getenv("TWO_TASK") != "REMOTE" {
+		t.Fatalf("bad: %s", os.Getenv("TWO_TASK"))
+	}
+
+	// Then only what the Oracle client in this process needs is set on it
+	SetClient()
+
+	if os.Getenv("ORACLE_SID") != "ORCL" || os.Getenv("TWO_TASK") != "" {
+		t.Fatalf("bad: %s %s", os.Getenv("ORACLE_SID"), os.Getenv("TWO_TASK"))
+	}
+
+	if os.Getenv("LD_LIBRARY_PATH") != "/usr/lib" || os.Getenv("NLS_LANG") == "" {
+		t.Fatalf("bad: %s %s", os.Getenv("LD_LIBRARY_PATH"), os.Getenv("NLS_LANG"))
+	}
+
+	// Settings made for one database are not passed on to children
+	if environMap(StartEnviron())["ORACLE_SID"] == "ORCL" || GetStart("ORACLE_SID") == "ORCL" {
+		t.Fatal("ORACLE_SID should not be set in the start environment")
+	}
+
+	if Get("TNS_ADMIN") != environment["TNS_ADMIN"] {
+		t.Fatalf("bad: %s", Get("TNS_ADMIN"))
+	}
+
+	// A client home used for rman without network/admin falls back to the database home
+	rmanHome, err := ioutil.TempDir("", "environment")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(rmanHome)
+
+	Build("ORCL", oracleHome, rmanHome)
+
+	if Get("TNS_ADMIN") != filepath.Join(oracleHome, "network", "admin") || Get("ORACLE_HOME") != rmanHome {
+		t.Fatalf("bad: %s %s", Get("TNS_ADMIN"), Get("ORACLE_HOME"))
+	}
+
+	// Instance files are under the database home
+	if BaseConfig() != oracleHome {
+		t.Fatalf("bad: %s", BaseConfig())
+	}
+}
+
+func TestGetHome(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+
+	oracleHome, err := ioutil.TempDir("", "environment")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(oracleHome)
+
+	// Homes before 18c have no orabasehome
+	if home := GetHome(oracleHome); home.ReadOnly || home.BaseHome != oracleHome || home.BaseConfig != oracleHome {
+		t.Fatalf("bad: %#v", home)
+	}
+
+	if err := os.MkdirAll(filepath.Join(oracleHome, "bin"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The commands print the home they were given when it is read-write
+	for _, command := range []string{"orabasehome", "orabaseconfig"} {
+		if err := ioutil.WriteFile(filepath.Join(oracleHome, "bin", command), []byte("#!/bin/sh\necho $ORACLE_HOME\n"), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if home := GetHome(oracleHome); home.ReadOnly || home.BaseHome != oracleHome || home.BaseConfig != oracleHome {
+		t.Fatalf("bad: %#v", home)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(oracleHome, "bin", "orabaseconfig"), []byte("#!/bin/sh\necho /u01/app/oracle\n"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	script := "#!/bin/sh\necho /u01/app/oracle/homes/OraDB19Home1\n"
+
+	if err := ioutil.WriteFile(filepath.Join(oracleHome, "bin", "orabasehome"), []byte(script), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if home := GetHome(oracleHome); !home.ReadOnly || home.BaseHome != "/u01/app/oracle/homes/OraDB19Home1" || home.BaseConfig != "/u01/app/oracle" {
+		t.Fatalf("bad: %#v", home)
+	}
+}
+
+func TestGetTNSAdminDirs(t *testing.T) {
+	homes := []Home{
+		{Path: "/oh/client", BaseHome: "/oh/client", BaseConfig: "/oh/client"},
+		{Path: "/oh/db", BaseHome: "/ob/homes/db", BaseConfig: "/ob"},
+	}
+
+	expected := []string{
+		filepath.Join("/oh/client", "network", "admin"),
+		filepath.Join("/ob/homes/db", "network", "admin"),
+		filepath.Join("/ob", "network", "admin"),
+	}
+
+	if dirs := getTNSAdminDirs(homes); !reflect.DeepEqual(dirs, expected) {
+		t.Fatalf("bad: %#v", dirs)
+	}
+}
@@ -0,0 +1,78 @@
+package environment
+
+// Standard imports
+
+import "os"
+import "os/exec"
+import "path/filepath"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+
+// Global Variables
+
+// Home is an Oracle home and where its configuration is kept
+// Read-only homes (18c and later) keep network/admin under ORACLE_BASE_HOME and dbs under ORACLE_BASE_CONFIG
+
+type Home struct {
+	Path       string
+	BaseHome   string
+	BaseConfig string
+	ReadOnly   bool
+}
+
+// Local functions
+
+func runHomeCommand(oracleHome string, command string) string {
+	// Homes before 18c do not have the commands so are always read-write
+
+	commandPath := filepath.Join(oracleHome, "bin", strings.Join( []string{ command, setup.ExecutableSuffix }, ""))
+
+	if _, err := os.Stat(commandPath); err != nil {
+		logger.Tracef("%s not found", commandPath)
+		return ""
+	}
+
+	homeCommand := exec.Command(commandPath)
+
+	homeCommand.Env = append(os.Environ(), strings.Join( []string{ "ORACLE_HOME", oracleHome }, "="))
+
+	output, err := homeCommand.Output()
+	if err != nil {
+		logger.Warnf("Unable to run %s - %s", commandPath, err)
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+// Global functions
+
+func GetHome(oracleHome string) Home {
+	logger.Debugf("Checking Oracle home %s ...", oracleHome)
+
+	home := Home{ Path: oracleHome, BaseHome: oracleHome, BaseConfig: oracleHome }
+
+	if baseHome := runHomeCommand(oracleHome, "orabasehome"); baseHome != "" {
+		home.BaseHome = baseHome
+	}
+
+	if baseConfig := runHomeCommand(oracleHome, "orabaseconfig"); baseConfig != "" {
+		home.BaseConfig = baseConfig
+	}
+
+	home.ReadOnly = filepath.Clean(home.BaseHome) != filepath.Clean(oracleHome)
+
+	if home.ReadOnly {
+		logger.Infof("Oracle home %s is read-only. ORACLE_BASE_HOME is %s and ORACLE_BASE_CONFIG is %s", oracleHome, home.BaseHome, home.BaseConfig)
+	} else {
+		logger.Infof("Oracle home %s is read-write", oracleHome)
+	}
+
+	logger.Debug("Process complete")
+
+	return home
+}
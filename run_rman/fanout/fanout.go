@@ -0,0 +1,219 @@
+package fanout
+
+// Standard imports
+
+import "flag"
+import "fmt"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/general"
+import "github.com/daviesluke/run_rman/oratab"
+
+// local Variables
+
+type result struct {
+	database string
+	err      error
+	duration time.Duration
+}
+
+// runDatabase runs the script against one database and waits for it to finish
+
+var runDatabase = runChild
+
+// Local functions
+
+func isPattern(database string) bool {
+	return strings.ContainsAny(database, "*?[")
+}
+
+func expand(databaseList string, entries []oratab.Entry) ([]string, error) {
+	// ALL and patterns only pick databases flagged Y to start in the oratab
+	// Databases named in full are run whatever their flag
+
+	var databases []string
+
+	found := make(map[string]bool)
+
+	add := func(database string) {
+		if !found[database] {
+			found[database] = true
+			databases = append(databases, database)
+		}
+	}
+
+	for _, item := range strings.Split(databaseList, ",") {
+		item = strings.TrimSpace(item)
+
+		switch {
+		case item == "":
+			continue
+		case strings.ToUpper(item) == "ALL" || isPattern(item):
+			if _, err := filepath.Match(item, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s", item)
+			}
+
+			for _, entry := range entries {
+				matched, _ := filepath.Match(item, entry.SID)
+
+				if entry.Start && (matched || strings.ToUpper(item) == "ALL") {
+					add(entry.SID)
+				}
+			}
+		default:
+			add(item)
+		}
+	}
+
+	if len(databases) == 0 {
+		return nil, fmt.Errorf("no databases in %s match %s", config.ConfigValues["OraTabPath"], databaseList)
+	}
+
+	return databases, nil
+}
+
+func childArgs(database string) []string {
+	// Each run takes the same locks and resources but only the summary is mailed
+
+	var args []string
+
+	flag.Visit(func(flagParam *flag.Flag) {
+		switch flagParam.Name {
+		case "db", "d", "email", "E", "erroremail", "e":
+		default:
+			args = append(args, strings.Join( []string{ "-", flagParam.Name, "=", flagParam.Value.String() }, ""))
+		}
+	})
+
+	return append(args, "-d", database, config.RMANScript)
+}
+
+func runChild(database string) error {
+	command, err := general.StartChild(childArgs(database))
+	if err != nil {
+		return err
+	}
+
+	return command.Wait()
+}
+
+func runAll(databases []string, parallel int) []result {
+	// Runs at most parallel databases at once and keeps the results in the order given
+
+	results := make([]result, len(databases))
+	slots   := make(chan bool, parallel)
+
+	var wait sync.WaitGroup
+
+	for i, database := range databases {
+		slots <- true
+
+		wait.Add(1)
+
+		go func(i int, database string) {
+			defer wait.Done()
+
+			logger.Infof("Starting %s for %s ...", config.RMANScriptBase, database)
+
+			start := time.Now()
+			err   := runDatabase(database)
+
+			results[i] = result{ database: database, err: err, duration: time.Since(start) }
+
+			if err != nil {
+				logger.Warnf("Run of %s for %s failed - %s. See its log for details", config.RMANScriptBase, database, err)
+			} else {
+				logger.Infof("Run of %s for %s complete", config.RMANScriptBase, database)
+			}
+
+			<-slots
+		}(i, database)
+	}
+
+	wait.Wait()
+
+	return results
+}
+
+// Global functions
+
+func IsMulti(database string) bool {
+	return strings.ToUpper(database) == "ALL" || isPattern(database) || strings.Contains(database, ",")
+}
+
+func Run() {
+	logger.Infof("Running against databases %s ...", setup.Database)
+
+	// Read the config file
+	config.GetConfig(setup.ConfigFileName)
+
+	// Only the settings common to all databases apply here
+	config.SetAllConfig("")
+
+	// The summary is recorded against the databases as given
+	logger.SetHistoryVars(setup.HistFileName, setup.Database, config.RMANScriptBase)
+
+	entries, err := oratab.ReadAll(config.ConfigValues["OraTabPath"])
+	if err != nil {
+		logger.Errorf("Unable to read oratab - %s", err)
+	}
+
+	databases, err := expand(setup.Database, oratab.Databases(entries))
+	if err != nil {
+		logger.Errorf("Unable to find databases - %s", err)
+	}
+
+	parallel, err := strconv.Atoi(config.ConfigValues["FanOutParallel"])
+	if err != nil || parallel < 1 {
+		logger.Errorf("FanOutParallel must be 1 or more not %s", config.ConfigValues["FanOutParallel"])
+	}
+
+	logger.Infof("Running %s against %s, %d at a time", config.RMANScriptBase, strings.Join(databases, ", "), parallel)
+
+	results := runAll(databases, parallel)
+
+	// Summarise for the mail and history
+
+	failed := 0
+
+	logger.Info("Summary")
+
+	for _, result := range results {
+		status := "SUCCESS"
+
+		if result.err != nil {
+			status = "FAILURE"
+			failed++
+		}
+
+		logger.Infof("%-12s %-8s %s", result.database, status, result.duration.Round(time.Second))
+	}
+
+	logger.SetHistoryDetail(fmt.Sprintf("databases=%d failed=%d", len(results), failed))
+
+	if failed > 0 {
+		logger.WriteHistory("FAILURE")
+
+		logger.Infof("%d of %d databases failed", failed, len(results))
+
+		logger.SendLog("ERROR")
+
+		os.Exit(1)
+	} else {
+		logger.WriteHistory("SUCCESS")
+
+		logger.Info("Process complete")
+
+		logger.SendLog("SUCCESS")
+	}
+}
@@ -0,0 +1,113 @@
+package fanout
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/run_rman/oratab"
+)
+
+var testEntries = []oratab.Entry{
+	{SID: "PROD1", Start: true},
+	{SID: "PROD2", Start: true},
+	{SID: "PROD3", Start: false},
+	{SID: "TEST", Start: true},
+	{SID: "PROD1", Start: true},
+}
+
+func TestIsMulti(t *testing.T) {
+	for database, multi := range map[string]bool{"ORCL": false, "all": true, "PROD*": true, "ORCL,TEST": true, "": false} {
+		if IsMulti(database) != multi {
+			t.Fatalf("bad: %s", database)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	cases := map[string][]string{
+		"ALL":             {"PROD1", "PROD2", "TEST"},
+		"PROD*":           {"PROD1", "PROD2"},
+		"PROD[23]":        {"PROD2"},
+		"PROD3,TEST":      {"PROD3", "TEST"},
+		"TEST, PROD*,ALL": {"TEST", "PROD1", "PROD2"},
+	}
+
+	for databaseList, expected := range cases {
+		databases, err := expand(databaseList, testEntries)
+		if err != nil || !reflect.DeepEqual(databases, expected) {
+			t.Fatalf("bad %s: %#v %v", databaseList, databases, err)
+		}
+	}
+
+	for _, databaseList := range []string{"DEV*", "PROD[", ","} {
+		if _, err := expand(databaseList, testEntries); err == nil {
+			t.Fatalf("should error: %s", databaseList)
+		}
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	defer func() { runDatabase = runChild }()
+
+	var mutex sync.Mutex
+
+	current, most := 0, 0
+
+	runDatabase = func(database string) error {
+		mutex.Lock()
+		current++
+		if current > most {
+			most = current
+		}
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		current--
+		mutex.Unlock()
+
+		if database == "PROD2" {
+			return errors.New("exit status 1")
+		}
+
+		return nil
+	}
+
+	results := runAll([]string{"PROD1", "PROD2", "PROD3", "TEST", "DEV"}, 2)
+
+	if most != 2 {
+		t.Fatalf("bad concurrency: %d", most)
+	}
+
+	for i, database := range []string{"PROD1", "PROD2", "PROD3", "TEST", "DEV"} {
+		if results[i].database != database || (results[i].err != nil) != (database == "PROD2") {
+			t.Fatalf("bad: %#v", results[i])
+		}
+	}
+}
+
+func TestChildArgs(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet("run_rman", flag.ContinueOnError)
+
+	flag.String("d", "", "Database name")
+	flag.String("E", "", "E-mail list")
+	flag.String("r", "", "Resource name")
+
+	flag.Set("d", "PROD*")
+	flag.Set("E", "dba@example.com")
+	flag.Set("r", "TAPE=1")
+
+	config.RMANScript = "/opt/run_rman/rman_scripts/level_0_backup.rman"
+
+	expected := []string{"-r=TAPE=1", "-d", "PROD1", config.RMANScript}
+
+	if args := childArgs("PROD1"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("bad: %#v", args)
+	}
+}
@@ -3,9 +3,10 @@ package general
 // Standard imports
 
 import "flag"
+import "fmt"
 import "os"
+import "os/exec"
 import "path/filepath"
-import "runtime"
 import "strings"
 import "strconv"
 import "time"
@@ -16,7 +17,10 @@ import "github.com/daviesluke/logger"
 import "github.com/daviesluke/setup"
 import "github.com/daviesluke/utils"
 import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/coordinator"
+import "github.com/daviesluke/run_rman/environment"
 import "github.com/daviesluke/run_rman/locker"
+import "github.com/daviesluke/run_rman/oratab"
 import "github.com/daviesluke/run_rman/resource"
 
 
@@ -31,18 +35,38 @@ var email      = flag.String("email"      , "", "E-mail list for success / failu
 var lock       = flag.String("lock"       , "", "Lock name")
 var logDir     = flag.String("log"        , "", "Directory for logs")
 var resList    = flag.String("resource"   , "", "Resource name")
+var apply      = flag.Bool("apply"        , false, "Apply changes to fix configuration drift")
+var format     = flag.String("format"     , "text", "Report output format")
 
 // Global Variables
 
 var LockName          string
 
+// Command is the action requested. Anything other than a known command name is taken as an RMAN script to run
+
+var Command           string = "run"
+
+var commandList       = []string{ "status", "config drift", "recover", "vault add", "vault list", "vault remove", "report", "restoretest", "duplicate", "watch", "daemon", "schedule list" }
+
+// CommandArgs holds any arguments following the command words e.g. the name for vault add
+
+var CommandArgs       []string
+
+var ApplyDrift        bool
+
+var ReportFormat      string = "text"
+
 var SuccessEmails     []string
 var ErrorEmails       []string
 
-var Resources         map[string]int
+var Resources         = make(map[string]int)
 
 var RMAN              string
 
+// OracleHome is the home of the database which RMAN is run from unless RMANHome is set
+
+var OracleHome        string
+
 // Local functions
 
 func init() {
@@ -57,6 +81,8 @@ func init() {
 	flag.StringVar(lock      , "l", "", "Lock name")
 	flag.StringVar(logDir    , "L", "", "Alternative Log directory")
 	flag.StringVar(resList   , "r", "", "Resource name")
+	flag.BoolVar(apply       , "a", false, "Apply changes to fix configuration drift")
+	flag.StringVar(format    , "f", "text", "Report output format")
 }
 
 func removeOldFiles ( dirName string, fileFilter string , daysOld int ) {
@@ -85,6 +111,90 @@ func removeOldFiles ( dirName string, fileFilter string , daysOld int ) {
 	logger.Info("Process complete")
 }
 
+func setDatabaseEnvironment ( database string ) error {
+	// Finds the homes for the database and builds the environment rman is run with
+
+	logger.SetHistoryVars(setup.HistFileName, database, config.RMANScriptBase)
+
+	// Set all the config items
+
+	config.SetAllConfig(database)
+
+	// See if we can find Oracle Home in the OraTabPath string
+
+	var oracleHome string
+
+	// Now we have the database name find its home using oraenv if set or the oratab files in OraTabPath
+
+	if config.ConfigValues["EnvFile"] != "" {
+		envValues, err := oratab.OraEnv(config.ConfigValues["EnvFile"], database)
+		if err != nil {
+			return fmt.Errorf("Unable to set the environment for %s - %s", database, err)
+		}
+
+		oracleHome = envValues["ORACLE_HOME"]
+
+		logger.Debugf("ORACLE_HOME %s set by %s", oracleHome, config.ConfigValues["EnvFile"])
+	} else if entry, err := oratab.Lookup(config.ConfigValues["OraTabPath"], database); err == nil {
+		oracleHome = entry.Home
+
+		logger.Debugf("ORACLE_HOME %s found in %s line %d", oracleHome, entry.File, entry.Line)
+	} else {
+		logger.Debugf("Unable to find ORACLE_HOME in oratab - %s", err)
+	}
+
+	if oracleHome == "" {
+		// Check to see if it was set in the environment when started as watch sets it for each database
+
+		oracleHome = environment.GetStart("ORACLE_HOME")
+
+		if oracleHome == "" {
+			return fmt.Errorf("Unable to locate an Oracle Home.  Use the correct SID and environment file.")
+		}
+
+		logger.Debug("Using ORACLE_HOME already set in environment")
+	}
+
+	OracleHome = oracleHome
+
+	// rman may be run from another home e.g. a newer client against an older database
+
+	rmanHome := oracleHome
+
+	if config.ConfigValues["RMANHome"] != "" {
+		rmanHome = config.ConfigValues["RMANHome"]
+
+		logger.Infof("Using RMANHome %s in place of database home %s", rmanHome, oracleHome)
+	}
+
+	// Now check it is a valid ORACLE_HOME containing rman
+
+	RMAN = strings.Join( [] string{ "rman"   , setup.ExecutableSuffix }, "" )
+
+	RMAN = filepath.Join(  rmanHome, "bin", RMAN )
+
+	logger.Tracef("Checking for RMAN executable - %s", RMAN)
+	if _, err := os.Stat(RMAN); err != nil {
+		return fmt.Errorf("ORACLE_HOME %s does not contain command %s", rmanHome, RMAN)
+	}
+
+	logger.Infof("ORACLE_HOME set to %s", rmanHome)
+
+	// ORACLE_SID, PATH, libraries and TNS_ADMIN are set for rman only
+
+	environment.Build(database, oracleHome, rmanHome)
+	environment.Log()
+
+	// An RMANConfig without a directory is kept with the instance files of the database home
+
+	if rmanConfig := config.ConfigValues["RMANConfig"]; rmanConfig != "" && !filepath.IsAbs(rmanConfig) {
+		config.ConfigValues["RMANConfig"] = filepath.Join(environment.BaseConfig(), "dbs", rmanConfig)
+
+		logger.Infof("Using RMANConfig %s", config.ConfigValues["RMANConfig"])
+	}
+
+	return nil
+}
 
 // Global functions
 
@@ -137,6 +247,10 @@ func ValidateFlags () {
 			setup.SetDatabase(*database)
 		} else if flagParam.Name == "lock" || flagParam.Name == "l" {
 			SetLock(*lock)
+		} else if flagParam.Name == "apply" || flagParam.Name == "a" {
+			ApplyDrift = *apply
+		} else if flagParam.Name == "format" || flagParam.Name == "f" {
+			ReportFormat = *format
 		}
 	}
 
@@ -147,6 +261,25 @@ func ValidateFlags () {
 	logger.Info("Process complete")
 }
 
+func SetCommand () {
+	logger.Debug("Setting the command ...")
+
+	// Commands may be more than one word e.g. config drift
+
+	for _, commandName := range commandList {
+		commandWords := strings.Fields(commandName)
+
+		if flag.NArg() >= len(commandWords) && strings.Join(flag.Args()[:len(commandWords)], " ") == commandName {
+			Command     = commandName
+			CommandArgs = flag.Args()[len(commandWords):]
+		}
+	}
+
+	logger.Infof("Command set to %s", Command)
+
+	logger.Debug("Process complete")
+}
+
 func SetEnvironment ( database string ) {
 	logger.Info("Setting database environment ...")
 
@@ -199,88 +332,27 @@ func SetEnvironment ( database string ) {
 
 	logger.Infof("Database set to %s", setup.Database)
 
-	logger.SetHistoryVars(setup.HistFileName, setup.Database, config.RMANScriptBase)
-
-	// Sets the correct ORACLE_SID environment 
-
-	os.Setenv("ORACLE_SID",setup.Database)
-	logger.Tracef("Set environment ORACLE_SID to %s", setup.Database)
-	os.Unsetenv("TWO_TASK")
-	logger.Trace("Unset TWO_TASK")
-
-	// Set all the config items
-
-	config.SetAllConfig(setup.Database)
-
-	// See if we can find Oracle Home in the OraTabPath string
-
-	var oracleHome string
-
-	// Now we have the database name examine the OraTabPath to see if there are any overrides
-
-	logger.Tracef("Looping around the OraTabPath %s", config.ConfigValues["OraTabPath"])
-
-	for _, envFile := range strings.Split(config.ConfigValues["OraTabPath"],setup.PathDelimiter) {
-
-		logger.Debugf("Checking database in file %s", envFile)
-
-		// Check file exists
-
-		if _, err := os.Stat(envFile); err == nil {
-			oracleHome = utils.LookupFile(envFile,setup.Database,1,2,setup.PathDelimiter,1)
-
-			if oracleHome != "" {
-				logger.Debug("Found entry in file. Breaking loop ...")
-				break
-			}
-		} else {
-			logger.Trace("File not found. Ignoring ...")
-		}
+	if err := setDatabaseEnvironment(setup.Database); err != nil {
+		logger.Errorf("%s", err)
 	}
 
-	if oracleHome == "" {
-		// Check to see if it is set in the environment
-
-		oracleHome := os.Getenv("ORACLE_HOME")
-
-		if oracleHome == "" {
-			logger.Errorf("Unable to locate an Oracle Home.  Use the correct SID and environment file.")
-		} else {
-			logger.Debug("Using ORACLE_HOME already set in environment")
-		}
-	} else {
-		// Windows does not want ORACLE_HOME set - just make sure path is set correctly 
-		// Otherwise set the ORACLE_HOME
-
-		if runtime.GOOS == "windows" {
-			logger.Trace("Setting the PATH env variable")
-		
-			ohPath  := filepath.Join(oracleHome,"bin")
-			exePath := os.Getenv("PATH")
-
-			exePath = strings.Join( []string{ ohPath , exePath }, setup.PathDelimiter )
-		
-			os.Setenv("PATH",exePath)
-		} else {
-			logger.Trace("Setting the ORACLE_HOME env variable")
-			os.Setenv("ORACLE_HOME",oracleHome)
-		}
-	}
+	logger.Info("Process complete")
+}
 
-	// Now check it is a valid ORACLE_HOME containing rman
+func CheckEnvironment ( database string ) error {
+	// As SetEnvironment for a named database but returns any problem rather than exiting
 
-	RMAN = strings.Join( [] string{ "rman"   , setup.ExecutableSuffix }, "" )
+	logger.Info("Checking database environment ...")
 
-	RMAN = filepath.Join(  oracleHome, "bin", RMAN )
+	setup.Database = database
 
-	logger.Tracef("Checking for RMAN executable - %s", RMAN)
-	if _, err := os.Stat(RMAN); err != nil {
-		logger.Errorf("ORACLE_HOME %s does not contain command %s", oracleHome, RMAN);
-	}
+	logger.Infof("Database set to %s", setup.Database)
 
-	logger.Infof("ORACLE_HOME set to %s", oracleHome)
+	err := setDatabaseEnvironment(setup.Database)
 
 	logger.Info("Process complete")
+
+	return err
 }
 
 func SetLock (lock string) {
@@ -370,6 +442,33 @@ func SetErrEmail (email string) {
 	logger.Debug("Process complete")
 }
 
+func StartChild ( args []string ) (*exec.Cmd, error) {
+	logger.Debug("Starting child run ...")
+
+	// Runs started by watch or daemon use this executable so they follow the normal flow
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	command := exec.Command(executable, args...)
+
+	// Children build their own environment so must not start with the one set for another database
+
+	command.Env = environment.StartEnviron()
+
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("Started %s with PID %d", executable, command.Process.Pid)
+
+	logger.Debug("Process complete")
+
+	return command, nil
+}
+
 func RenameLog () {
 	logger.Info("Renaming log ...")
 
@@ -398,7 +497,7 @@ func Cleanup() {
 
 	// Remove lock file if specified
 	if LockName != "" {
-		locker.RemoveLockEntry(setup.LockFileName,setup.CurrentPID)
+		locker.RemoveLockEntry(setup.LockFileName,setup.ProcessEntry)
 	}
 
 	// Release resources if specified
@@ -406,6 +505,9 @@ func Cleanup() {
 		resource.ReleaseResources(setup.ResourceObtainedFileName)
 	}
 
+	// Deregister from the coordination backend
+	coordinator.Shutdown()
+
 	logKeepTime, _ := strconv.Atoi(config.ConfigValues["LogKeepTime"])
 
 	// Removing old log files that have not yet been renamed
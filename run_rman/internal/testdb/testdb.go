@@ -0,0 +1,109 @@
+package testdb
+
+// Standard imports
+
+import "database/sql/driver"
+import "errors"
+import "io"
+
+// Global Variables
+
+//
+// Driver is a database/sql driver for tests that answers each statement with the functions set on it.
+//
+// Register it under a name of the test's choosing with sql.Register and open that name.
+// Connect, if set, is called for each new connection and can fail or delay it.
+// Query returns the rows for a query. The columns are taken from the first row.
+// Transactions are accepted and do nothing.
+//
+
+type Driver struct {
+	Connect func() error
+	Exec    func(query string, args []driver.Value) (driver.Result, error)
+	Query   func(query string, args []driver.Value) ([][]driver.Value, error)
+}
+
+// local Variables
+
+type conn struct {
+	d *Driver
+}
+
+type stmt struct {
+	d     *Driver
+	query string
+}
+
+type rows struct {
+	columns int
+	values  [][]driver.Value
+}
+
+var errNotSupported = errors.New("not supported by the test driver")
+
+// Local functions
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{ d: c.d, query: query }, nil
+}
+
+func (c *conn) Close() error              { return nil }
+func (c *conn) Begin() (driver.Tx, error) { return c, nil }
+func (c *conn) Commit() error             { return nil }
+func (c *conn) Rollback() error           { return nil }
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.d.Exec == nil {
+		return nil, errNotSupported
+	}
+
+	return s.d.Exec(s.query, args)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.d.Query == nil {
+		return nil, errNotSupported
+	}
+
+	values, err := s.d.Query(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &rows{ values: values }
+
+	if len(values) > 0 {
+		result.columns = len(values[0])
+	}
+
+	return result, nil
+}
+
+func (r *rows) Columns() []string { return make([]string, r.columns) }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+// Global functions
+
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	if d.Connect != nil {
+		if err := d.Connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &conn{ d: d }, nil
+}
@@ -2,100 +2,133 @@ package locker
 
 // Standard imports
 
-import "bufio"
-import "os"
 import "strings"
 import "strconv"
 import "time"
 
 // Local imports
 
-import "github.com/daviesluke/filelock"
 import "github.com/daviesluke/logger"
 import "github.com/daviesluke/setup"
 import "github.com/daviesluke/utils"
 import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/coordinator"
+import "github.com/daviesluke/run_rman/metrics"
 
 
 // local Variables
 
 // Local functions
 
-func checkLock( lockFileName string , lockName string , timeOutMins int ) {
-	var lockFile *os.File
-	var err      error
+func addEntry(lockFileName, pid, lockName string) {
+	// The caller must hold the lock on the lock set
+
+	// Entries for this process carry a heartbeat so hung processes can be detected
+
+	if utils.SameProcessEntry(pid, setup.ProcessEntry) {
+		pid = coordinator.AddHeartbeat(lockFileName, pid)
+	}
+
+	writeString := strings.Join( []string{ pid, " ", lockName }, "")
+
+	coordinator.Current.AddEntry(lockFileName, writeString)
+
+	logger.Infof("Added entry %s to lock file", writeString)
+}
+
+func removeEntry(lockFileName string, lockPID string) {
+	// The caller must hold the lock on the lock set
+
+	logger.Infof("Lock File : %s", lockFileName)
+	logger.Infof("Lock PID  : %s", lockPID)
+
+	// Stop refreshing our own entry before it goes
+
+	if utils.SameProcessEntry(lockPID, setup.ProcessEntry) {
+		coordinator.RemoveHeartbeat(lockFileName)
+	}
+
+	entryCount := 0
+
+	for _, lockEntry := range coordinator.Current.ReadEntries(lockFileName) {
+		variableTokens := strings.SplitN(lockEntry, " ", 2)
+
+		fileLockPID := variableTokens[0]
+
+		if utils.SameProcessEntry(fileLockPID, lockPID) {
+			logger.Debugf("Removing PID %s ...", fileLockPID)
+			coordinator.Current.RemoveEntry(lockFileName, lockEntry)
+			entryCount++
+		} else {
+			logger.Debugf("Keeping PID %s ...", fileLockPID)
+		}
+	}
+
+	if entryCount == 0 {
+		logger.Warnf("No entry found for %s. Must have been removed", lockPID)
+	} else {
+		logger.Info("Removed entry")
+	}
+}
 
+func checkLock( lockFileName string , lockName string , timeOutMins int ) {
 	logger.Debugf("Lock file -> %s", lockFileName)
 	logger.Debugf("Lock Name -> %s", lockName)
 	logger.Debugf("Time Out  -> %d mins", timeOutMins)
 
-	// First check to see if file exists
+	// First remove any entries left by dead processes
 
-	logger.Tracef("Attempting to open the file %s ...", lockFileName)
+	if len(coordinator.Current.ReadEntries(lockFileName)) > 0 {
+		logger.Infof("Lock entries for %s found. Checking contents ...", lockFileName)
 
-	if lockFile , err = os.Open(lockFileName); err == nil {
-		logger.Infof("File %s found. Checking contents ...", lockFileName)
+		CleanLockFile(lockFileName, lockName, 0)
+	} else {
+		logger.Debugf("No lock entries for %s", lockFileName)
+	}
 
-		lockFile.Close()
-		logger.Debug("Closed the file")
+	lockCounter := 0
+	logger.Debug("Initialized lock counter to zero")
 
-		CleanLockFile(lockFileName, lockName, 0)
+	// The check and the add are made under one lock so processes on other hosts cannot both pass the check
 
-		lockCounter := 0
-		logger.Debug("Initialized lock counter to zero")
+	for {
+		coordinator.Current.Lock(lockFileName,1)
 
-		// File may now not exist after cleaning - try opening it again in loop
+		lockFound := false
 
-		for {
-			lockFound := false
+		for _, lockEntry := range coordinator.Current.ReadEntries(lockFileName) {
+			variableTokens := strings.SplitN(lockEntry, " ", 2)
 
-			if lockFile , err = os.Open(lockFileName); err == nil {
-				logger.Debugf("Opened lock file %s", lockFileName)
+			lockPID, _, lockHost, _ := utils.SplitProcessEntry(variableTokens[0])
+			fileLockName := variableTokens[1]
 
-				// Now let's read the lock file for the lock entry
-				lockScanner := bufio.NewScanner(lockFile)
-	
-				logger.Debug("Scanning file ...")
+			logger.Debugf("Found PID %d with lock name %s", lockPID, fileLockName)
 
-				for lockScanner.Scan() {
-					variableTokens := strings.SplitN(lockScanner.Text(), " ", 2)
+			if fileLockName == lockName {
+				logger.Warnf("Process %d on host %s has already locked this process using lock name %s!", lockPID, lockHost, fileLockName)
+				lockFound = true
+				break
+			}
+		}
 
-					lockPID, _  := strconv.Atoi(variableTokens[0])
-					fileLockName := variableTokens[1]
+		if !lockFound {
+			addEntry(lockFileName, setup.ProcessEntry, lockName)
 
-					logger.Debugf("Found PID %d with lock name %s", lockPID, fileLockName)
+			coordinator.Current.Unlock(lockFileName)
 
-					if fileLockName == lockName {
-						logger.Warnf("Process %d has already locked this process using lock name %s!", lockPID, fileLockName)
-						lockFound = true
-						break
-					}
-				}
+			break
+		}
 
-				// Close file
-				lockFile.Close()
-				logger.Debug("Lock File closed")
+		coordinator.Current.Unlock(lockFileName)
 
-				if lockFound {
-					lockCounter++
-					logger.Debugf("Lock counter incremented to %d", lockCounter)
-				}
-			} else {
-				logger.Debugf("Unable to open file %s", lockFileName)
-			}
+		lockCounter++
+		logger.Debugf("Lock counter incremented to %d", lockCounter)
 
-			if lockFound {
-				if lockCounter > timeOutMins {
-					logger.Errorf("Unable to obtain the lock %s. Exiting ...", lockName)
-				}
-				logger.Info("Sleeping for 60 seconds ...")
-				time.Sleep(60 * time.Second)
-			} else {
-				break
-			}
-		} 
-	} else {
-		logger.Debugf("Unable to open file %s", lockFileName)
+		if lockCounter > timeOutMins {
+			logger.Errorf("Unable to obtain the lock %s. Exiting ...", lockName)
+		}
+		logger.Info("Sleeping for 60 seconds ...")
+		time.Sleep(60 * time.Second)
 	}
 
 	logger.Debug("Process complete")
@@ -112,11 +145,13 @@ func LockProcess (lockName, database string) {
 	if lockName != "" {
 		checkLockMins, _ := strconv.Atoi(config.ConfigValues["CheckLockMins"])
 
-		checkLock(setup.LockFileName, lockName, checkLockMins)
+		metrics.StartWait(metrics.LockWait)
 
-		// If we get to here then add the entry 
+		// Waits for the lock name to be free then adds our entry
 
-		AddLockEntry(setup.LockFileName, setup.CurrentPID,  lockName)
+		checkLock(setup.LockFileName, lockName, checkLockMins)
+
+		metrics.StopWait(metrics.LockWait)
 	} else {
 		logger.Info("No lock string provided. No locking necessary")
 	}
@@ -124,93 +159,36 @@ func LockProcess (lockName, database string) {
 	logger.Info("Process complete")
 }
 
-func RemoveLockEntry(lockFileName string, lockPID string) {
-	logger.Infof("Lock File : %s", lockFileName)
-	logger.Infof("Lock PID  : %s", lockPID)
+func GetLockEntries(lockFileName string) []string {
+	logger.Debugf("Getting lock entries for %s ...", lockFileName)
 
-	// To write the file - take a real lock
-	
-	filelock.LockFile(lockFileName,1)
-	
-	newLockFileName := strings.Join( []string{ lockFileName, setup.CurrentPID }, ".")
-	logger.Debugf("New lock file name set to %s", newLockFileName)
-
-	fileWriteSize   := 0
-
-	// Open the file for copying
-	if lockFile , err := os.Open(lockFileName); err == nil {
-		// Open a temp file for writing
-		if newLockFile, err := os.OpenFile(newLockFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err == nil {
-			// start scanning file and copying contents
-			lockScanner := bufio.NewScanner(lockFile)
-
-			for lockScanner.Scan() {
-				variableTokens := strings.SplitN(lockScanner.Text(), " ", 2)
-				
-				fileLockPID := variableTokens[0]
-
-				if fileLockPID != lockPID {
-					logger.Debugf("Found PID %s for writing ...", fileLockPID)
-					if bytesWritten, err := newLockFile.WriteString(lockScanner.Text()+"\n"); err != nil {
-						filelock.UnlockFile(lockFileName)
-						logger.Errorf("Unable to write to new lock file %s", newLockFileName)
-					} else {
-						fileWriteSize+=bytesWritten
-						logger.Debugf("Written %d bytes to new lock file, %d written so far", bytesWritten, fileWriteSize)
-					}
-				} else {
-					logger.Debugf("Ignoring PID %s ...", fileLockPID)
-				}
-			}
+	lockEntries := coordinator.Current.ReadEntries(lockFileName)
 
-			newLockFile.Close()
-			logger.Debug("New lock file closed")
-		} else {
-			filelock.UnlockFile(lockFileName)
-			logger.Errorf("Unable to create temp lock file %s", newLockFileName)
-		}
+	logger.Debugf("Returning %d entries", len(lockEntries))
 
-		lockFile.Close()
-		logger.Debug("Old lock file closed")
-	} else {
-		logger.Warnf("Unable to open the lock file %s. Must have been deleted", lockFileName)
-	}
+	return lockEntries
+}
 
-	// Check file exists and if it does replace the lock file
+func RemoveLockEntry(lockFileName string, lockPID string) {
+	// To write the entries - take a real lock
 	
-	if _, err := os.Stat(newLockFileName); err == nil {
-		logger.Debugf("Found file %s. Renaming to %s ...", newLockFileName, lockFileName)
-		if err := os.Rename(newLockFileName, lockFileName); err != nil {
-			filelock.UnlockFile(lockFileName)
-			logger.Errorf("Unable to move %s to %s", newLockFileName, lockFileName)
-		}
-		logger.Debug("Renamed file")
-	} else {
-		logger.Errorf("Unable to find new lock file %s", newLockFileName)
-	}
+	coordinator.Current.Lock(lockFileName,1)
 
-	logger.Info("Removed entry")
+	removeEntry(lockFileName, lockPID)
 
-	// Check size of remaining lock file and if 0 then remove it
+	// Unlock the entries
 
-	if fileWriteSize == 0 {
-		logger.Infof("Lock file now empty - removing ...")
-		if err := os.Remove(lockFileName); err != nil {
-			filelock.UnlockFile(lockFileName)
-			logger.Errorf("Unable to remove lock file %s", lockFileName)
-		}
-		logger.Debug("Successfully removed empty lock file")
-	} else {
-		logger.Debug("Lock file still contains entries.")
-	}
-	
-	// Unlock the file
-
-	filelock.UnlockFile(lockFileName)
+	coordinator.Current.Unlock(lockFileName)
 
 	logger.Debug("Process complete")
 }
 
+func RemoveHeldLockEntry(lockFileName string, lockPID string) {
+	// For callers already holding the coordinator lock on the lock file
+
+	removeEntry(lockFileName, lockPID)
+}
+
 func CleanLockFile(lockFileName string, lockName string, startingEntry int) []string {
 	logger.Info("Cleaning lock file of dead processes ...")
 
@@ -219,55 +197,57 @@ func CleanLockFile(lockFileName string, lockName string, startingEntry int) []st
 	lockCount := 0
 	lineCount := 0
 
-	if lockFile , err := os.Open(lockFileName); err == nil {
-		lockScanner := bufio.NewScanner(lockFile)
+	for _, lockEntry := range coordinator.Current.ReadEntries(lockFileName) {
+		variableTokens := strings.SplitN(lockEntry, " ", 2)
 
-		for lockScanner.Scan() {
-			variableTokens := strings.SplitN(lockScanner.Text(), " ", 2)
+		lockPID       := variableTokens[0]
 
-			lockPID       := variableTokens[0]
+		if !utils.SameProcessEntry(lockPID, setup.ProcessEntry) {
+			ilockPID, _, _, _ := utils.SplitProcessEntry(lockPID)
+			fileLockName := variableTokens[1]
 
-			if lockPID != setup.CurrentPID {
-				ilockPID, _   := strconv.Atoi(variableTokens[0])
-				fileLockName := variableTokens[1]
+			if fileLockName == lockName {
+				logger.Debugf("Found PID with lock name %s. Checking PID %d is still alive ...", fileLockName, ilockPID)
 
-				if fileLockName == lockName {
-					logger.Debugf("Found PID with lock name %s. Checking PID %d is still alive ...", fileLockName, ilockPID)
+				if pidAlive, pidIsName := coordinator.Current.CheckProcess(lockPID, setup.BaseName); pidAlive {
+					if pidIsName {
+						if leaseAge, expired := coordinator.LeaseExpired(lockPID); expired {
+							coordinator.ReportExpiredLease(lockFileName, lockPID, leaseAge)
 
-					if pidAlive, pidIsName := utils.CheckProcess(ilockPID, setup.BaseName); pidAlive {
-						if pidIsName {
-							logger.Infof("Process %d is running %s.  Valid entry", ilockPID, setup.BaseName)
-						} else {
-							if lineCount >= startingEntry {
-								logger.Warnf("Process %d is not running %s. Invalid entry. Removing ...", ilockPID, setup.BaseName)
+							if coordinator.BreakExpiredLeases() {
+								logger.Warnf("Breaking expired lease for process %d ...", ilockPID)
 
-							
 								lockPIDS = append(lockPIDS,lockPID)
 								lockCount++
-							} else {
-								logger.Warnf("Process %d is not running %s. But is at line %d in lock file. Keeping ...", ilockPID, setup.BaseName)
 							}
+						} else {
+							logger.Infof("Process %d is running %s.  Valid entry", ilockPID, setup.BaseName)
 						}
 					} else {
-						logger.Warnf("Old PID %d found in lock file and is no longer running. Removing ...", ilockPID)
+						if lineCount >= startingEntry {
+							logger.Warnf("Process %d is not running %s. Invalid entry. Removing ...", ilockPID, setup.BaseName)
 
-						lockPIDS = append(lockPIDS,lockPID)
-						lockCount++
+						
+							lockPIDS = append(lockPIDS,lockPID)
+							lockCount++
+						} else {
+							logger.Warnf("Process %d is not running %s. But is at line %d in lock file. Keeping ...", ilockPID, setup.BaseName, lineCount)
+						}
 					}
 				} else {
-					logger.Debugf("Found PID %d for lock name %s", ilockPID, fileLockName)
+					logger.Warnf("Old PID %d found in lock file and is no longer running. Removing ...", ilockPID)
+
+					lockPIDS = append(lockPIDS,lockPID)
+					lockCount++
 				}
 			} else {
-				logger.Debug("PID found is current PID.  Ignoring ...")
+				logger.Debugf("Found PID %d for lock name %s", ilockPID, fileLockName)
 			}
-
-			lineCount++
+		} else {
+			logger.Debug("Entry found is current process.  Ignoring ...")
 		}
 
-		lockFile.Close()
-		logger.Debug("Closed lock file")
-	} else {
-		logger.Infof("Lock file not longer present")
+		lineCount++
 	}
 
 	// Remove any PIDs found
@@ -285,30 +265,21 @@ func CleanLockFile(lockFileName string, lockName string, startingEntry int) []st
 func AddLockEntry(lockFileName, pid, lockName string) {
 	logger.Debug("Adding lock entry ...")
 
-	// To write the file - take a real lock
+	// To write the entries - take a real lock
 	
-	filelock.LockFile(lockFileName,1)
+	coordinator.Current.Lock(lockFileName,1)
 
-	if lockFile, err := os.OpenFile(lockFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err == nil {
-		writeString := strings.Join( []string{ pid, " ", lockName }, "")
-	
-		if _, err := lockFile.WriteString(writeString+"\n"); err != nil {
-			filelock.UnlockFile(lockFileName)
-			logger.Errorf("Unable to write to lockfile %s", lockFileName)
-		} else {
-			logger.Infof("Added entry %s to lock file", writeString)
-		}
+	addEntry(lockFileName, pid, lockName)
 
-		lockFile.Close()
-		logger.Debug("Closed lock file")
-	} else {
-		filelock.UnlockFile(lockFileName)
-		logger.Errorf("Unable to open file %s", lockFileName)
-	}
-		
-	// Unlock the file
+	// Unlock the entries
 
-	filelock.UnlockFile(lockFileName)
+	coordinator.Current.Unlock(lockFileName)
 
 	logger.Debug("Process complete")
 }
+
+func AddHeldLockEntry(lockFileName, pid, lockName string) {
+	// For callers already holding the coordinator lock on the lock file
+
+	addEntry(lockFileName, pid, lockName)
+}
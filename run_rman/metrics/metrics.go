@@ -0,0 +1,259 @@
+package metrics
+
+// Standard imports
+
+import "bufio"
+import "bytes"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "os"
+import "path/filepath"
+import "regexp"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/run_rman/config"
+
+// Global Variables
+
+// Waits timed for the metrics
+
+const LockWait     = "lock"
+const ResourceWait = "resource"
+
+// local Variables
+
+// waitTimer adds up the time spent waiting. A wait still running when the metrics are written e.g. on a time out is included
+
+type waitTimer struct {
+	started time.Time
+	total   time.Duration
+}
+
+var waits = map[string]*waitTimer{
+	LockWait     : &waitTimer{},
+	ResourceWait : &waitTimer{},
+}
+
+var errorCount   int
+
+var metricsMutex sync.Mutex
+
+var statuses     = []string{ "SUCCESS", "FAILURE", "SKIPPED" }
+
+var labelRegEx   = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+var pushClient   = &http.Client{ Timeout: 10 * time.Second }
+
+const lastSuccessName = "run_rman_last_success_timestamp_seconds"
+
+// Local functions
+
+func (w *waitTimer) elapsed(now time.Time) time.Duration {
+	if w.started.IsZero() {
+		return w.total
+	}
+
+	return w.total + now.Sub(w.started)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func getFileName(database string, script string) string {
+	// Each database and script has its own file so runs never write the same one
+	// Patterns such as PROD* used to run many databases are made safe for the file name
+
+	fileName := strings.Join( []string{ "run_rman", labelRegEx.ReplaceAllString(database, "_"), labelRegEx.ReplaceAllString(script, "_") }, "_")
+
+	return filepath.Join(config.ConfigValues["MetricsDir"], strings.Join( []string{ fileName, "prom" }, "."))
+}
+
+func getLastSuccess(fileName string) string {
+	// The last success is carried over from the file written by the previous run
+
+	metricsFile, err := os.Open(fileName)
+	if err != nil {
+		return ""
+	}
+
+	defer metricsFile.Close()
+
+	scanner := bufio.NewScanner(metricsFile)
+
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && strings.HasPrefix(fields[0], lastSuccessName + "{") {
+			return fields[1]
+		}
+	}
+
+	return ""
+}
+
+func formatMetrics(database string, script string, status string, duration time.Duration, backupBytes int64, now time.Time, lastSuccess string) string {
+	var text bytes.Buffer
+
+	labels := fmt.Sprintf(`database="%s",script="%s"`, escapeLabel(database), escapeLabel(script))
+
+	gauge := func(name string, help string, labelText string, value string) {
+		fmt.Fprintf(&text, "# HELP %s %s\n# TYPE %s gauge\n%s{%s} %s\n", name, help, name, name, labelText, value)
+	}
+
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+	}
+
+	gauge("run_rman_last_run_timestamp_seconds", "Time the last run of the script finished", labels, strconv.FormatInt(now.Unix(), 10))
+	gauge("run_rman_last_run_duration_seconds", "Elapsed time of the last run", labels, seconds(duration))
+
+	// One series per status with 1 against the status of the last run
+
+	fmt.Fprint(&text, "# HELP run_rman_last_run_status Status of the last run\n# TYPE run_rman_last_run_status gauge\n")
+
+	for _, runStatus := range statuses {
+		value := 0
+
+		if runStatus == status {
+			value = 1
+		}
+
+		fmt.Fprintf(&text, "run_rman_last_run_status{%s,status=\"%s\"} %d\n", labels, runStatus, value)
+	}
+
+	if status == "SUCCESS" {
+		lastSuccess = strconv.FormatInt(now.Unix(), 10)
+	}
+
+	if lastSuccess != "" {
+		gauge(lastSuccessName, "Time the script last finished successfully", labels, lastSuccess)
+	}
+
+	gauge("run_rman_last_run_bytes_written", "Size of the backups written by the last run", labels, strconv.FormatInt(backupBytes, 10))
+	gauge("run_rman_last_run_errors", "ORA- and RMAN- errors not ignored in the RMAN output of the last run", labels, strconv.Itoa(errorCount))
+	gauge("run_rman_last_run_lock_wait_seconds", "Time the last run waited for its lock", labels, seconds(waits[LockWait].elapsed(now)))
+	gauge("run_rman_last_run_resource_wait_seconds", "Time the last run waited for its resources", labels, seconds(waits[ResourceWait].elapsed(now)))
+
+	return text.String()
+}
+
+func writeFile(fileName string, text string) error {
+	// Written to a name the textfile collector ignores then renamed so it never reads part of the file
+
+	tmpFileName := strings.Join( []string{ fileName, setup.CurrentPID }, ".")
+
+	if err := ioutil.WriteFile(tmpFileName, []byte(text), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+
+	return nil
+}
+
+func push(pushURL string, database string, script string, text string) error {
+	// PUT replaces all the metrics of the group for the database and script
+
+	groupURL := strings.Join( []string{ strings.TrimRight(pushURL, "/"), "metrics", "job", "run_rman", "database", url.PathEscape(database), "script", url.PathEscape(script) }, "/")
+
+	request, err := http.NewRequest("PUT", groupURL, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	response, err := pushClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", groupURL, response.Status)
+	}
+
+	return nil
+}
+
+// Global functions
+
+func StartWait(name string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	waits[name].started = time.Now()
+}
+
+func StopWait(name string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	wait := waits[name]
+
+	wait.total   = wait.elapsed(time.Now())
+	wait.started = time.Time{}
+}
+
+func AddErrors(count int) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	errorCount += count
+}
+
+func Write(database string, script string, status string, duration time.Duration, backupBytes int64) {
+	// Called as the history is written so must not fail the run
+
+	metricsDir := config.ConfigValues["MetricsDir"]
+	pushURL    := config.ConfigValues["MetricsPushURL"]
+
+	if (metricsDir == "" && pushURL == "") || database == "" || script == "" {
+		return
+	}
+
+	logger.Debug("Writing metrics ...")
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	now := time.Now()
+
+	lastSuccess := ""
+
+	if metricsDir != "" {
+		lastSuccess = getLastSuccess(getFileName(database, script))
+	}
+
+	text := formatMetrics(database, script, status, duration, backupBytes, now, lastSuccess)
+
+	if metricsDir != "" {
+		if err := writeFile(getFileName(database, script), text); err != nil {
+			logger.Warnf("Unable to write metrics to %s - %s", metricsDir, err)
+		} else {
+			logger.Debugf("Metrics written to %s", getFileName(database, script))
+		}
+	}
+
+	if pushURL != "" {
+		if err := push(pushURL, database, script, text); err != nil {
+			logger.Warnf("Unable to push metrics to %s - %s", pushURL, err)
+		} else {
+			logger.Debugf("Metrics pushed to %s", pushURL)
+		}
+	}
+
+	logger.Debug("Process complete")
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/config"
+)
+
+func resetMetrics() {
+	errorCount = 0
+
+	for _, wait := range waits {
+		*wait = waitTimer{}
+	}
+
+	config.ConfigValues["MetricsDir"] = ""
+	config.ConfigValues["MetricsPushURL"] = ""
+}
+
+func TestFormatMetrics(t *testing.T) {
+	defer resetMetrics()
+
+	now := time.Unix(1700000000, 0)
+
+	AddErrors(2)
+	AddErrors(1)
+
+	waits[LockWait].total = 90 * time.Second
+
+	// A wait not stopped e.g. on a time out counts up to now
+	waits[ResourceWait].started = now.Add(-30 * time.Second)
+
+	text := formatMetrics("ORCL", `odd"name`, "FAILURE", 125*time.Second, 1024, now, "1600000000")
+
+	for _, expected := range []string{
+		`run_rman_last_run_timestamp_seconds{database="ORCL",script="odd\"name"} 1700000000`,
+		`run_rman_last_run_duration_seconds{database="ORCL",script="odd\"name"} 125.000`,
+		`run_rman_last_run_status{database="ORCL",script="odd\"name",status="SUCCESS"} 0`,
+		`run_rman_last_run_status{database="ORCL",script="odd\"name",status="FAILURE"} 1`,
+		`run_rman_last_success_timestamp_seconds{database="ORCL",script="odd\"name"} 1600000000`,
+		`run_rman_last_run_bytes_written{database="ORCL",script="odd\"name"} 1024`,
+		`run_rman_last_run_errors{database="ORCL",script="odd\"name"} 3`,
+		`run_rman_last_run_lock_wait_seconds{database="ORCL",script="odd\"name"} 90.000`,
+		`run_rman_last_run_resource_wait_seconds{database="ORCL",script="odd\"name"} 30.000`,
+		"# TYPE run_rman_last_run_status gauge",
+	} {
+		if !strings.Contains(text, expected+"\n") {
+			t.Fatalf("missing %s in:\n%s", expected, text)
+		}
+	}
+
+	// Without a previous success there is no last success
+	if text := formatMetrics("ORCL", "backup", "FAILURE", 0, 0, now, ""); strings.Contains(text, lastSuccessName) {
+		t.Fatalf("bad:\n%s", text)
+	}
+}
+
+func TestWaits(t *testing.T) {
+	defer resetMetrics()
+
+	StartWait(LockWait)
+	time.Sleep(10 * time.Millisecond)
+	StopWait(LockWait)
+
+	first := waits[LockWait].elapsed(time.Now())
+
+	time.Sleep(10 * time.Millisecond)
+
+	if first < 10*time.Millisecond || waits[LockWait].elapsed(time.Now()) != first {
+		t.Fatalf("bad: %s", first)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	defer resetMetrics()
+
+	metricsDir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(metricsDir)
+
+	var pushed []string
+	var body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, _ := ioutil.ReadAll(r.Body)
+
+		pushed = append(pushed, r.Method+" "+r.URL.Path)
+		body = string(contents)
+	}))
+	defer server.Close()
+
+	config.ConfigValues["MetricsDir"] = metricsDir
+	config.ConfigValues["MetricsPushURL"] = server.URL + "/"
+
+	Write("ORCL", "backup_full", "SUCCESS", time.Minute, 2048)
+
+	fileName := filepath.Join(metricsDir, "run_rman_ORCL_backup_full.prom")
+
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	lastSuccess := getLastSuccess(fileName)
+
+	if lastSuccess == "" || body != string(contents) {
+		t.Fatalf("bad: %s\n%s", lastSuccess, contents)
+	}
+
+	if len(pushed) != 1 || pushed[0] != "PUT /metrics/job/run_rman/database/ORCL/script/backup_full" {
+		t.Fatalf("bad: %v", pushed)
+	}
+
+	// A failure keeps the last success and leaves no temporary file behind
+	Write("ORCL", "backup_full", "FAILURE", time.Minute, 0)
+
+	if getLastSuccess(fileName) != lastSuccess {
+		t.Fatalf("bad: %s", getLastSuccess(fileName))
+	}
+
+	files, _ := filepath.Glob(filepath.Join(metricsDir, "*"))
+
+	if len(files) != 1 {
+		t.Fatalf("bad: %v", files)
+	}
+
+	// Patterns used to run many databases are safe in the file name
+	Write("PROD*", "backup_full", "SUCCESS", time.Minute, 0)
+
+	if _, err := os.Stat(filepath.Join(metricsDir, "run_rman_PROD__backup_full.prom")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Nothing is written without a database and script e.g. for the status command
+	Write("ORCL", "", "FAILURE", time.Minute, 0)
+
+	if len(pushed) != 3 {
+		t.Fatalf("bad: %v", pushed)
+	}
+}
+
+func TestPushFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := push(server.URL, "ORCL", "backup_full", "bad"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("bad: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package oracle
+
+// Standard imports
+
+import "context"
+import "database/sql"
+import "fmt"
+import "regexp"
+import "strconv"
+import "strings"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/utils"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/environment"
+import "github.com/daviesluke/mattn/go-oci8"
+
+// Global Variables
+
+// DatabaseStatus describes the target database as found when checking the connection
+
+type DatabaseStatus struct {
+	InstanceName string
+	Status       string
+	DatabaseRole string
+	OpenMode     string
+	LogMode      string
+	Version      string
+}
+
+// Target holds the status of the target database once the connections have been checked
+
+var Target DatabaseStatus
+
+// local Variables
+
+var driverName = "oci8"
+
+var oraRegEx = regexp.MustCompile(`ORA-[0-9]{5}`)
+
+var databaseRoles = []string{ "PRIMARY", "PHYSICAL STANDBY", "LOGICAL STANDBY", "SNAPSHOT STANDBY" }
+
+// errorClasses groups the ORA errors seen when connecting by their likely cause
+// Only classes marked retry can succeed by trying again
+
+var errorClasses = []struct {
+	class string
+	retry bool
+	hint  string
+	codes []string
+}{
+	{ "listener"     , true , "the listener is running and the connect identifier is correct"                  , []string{ "ORA-12154", "ORA-12170", "ORA-12505", "ORA-12514", "ORA-12535", "ORA-12537", "ORA-12541", "ORA-12543", "ORA-12545", "ORA-12560" } },
+	{ "credentials"  , false, "the user name and password and that the account is not locked or expired"      , []string{ "ORA-01005", "ORA-01017", "ORA-28000", "ORA-28001" } },
+	{ "instance down", true , "the instance is started and not shutting down"                                , []string{ "ORA-01033", "ORA-01034", "ORA-01089", "ORA-12528", "ORA-27101" } },
+	{ "not SYSDBA"   , false, "the user has been granted SYSDBA and the password file is in place"            , []string{ "ORA-01031", "ORA-01994", "ORA-28009" } },
+}
+
+// Local functions
+
+func getConfigInt(configName string) int {
+	configValue, err := strconv.Atoi(config.ConfigValues[configName])
+	if err != nil || configValue < 0 {
+		logger.Errorf("%s must be a whole number not %s", configName, config.ConfigValues[configName])
+	}
+
+	return configValue
+}
+
+func classifyError(err error) (string, bool, string) {
+	// Returns the class of the error, whether to retry and what to check
+
+	if err == context.DeadlineExceeded {
+		return "timeout", true, "the database host is reachable and ConnectTimeoutSecs is long enough"
+	}
+
+	errorCode := oraRegEx.FindString(err.Error())
+
+	for _, errorClass := range errorClasses {
+		for _, code := range errorClass.codes {
+			if code == errorCode {
+				return errorClass.class, errorClass.retry, errorClass.hint
+			}
+		}
+	}
+
+	return "unknown", true, "the database alert log and listener log"
+}
+
+func pingDatabase(db *sql.DB) error {
+	timeout := time.Duration(getConfigInt("ConnectTimeoutSecs")) * time.Second
+
+	if timeout == 0 {
+		return db.Ping()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Some drivers do not honour the context so give up waiting regardless
+
+	pingResult := make(chan error, 1)
+
+	go func() {
+		pingResult <- db.PingContext(ctx)
+	}()
+
+	select {
+	case err := <-pingResult:
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			return context.DeadlineExceeded
+		}
+
+		return err
+	case <-ctx.Done():
+		return context.DeadlineExceeded
+	}
+}
+
+func openConnection(connString string) (*sql.DB, error) {
+	logger.Debug("Opening connection ...")
+
+	dsn, err := oci8.ParseDSN(connString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string - %s", err)
+	}
+
+	logger.Debugf("Connecting to %s as %s", dsn.Connect, dsn.Username)
+
+	environment.SetClient()
+
+	db, err := sql.Open(driverName, connString)
+	if err != nil {
+		return nil, err
+	}
+
+	retries    := getConfigInt("ConnectRetries")
+	retryDelay := time.Duration(getConfigInt("ConnectRetryDelaySecs")) * time.Second
+
+	for attempt := 0; ; attempt++ {
+		err = pingDatabase(db)
+
+		if err == nil {
+			break
+		}
+
+		errorClass, retry, hint := classifyError(err)
+
+		if !retry || attempt >= retries {
+			db.Close()
+
+			return nil, fmt.Errorf("%s (%s) - check %s", err, errorClass, hint)
+		}
+
+		logger.Warnf("Connection attempt %d failed with %s (%s). Retrying in %s ...", attempt + 1, err, errorClass, retryDelay)
+
+		time.Sleep(retryDelay)
+	}
+
+	logger.Debug("Process complete")
+
+	return db, nil
+}
+
+func getDatabaseStatus(db *sql.DB) (DatabaseStatus, error) {
+	logger.Debug("Getting database status ...")
+
+	var status DatabaseStatus
+
+	if err := db.QueryRow("select instance_name, status, version from v$instance").Scan(&status.InstanceName, &status.Status, &status.Version); err != nil {
+		return status, err
+	}
+
+	// V$DATABASE is only available once the control file has been read
+
+	if status.Status == "STARTED" {
+		status.OpenMode = "NOT MOUNTED"
+		return status, nil
+	}
+
+	if err := db.QueryRow("select database_role, open_mode, log_mode from v$database").Scan(&status.DatabaseRole, &status.OpenMode, &status.LogMode); err != nil {
+		return status, err
+	}
+
+	logger.Debug("Process complete")
+
+	return status, nil
+}
+
+func checkDatabaseStatus(db *sql.DB) {
+	logger.Info("Checking target database status ...")
+
+	status, err := getDatabaseStatus(db)
+	if err != nil {
+		logger.Errorf("Unable to get the status of the target database - %s", err)
+	}
+
+	logger.Infof("Instance      -> %s", status.InstanceName)
+	logger.Infof("Status        -> %s", status.Status)
+	logger.Infof("Database role -> %s", status.DatabaseRole)
+	logger.Infof("Open mode     -> %s", status.OpenMode)
+	logger.Infof("Log mode      -> %s", status.LogMode)
+	logger.Infof("Version       -> %s", status.Version)
+
+	if status.Status == "STARTED" {
+		logger.Warnf("Instance %s is not mounted. RMAN needs at least a mounted database to back up", status.InstanceName)
+	}
+
+	if status.LogMode == "NOARCHIVELOG" && status.OpenMode != "MOUNTED" {
+		logger.Warnf("Database is in NOARCHIVELOG mode. Only backups of a mounted database will succeed")
+	}
+
+	Target = status
+
+	logger.Info("Process complete")
+}
+
+func RoleMatches(runOnRole string, databaseRole string) (bool, error) {
+	// RunOnRole may list several roles separated by commas e.g. PRIMARY,SNAPSHOT STANDBY
+
+	for _, role := range strings.Split(strings.ToUpper(runOnRole), ",") {
+		role = strings.Join(strings.Fields(role), " ")
+
+		if role == "ANY" || role == "" {
+			return true, nil
+		}
+
+		found := false
+
+		for _, knownRole := range databaseRoles {
+			found = found || knownRole == role
+		}
+
+		if !found {
+			return false, fmt.Errorf("unknown role %s in RunOnRole", role)
+		}
+
+		if role == databaseRole {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func connectionFailed(connectionName string, connString string, err error) {
+	logger.Errorf("Unable to connect to %s database using %s - %s", connectionName, utils.RemovePassword(connString,false), err)
+}
@@ -0,0 +1,225 @@
+package oracle
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/run_rman/internal/testdb"
+)
+
+// testDatabase fails to connect with each of failures in turn then answers the status queries
+type testDatabase struct {
+	sync.Mutex
+	failures []string
+	delay    time.Duration
+	attempts int
+	instance []driver.Value
+	database []driver.Value
+	executed []string
+}
+
+func (d *testDatabase) connect() error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.attempts++
+
+	if len(d.failures) > 0 {
+		failure := d.failures[0]
+		d.failures = d.failures[1:]
+
+		return errors.New(failure)
+	}
+
+	time.Sleep(d.delay)
+
+	return nil
+}
+
+func (d *testDatabase) exec(query string, args []driver.Value) (driver.Result, error) {
+	if strings.Contains(query, "missing_table") {
+		return nil, errors.New("ORA-00942: table or view does not exist")
+	}
+
+	d.executed = append(d.executed, query)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (d *testDatabase) query(query string, args []driver.Value) ([][]driver.Value, error) {
+	if strings.Contains(query, "v$instance") {
+		return [][]driver.Value{d.instance}, nil
+	}
+
+	if d.database == nil {
+		return nil, errors.New("ORA-01507: database not mounted")
+	}
+
+	return [][]driver.Value{d.database}, nil
+}
+
+func useTestDriver(t *testing.T, failures ...string) *testDatabase {
+	d := &testDatabase{failures: failures}
+
+	sql.Register("oracletest"+t.Name(), &testdb.Driver{Connect: d.connect, Exec: d.exec, Query: d.query})
+
+	driverName = "oracletest" + t.Name()
+
+	config.ConfigValues["ConnectRetryDelaySecs"] = "0"
+
+	return d
+}
+
+func resetTestDriver() {
+	driverName = "oci8"
+
+	config.ConfigValues["ConnectRetries"] = "2"
+	config.ConfigValues["ConnectRetryDelaySecs"] = "10"
+	config.ConfigValues["ConnectTimeoutSecs"] = "30"
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := map[string]string{
+		"ORA-12541: TNS:no listener":                         "listener",
+		"ORA-01017: invalid username/password; logon denied": "credentials",
+		"ORA-01034: ORACLE not available":                    "instance down",
+		"ORA-01031: insufficient privileges":                 "not SYSDBA",
+		"something else":                                     "unknown",
+	}
+
+	for message, expected := range cases {
+		if class, _, _ := classifyError(errors.New(message)); class != expected {
+			t.Fatalf("bad: %s -> %s", message, class)
+		}
+	}
+
+	if class, retry, _ := classifyError(context.DeadlineExceeded); class != "timeout" || !retry {
+		t.Fatalf("bad: %s %t", class, retry)
+	}
+}
+
+func TestOpenConnectionRetry(t *testing.T) {
+	d := useTestDriver(t, "ORA-12541: TNS:no listener", "ORA-01034: ORACLE not available")
+	defer resetTestDriver()
+
+	db, err := openConnection("rman/pass@cat")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	db.Close()
+
+	if d.attempts != 3 {
+		t.Fatalf("bad: %d", d.attempts)
+	}
+
+	// Giving up after the configured retries
+	d.failures = []string{"ORA-12541: TNS:no listener", "ORA-12541: TNS:no listener"}
+	d.attempts = 0
+	config.ConfigValues["ConnectRetries"] = "1"
+
+	if _, err := openConnection("rman/pass@cat"); err == nil || !strings.Contains(err.Error(), "(listener)") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	if d.attempts != 2 {
+		t.Fatalf("bad: %d", d.attempts)
+	}
+}
+
+func TestOpenConnectionNoRetry(t *testing.T) {
+	d := useTestDriver(t, "ORA-01017: invalid username/password; logon denied")
+	defer resetTestDriver()
+
+	// Bad credentials are never retried so accounts are not locked
+	if _, err := openConnection("rman/wrong@cat"); err == nil || !strings.Contains(err.Error(), "(credentials)") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	if d.attempts != 1 {
+		t.Fatalf("bad: %d", d.attempts)
+	}
+}
+
+func TestOpenConnectionTimeout(t *testing.T) {
+	d := useTestDriver(t)
+	defer resetTestDriver()
+
+	d.delay = 3 * time.Second
+	config.ConfigValues["ConnectTimeoutSecs"] = "1"
+	config.ConfigValues["ConnectRetries"] = "0"
+
+	start := time.Now()
+
+	if _, err := openConnection("rman/pass@cat"); err == nil || !strings.Contains(err.Error(), "(timeout)") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("did not time out: %s", time.Since(start))
+	}
+}
+
+func TestGetDatabaseStatus(t *testing.T) {
+	d := useTestDriver(t)
+	defer resetTestDriver()
+
+	d.instance = []driver.Value{"ORCL", "OPEN", "19.0.0.0.0"}
+	d.database = []driver.Value{"PRIMARY", "READ WRITE", "ARCHIVELOG"}
+
+	db, err := openConnection("/@?as=sysdba")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+
+	status, err := getDatabaseStatus(db)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if status != (DatabaseStatus{"ORCL", "OPEN", "PRIMARY", "READ WRITE", "ARCHIVELOG", "19.0.0.0.0"}) {
+		t.Fatalf("bad: %#v", status)
+	}
+
+	// A started instance has no V$DATABASE
+	d.instance = []driver.Value{"ORCL", "STARTED", "19.0.0.0.0"}
+	d.database = nil
+
+	if status, err = getDatabaseStatus(db); err != nil || status.OpenMode != "NOT MOUNTED" {
+		t.Fatalf("bad: %#v %v", status, err)
+	}
+}
+
+func TestRoleMatches(t *testing.T) {
+	cases := []struct {
+		runOnRole string
+		role      string
+		matches   bool
+	}{
+		{"ANY", "PHYSICAL STANDBY", true},
+		{"", "PRIMARY", true},
+		{"PRIMARY", "PRIMARY", true},
+		{"primary", "PHYSICAL STANDBY", false},
+		{"Physical  Standby", "PHYSICAL STANDBY", true},
+		{"PRIMARY,SNAPSHOT STANDBY", "SNAPSHOT STANDBY", true},
+		{"PRIMARY, LOGICAL STANDBY", "PHYSICAL STANDBY", false},
+	}
+
+	for _, c := range cases {
+		if matches, err := RoleMatches(c.runOnRole, c.role); err != nil || matches != c.matches {
+			t.Fatalf("bad: %q %q %t %v", c.runOnRole, c.role, matches, err)
+		}
+	}
+
+	if _, err := RoleMatches("STANDBY", "PHYSICAL STANDBY"); err == nil {
+		t.Fatal("should error")
+	}
+}
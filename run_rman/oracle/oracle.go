@@ -8,59 +8,73 @@ import "strings"
 // Local imports
 
 import "github.com/daviesluke/logger"
-import "github.com/daviesluke/setup"
 import "github.com/daviesluke/utils"
 import "github.com/daviesluke/run_rman/config"
-import _ "github.com/daviesluke/mattn/go-oci8"
+import "github.com/daviesluke/run_rman/credential"
 
 // local variables
 
 // local functions
 
-func checkConnection (connString string) {
+func checkConnection (connString string, connectionName string) *sql.DB {
 	logger.Debug("Checking connection ...")
 
-	logger.Debugf("Connection string -> %s", connString)
+	logger.Debugf("Connection string -> %s", utils.RemovePassword(connString,false))
 
-	if db, err := sql.Open("oci8", connString); err == nil {
-
-		if err = db.Ping(); err != nil {
-			logger.Errorf("Unable to connect to database %s using %s", setup.Database, utils.RemovePassword(connString,false))
-		} else {
-			logger.Info("Successfully connected to the target database")
-		}
-		
-		db.Close()
+	db, err := openConnection(connString)
+	if err != nil {
+		connectionFailed(connectionName, connString, err)
 	}
-	
-	logger.Debug("Process complete")
-}
-	
 
-func checkTargetConnection () {
-	logger.Info("Checking target connection ...")
+	logger.Infof("Successfully connected to the %s database", connectionName)
+
+	logger.Debug("Process complete")
 
-	targetConnection := config.ConfigValues["TargetConnection"]
+	return db
+}
 
-	if targetConnection == "/" {
-		targetConnection = "/@?as=sysdba" // sys/.@?as=sysdba
+func sysdbaConnectionString (connection string) string {
+	if connection == "/" {
+		connection = "/@?as=sysdba" // sys/.@?as=sysdba
 	} else {
 		// If starts with SYS then add as=sysdba
 		regEx := "^[Ss][Yy][Ss][@/].+$"
 
-		if utils.CheckRegEx(targetConnection,regEx) {
+		if utils.CheckRegEx(connection,regEx) {
 			// Check if it has a connection part 
 			regEx = ".+@.+"
 
-			if utils.CheckRegEx(targetConnection,regEx) {
-				targetConnection = strings.Join( []string{ targetConnection , "as=sysdba"} , "?")
+			if utils.CheckRegEx(connection,regEx) {
+				connection = strings.Join( []string{ connection , "as=sysdba"} , "?")
 			} else {
-				targetConnection = strings.Join( []string{ targetConnection , "as=sysdba"} , "@?")
+				connection = strings.Join( []string{ connection , "as=sysdba"} , "@?")
 			}
 		}
 	}
 
-	checkConnection(targetConnection)
+	return connection
+}
+
+func targetConnectionString () string {
+	return sysdbaConnectionString(credential.Connection("TargetConnection"))
+}
+
+func auxiliaryConnectionString () string {
+	if config.ConfigValues["AuxiliaryConnection"] == "" {
+		logger.Errorf("AuxiliaryConnection must be set to duplicate the database")
+	}
+
+	return sysdbaConnectionString(credential.Connection("AuxiliaryConnection"))
+}
+
+func checkTargetConnection () {
+	logger.Info("Checking target connection ...")
+
+	db := checkConnection(targetConnectionString(), "target")
+
+	checkDatabaseStatus(db)
+
+	db.Close()
 
 	logger.Debug("Process complete")
 }
@@ -68,12 +82,10 @@ func checkTargetConnection () {
 func checkCatalogConnection () {
 	logger.Info("Checking catalog connection ...")
 
-	catalogConnection := config.ConfigValues["CatalogConnection"]
-
-	if catalogConnection == "" { 
+	if config.ConfigValues["CatalogConnection"] == "" { 
 		logger.Infof("No RMAN catalog has been configured - running with control file only")
 	} else {
-		checkConnection(catalogConnection)
+		checkConnection(credential.Connection("CatalogConnection"), "catalog").Close()
 	}
 
 	logger.Debug("Process complete")
@@ -82,8 +94,90 @@ func checkCatalogConnection () {
 
 // Global functions
 
+func OpenCatalog () *sql.DB {
+	logger.Info("Opening catalog connection ...")
+
+	if config.ConfigValues["CatalogConnection"] == "" {
+		logger.Errorf("CatalogConnection must be set to use the catalog database")
+	}
+
+	catalogConnection := credential.Connection("CatalogConnection")
+
+	db, err := openConnection(catalogConnection)
+	if err != nil {
+		connectionFailed("catalog", catalogConnection, err)
+	}
+
+	logger.Debug("Process complete")
+
+	return db
+}
+
 func CheckConnections () {
 	checkTargetConnection()
 
 	checkCatalogConnection()
 }
+
+func CheckAuxiliaryConnection () {
+	logger.Info("Checking auxiliary connection ...")
+
+	db := checkConnection(auxiliaryConnectionString(), "auxiliary")
+
+	defer db.Close()
+
+	status, err := getDatabaseStatus(db)
+	if err != nil {
+		logger.Errorf("Unable to get the status of the auxiliary instance - %s", err)
+	}
+
+	// DUPLICATE creates the control file so the instance must only be started
+
+	if status.Status != "STARTED" {
+		logger.Errorf("Auxiliary instance %s is %s. It must be started NOMOUNT to duplicate to", status.InstanceName, status.Status)
+	}
+
+	logger.Infof("Auxiliary instance %s is started NOMOUNT", status.InstanceName)
+
+	logger.Debug("Process complete")
+}
+
+func OpenAuxiliary () *sql.DB {
+	logger.Info("Opening auxiliary connection ...")
+
+	auxiliaryConnection := auxiliaryConnectionString()
+
+	db, err := openConnection(auxiliaryConnection)
+	if err != nil {
+		connectionFailed("auxiliary", auxiliaryConnection, err)
+	}
+
+	logger.Debug("Process complete")
+
+	return db
+}
+
+func CheckRole () bool {
+	logger.Info("Checking database role ...")
+
+	runOnRole := config.ConfigValues["RunOnRole"]
+
+	if Target.DatabaseRole == "" && strings.ToUpper(strings.TrimSpace(runOnRole)) != "ANY" {
+		logger.Errorf("Unable to get the role of database %s to compare with RunOnRole %s", Target.InstanceName, runOnRole)
+	}
+
+	matches, err := RoleMatches(runOnRole, Target.DatabaseRole)
+	if err != nil {
+		logger.Errorf("Invalid RunOnRole - %s", err)
+	}
+
+	if matches {
+		logger.Infof("Database role %s matches RunOnRole %s", Target.DatabaseRole, runOnRole)
+	} else {
+		logger.Infof("Database role %s does not match RunOnRole %s", Target.DatabaseRole, runOnRole)
+	}
+
+	logger.Info("Process complete")
+
+	return matches
+}
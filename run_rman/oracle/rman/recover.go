@@ -0,0 +1,175 @@
+package rman
+
+// Standard imports
+
+import "os"
+import "path/filepath"
+import "regexp"
+import "sort"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/coordinator"
+import "github.com/daviesluke/run_rman/locker"
+
+// local functions
+
+func removeResetFile(resetFileName string) {
+	logger.Debugf("Removing reset file %s ...", resetFileName)
+
+	if _, err := os.Stat(resetFileName); err != nil {
+		logger.Debugf("File %s has already been removed", resetFileName)
+	} else if err := os.Remove(resetFileName); err != nil {
+		logger.Errorf("Unable to remove old config file %s", resetFileName)
+	}
+}
+
+func findResetFiles(baseDir string, baseFileName string) []string {
+	logger.Debugf("Finding reset files for %s in %s ...", baseFileName, baseDir)
+
+	regEx := strings.Join( []string{ "^", regexp.QuoteMeta(baseFileName), "\\.[^.]+\\.reset$" }, "")
+
+	resetFiles := utils.FindFiles(baseDir, regEx, 0)
+
+	// Oldest first as the oldest file holds the configuration before any run changed it
+
+	sort.Slice(resetFiles, func(i, j int) bool {
+		iInfo, iErr := os.Stat(resetFiles[i])
+		jInfo, jErr := os.Stat(resetFiles[j])
+
+		if iErr != nil || jErr != nil {
+			return resetFiles[i] < resetFiles[j]
+		}
+
+		return iInfo.ModTime().Before(jInfo.ModTime())
+	})
+
+	logger.Debugf("Returning %d files", len(resetFiles))
+
+	return resetFiles
+}
+
+func restoreBaseline(baselineFileName string, reason string) {
+	logger.Warnf("Restoring RMAN configuration from %s as %s ...", baselineFileName, reason)
+
+	resetConfig(baselineFileName)
+
+	message := "Restored RMAN configuration for " + setup.Database + " from " + baselineFileName + " as " + reason
+
+	logger.Info(message)
+
+	logger.NotifyErrors("Restored RMAN configuration on " + setup.HostName, message)
+}
+
+// Global functions
+
+func RecoverConfig () []string {
+	logger.Info("Recovering RMAN configuration left by failed runs ...")
+
+	var restored []string
+
+	if config.ConfigValues["RMANConfig"] == "" {
+		logger.Info("Not using a custom RMAN config - nothing to recover")
+		return restored
+	}
+
+	baseDir      := filepath.Dir(config.ConfigValues["RMANConfig"])
+	baseFileName := filepath.Base(config.ConfigValues["RMANConfig"])
+
+	lockFileName := strings.Join( []string{ baseFileName, "lock" }, ".")
+	lockFileName  = filepath.Join(baseDir, lockFileName)
+
+	// Lock up the config to avoid anyone else using it whilst we are checking
+
+	coordinator.Current.Lock(lockFileName,20)
+
+	lockEntries := locker.GetLockEntries(lockFileName)
+
+	var deadEntries []string
+	liveCount := 0
+
+	referencedFiles := make(map[string]bool)
+
+	for _, lockEntry := range lockEntries {
+		lockPID := strings.SplitN(lockEntry, " ", 2)[0]
+
+		referencedFiles[getResetFileName(baseDir, baseFileName, lockPID)] = true
+
+		if pidAlive, pidIsName := coordinator.Current.CheckProcess(lockPID, setup.BaseName); pidAlive && pidIsName {
+			logger.Debugf("Process entry %s is still running", lockPID)
+			liveCount++
+		} else {
+			logger.Warnf("Process entry %s is no longer running", lockPID)
+			deadEntries = append(deadEntries, lockPID)
+		}
+	}
+
+	if len(lockEntries) > 0 && liveCount == 0 {
+		// Every run using the config has died so nobody is left to reset it
+		// The first entry saved the configuration before any of them changed it
+
+		firstPID      := strings.SplitN(lockEntries[0], " ", 2)[0]
+		baselineFile  := getResetFileName(baseDir, baseFileName, firstPID)
+
+		if _, err := os.Stat(baselineFile); err == nil {
+			restoreBaseline(baselineFile, "all runs using " + config.ConfigValues["RMANConfig"] + " have died")
+
+			restored = append(restored, baselineFile)
+		} else {
+			logger.Warnf("Baseline file %s is missing. Unable to restore configuration", baselineFile)
+
+			logger.NotifyErrors("Unable to restore RMAN configuration on " + setup.HostName, "Baseline file " + baselineFile + " for " + setup.Database + " is missing")
+		}
+	} else if len(deadEntries) > 0 && deadEntries[0] == strings.SplitN(lockEntries[0], " ", 2)[0] {
+		// The first entry holds the baseline the last live run will restore so must stay
+
+		logger.Infof("Keeping first entry %s for the remaining runs to reset from", deadEntries[0])
+
+		deadEntries = deadEntries[1:]
+	}
+
+	for _, lockPID := range deadEntries {
+		locker.RemoveHeldLockEntry(lockFileName, lockPID)
+
+		resetFileName := getResetFileName(baseDir, baseFileName, lockPID)
+
+		removeResetFile(resetFileName)
+
+		delete(referencedFiles, resetFileName)
+	}
+
+	// Reset files with no lock entry are left over from runs whose entries were already removed
+
+	resetFiles := findResetFiles(baseDir, baseFileName)
+
+	var orphanFiles []string
+
+	for _, resetFileName := range resetFiles {
+		if !referencedFiles[resetFileName] {
+			orphanFiles = append(orphanFiles, resetFileName)
+		}
+	}
+
+	if len(orphanFiles) > 0 && len(lockEntries) == 0 {
+		restoreBaseline(orphanFiles[0], "reset files were left with no runs using " + config.ConfigValues["RMANConfig"])
+
+		restored = append(restored, orphanFiles[0])
+	}
+
+	for _, resetFileName := range orphanFiles {
+		logger.Warnf("Removing orphaned reset file %s", resetFileName)
+
+		removeResetFile(resetFileName)
+	}
+
+	coordinator.Current.Unlock(lockFileName)
+
+	logger.Info("Process complete")
+
+	return restored
+}
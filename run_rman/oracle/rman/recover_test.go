@@ -0,0 +1,140 @@
+package rman
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/run_rman/coordinator"
+	"github.com/daviesluke/setup"
+	"github.com/daviesluke/utils"
+)
+
+func TestRecoverConfig(t *testing.T) {
+	hostName, _ := os.Hostname()
+
+	// The test binary is running rman.test so counts as a live run
+	setup.BaseName = "rman"
+	setup.ProcessEntry = fmt.Sprintf("%d:%s:%s:live", os.Getpid(), utils.GetProcessStartTime(os.Getpid()), hostName)
+
+	live := setup.ProcessEntry
+	firstDead := fmt.Sprintf("4194300:1:%s:dead1", hostName)
+	secondDead := fmt.Sprintf("4194301:1:%s:dead2", hostName)
+
+	oldCurrent := coordinator.Current
+	defer func() { coordinator.Current = oldCurrent }()
+
+	coordinator.Current = coordinator.NewLocalBackend()
+
+	rmanSession = startStub(t)
+	defer CloseSession()
+
+	cases := []struct {
+		name      string
+		entries   []string
+		files     []string
+		restored  []string
+		remaining []string
+		kept      []string
+	}{
+		{
+			name:      "all runs dead restores the first baseline",
+			entries:   []string{firstDead, secondDead},
+			files:     []string{"dead1", "dead2"},
+			restored:  []string{"dead1"},
+			remaining: nil,
+			kept:      nil,
+		},
+		{
+			name:      "first entry kept for a live run",
+			entries:   []string{firstDead, live, secondDead},
+			files:     []string{"dead1", "live", "dead2"},
+			restored:  nil,
+			remaining: []string{firstDead + " 0", live + " 0"},
+			kept:      []string{"dead1", "live"},
+		},
+		{
+			name:      "orphans with no runs restore the oldest",
+			entries:   nil,
+			files:     []string{"old", "new"},
+			restored:  []string{"old"},
+			remaining: nil,
+			kept:      nil,
+		},
+		{
+			name:      "orphans with a live run are removed",
+			entries:   []string{live},
+			files:     []string{"live", "old"},
+			restored:  nil,
+			remaining: []string{live + " 0"},
+			kept:      []string{"live"},
+		},
+	}
+
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "recover")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		setup.TmpFileName = filepath.Join(dir, "run_rman.tmp")
+		config.ConfigValues["RMANConfig"] = filepath.Join(dir, "rman.cfg")
+
+		lockFileName := config.ConfigValues["RMANConfig"] + ".lock"
+
+		for _, entry := range c.entries {
+			coordinator.Current.AddEntry(lockFileName, entry+" 0")
+		}
+
+		// Files are written oldest first
+		for i, runID := range c.files {
+			resetFile := filepath.Join(dir, "rman.cfg."+runID+".reset")
+
+			if err := ioutil.WriteFile(resetFile, []byte("CONFIGURE CONTROLFILE AUTOBACKUP ON;\n"), 0600); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			modTime := time.Now().Add(time.Duration(i-len(c.files)) * time.Hour)
+			os.Chtimes(resetFile, modTime, modTime)
+		}
+
+		var restored []string
+
+		for _, resetFile := range RecoverConfig() {
+			restored = append(restored, filepath.Base(resetFile))
+		}
+
+		var expected []string
+
+		for _, runID := range c.restored {
+			expected = append(expected, "rman.cfg."+runID+".reset")
+		}
+
+		if !reflect.DeepEqual(restored, expected) {
+			t.Fatalf("%s: restored %#v", c.name, restored)
+		}
+
+		if remaining := coordinator.Current.ReadEntries(lockFileName); !reflect.DeepEqual(remaining, c.remaining) {
+			t.Fatalf("%s: entries %#v", c.name, remaining)
+		}
+
+		for _, runID := range c.files {
+			_, err := os.Stat(filepath.Join(dir, "rman.cfg."+runID+".reset"))
+
+			isKept := false
+			for _, keptID := range c.kept {
+				isKept = isKept || keptID == runID
+			}
+
+			if isKept != (err == nil) {
+				t.Fatalf("%s: reset file for %s kept %t", c.name, runID, err == nil)
+			}
+		}
+	}
+}
@@ -4,128 +4,93 @@ package rman
 
 import "bufio"
 import "fmt"
+import "io"
+import "io/ioutil"
 import "os"
-import "os/exec"
 import "path/filepath"
 import "strconv"
 import "strings"
 
 // Local imports
 
-import "github.com/daviesluke/filelock"
 import "github.com/daviesluke/logger"
 import "github.com/daviesluke/setup"
 import "github.com/daviesluke/utils"
 import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/coordinator"
+import "github.com/daviesluke/run_rman/credential"
 import "github.com/daviesluke/run_rman/general"
 import "github.com/daviesluke/run_rman/locker"
+import "github.com/daviesluke/run_rman/metrics"
+import "github.com/daviesluke/run_rman/oracle/rmanconfig"
 
 // local variables
 
 var ResetConfigFileName     string
 var ResetConfigLockFileName string
 
-// local functions
+var rmanSession             *Session
 
-func checkDir(dirName string) {
-	logger.Debug("Checking scripts directory exists ...")
+// Exit code when configuration drift is found and not fixed
 
-	if dirInfo, err := os.Stat(dirName); err == nil && dirInfo.IsDir() {
-		logger.Debugf("Directory %s already exists", dirName)
-	} else {
-		if err != nil {
-			if err := os.MkdirAll(dirName,0755); err != nil {
-				logger.Errorf("Unable to make directory %s", dirName)
-			}
-		} else {
-			logger.Errorf("File %s is not a directory", dirName)
-		}
-	}
+const DriftExitCode = 2
 
-	logger.Debug("Process complete")
-}
+// local functions
 
 func getConfig(outputFile string) {
 	logger.Info("Getting RMAN configuration ...")
 
-	checkDir(setup.RMANScriptDir)
-
-	commandFileName := strings.Join( []string{ setup.BaseName, setup.CurrentPID }, ".")
-	commandFileName = filepath.Join( setup.RMANScriptDir , commandFileName)
-	
-	// Write the command file
-
-	if commandFile, err := os.OpenFile(commandFileName, os.O_CREATE | os.O_WRONLY | os.O_EXCL, 0600 ); err == nil {
-		if _, err := commandFile.WriteString("show all;\n") ; err != nil {
-			logger.Errorf("Unable to write file %s", commandFileName)
-		}
-
-		commandFile.Close()
-	} else {
-		logger.Errorf("Unable to open the file %s", commandFileName)
-	}
-
-	runRMAN(commandFileName, outputFile)
-
-	// Don't need the command file now so can remove it 
-
-	if err := os.Remove(commandFileName); err != nil {
-		logger.Errorf("Unable to remove file %s", commandFileName)
-	}
+	runRMAN("show all;", outputFile)
 
 	logger.Debug("Process complete")
 }
 
-func addConnections ( cmdFileName string, targetConn string, catalogConn string ) {
-	logger.Infof("Adding connections to file %s ...", cmdFileName)
+func getSession(output io.Writer) *Session {
+	// The session is shared by all commands run until rman exits
 
-	newCmdFileName := strings.Join( []string{ cmdFileName , "tmp" }, "." )
-	logger.Debugf("New command file %s", newCmdFileName)
+	if rmanSession != nil && !rmanSession.ended {
+		return rmanSession
+	}
 
-	if newCmdFile, err :=  os.OpenFile(newCmdFileName, os.O_CREATE | os.O_WRONLY | os.O_TRUNC, 0600 ); err == nil {
-		// Write the connection strings
+	if rmanSession != nil {
+		CloseSession()
+	}
 
-		connString := strings.Join( []string{ "connect", "target", targetConn }, " ")
+	var err error
 
-		if _, err := newCmdFile.WriteString(connString + setup.NewLine); err != nil {
-			logger.Errorf("Unable to write target connection to file %s", newCmdFileName)
-		}
+	if rmanSession, err = StartSession(general.RMAN); err != nil {
+		logger.Errorf("Unable to start RMAN %s - %s", general.RMAN, err)
+	}
 
-		logger.Debug("Target connection written")
+	// Connections are sent on stdin so passwords are never written to disk
 
-		if catalogConn != "" {
-			connString = strings.Join( []string{ "connect", "catalog", catalogConn }, " ")
+	connections := strings.Join( []string{ "connect", "target", credential.Connection("TargetConnection") }, " ") + "\n"
 
-			if _, err := newCmdFile.WriteString(connString + "\n"); err != nil {
-				logger.Errorf("Unable to write catalog connection to file %s", newCmdFileName)
-			}
-			
-			logger.Debug("Catalog connection written")
-		}
+	if config.ConfigValues["CatalogConnection"] != "" {
+		connections += strings.Join( []string{ "connect", "catalog", credential.Connection("CatalogConnection") }, " ") + "\n"
+	}
 
-		newCmdFile.Close()
+	// DUPLICATE also needs the instance being duplicated to
 
-		utils.CopyFileContents(cmdFileName, newCmdFileName, "")
+	if general.Command == "duplicate" {
+		connections += strings.Join( []string{ "connect", "auxiliary", credential.Connection("AuxiliaryConnection") }, " ") + "\n"
+	}
 
-		if err := os.Rename(newCmdFileName, cmdFileName); err != nil {
-			logger.Errorf("Unable to rename file from %s to %s", newCmdFileName, cmdFileName)
-		}
-		logger.Debugf("Renamed file %s to %s", newCmdFileName, cmdFileName)
-	} else {
-		logger.Errorf("Unable to open file %s", newCmdFileName)
+	if err := rmanSession.Run(connections, output); err != nil {
+		logger.Errorf("Unable to connect RMAN - %s", err)
 	}
 
-	logger.Debug("Process complete")
+	logger.Debug("Connections made")
+
+	return rmanSession
 }
 
-func runRMAN(cmdFile string, outFile string) {
+func runRMAN(commands string, outFile string) {
 	logger.Info("Running RMAN ...")
 
-	setup.CopyFileToLog("Command file contents", cmdFile)
-
-	addConnections(cmdFile, config.ConfigValues["TargetConnection"], config.ConfigValues["CatalogConnection"])
-
-	cmdParams := []string{ "cmdfile", cmdFile}
+	for _, commandLine := range strings.Split(strings.TrimRight(commands, "\n"), "\n") {
+		logger.Infof("RMAN command - %s", commandLine)
+	}
 
 	// Open the output file to capture the stdout and stderr
 
@@ -134,19 +99,24 @@ func runRMAN(cmdFile string, outFile string) {
 		logger.Errorf("Unable to open RMAN output file %s", outFile) 
 	}
 
-	// Set the command (does not run it yet)
+	// Now let's run it 
 
-	cmd := exec.Command(general.RMAN , cmdParams ...)
-	logger.Debugf("Set command to run %s cmdfile %s", general.RMAN, cmdFile)
-	
-	// Setting the stdout and stderr 
+	rmanErr := getSession(out).RunScript(commands, out)
 
-	cmd.Stdout = out
-	cmd.Stderr = out 
+	if rmanErr == ErrCommandFailed {
+		// As with a command file the rest of the script is skipped and the errors are found in the output
 
-	// Now let's run it 
+		rmanErr = nil
+	}
+
+	if rmanErr == ErrSessionEnded {
+		// The commands may have ended the session themselves so only fail if rman did
 
-	rmanErr := cmd.Run()
+		logger.Info("RMAN session ended")
+
+		rmanErr = rmanSession.Close()
+		rmanSession = nil
+	}
 
 	// Close output file
 	out.Close()
@@ -229,7 +199,11 @@ func checkRMAN(logFileName string) bool {
 
 	logger.Debugf("Regular expression set to %s, ignoring %s, ignore groups %d", regEx, ignoreRegEx, regGroup)
 
-	return utils.FindInFile(logFileName,regEx,ignoreRegEx,regGroup) 
+	errorCount := utils.CountInFile(logFileName,regEx,ignoreRegEx,regGroup)
+
+	metrics.AddErrors(errorCount)
+
+	return errorCount > 0
 }
 
 func saveConfig (newConfigFileName string) {
@@ -311,92 +285,83 @@ func formatCommand ( oldCmdFile, newCmdFile string ) {
 	logger.Debug("Process complete")
 }
 
-func setConfig( oldConfig , newConfig string ) {
-	logger.Infof("Old configuration -> %s", oldConfig)
-	logger.Infof("New configuration -> %s", newConfig)
+func setConfig( currentConfig , desiredConfig string, clearMissing bool ) {
+	logger.Infof("Current configuration -> %s", currentConfig)
+	logger.Infof("Desired configuration -> %s", desiredConfig)
 
-	// Open new files to compare and write out any differences
+	// Compare the settings rather than the text so formatting differences do not cause changes
 
-	new, err := os.Open(newConfig)
-	if err != nil {
-		logger.Errorf("Unable to open new config file %s", newConfig)
-	}
+	changes := rmanconfig.Diff(rmanconfig.ParseFile(currentConfig), rmanconfig.ParseFile(desiredConfig), clearMissing)
 
-	defer new.Close()
+	var commands []string
 
-	newCmdFile := strings.Join( []string{ newConfig, "run" }, "." )
+	for _, change := range changes {
+		logger.Infof("Setting %s changing with command %s", change.Key, change.Command)
 
-	out, err := os.OpenFile(newCmdFile, os.O_CREATE | os.O_WRONLY | os.O_TRUNC , 0600 )
-	if err != nil {
-		logger.Errorf("Unable to open new command file %s", newCmdFile)
+		commands = append(commands, change.Command)
 	}
 
-	newScan := bufio.NewScanner(new)
-
-	totalWritten := 0
+	// Now let's run what's left
+	
+	if len(commands) > 0 {
+		runRMAN(strings.Join(commands, "\n"),setup.TmpFileName)
 
-	for newScan.Scan() {
-		found := false
+		// No need for the output 
 
-		old, err := os.Open(oldConfig)
-		if err != nil {
-			logger.Errorf("Unable to open old config file %s", oldConfig)
+		if err := os.Remove(setup.TmpFileName); err != nil {
+			logger.Errorf("Unable to remove output file %s",setup.TmpFileName)
 		}
+	} else {
+		logger.Info("No changes to be made")
+	}
 
-		oldScan := bufio.NewScanner(old)
+	logger.Debug("Process complete")
+}
 
-		for oldScan.Scan() {
-			if newScan.Text() == oldScan.Text() {
-				logger.Debugf("Found command %s in old file. Ignoring ...", newScan.Text())
-				found = true
-				break
-			}
-		}
+func resetConfig(baselineConfig string) {
+	logger.Infof("Resetting configuration to baseline %s ...", baselineConfig)
 
-		old.Close()
+	// Compare against what is set now so settings changed since the baseline are cleared or set back
 
-		if ! found {
-			logger.Debugf("Writing config entry %s", newScan.Text())
+	currentConfig := strings.Join( []string{ baselineConfig, "current" }, "." )
 
-			if bytesWritten, err := out.WriteString(newScan.Text()+"\n"); err != nil {
-				logger.Errorf("Unable to write file %s", setup.TmpFileName)
-			} else {
-				totalWritten += bytesWritten
-			}
-		}
+	saveConfig(currentConfig)
+
+	setConfig(currentConfig, baselineConfig, true)
+
+	if err := os.Remove(currentConfig); err != nil {
+		logger.Errorf("Unable to remove file %s", currentConfig)
 	}
 
-	out.Sync()
+	logger.Debug("Process complete")
+}
 
-	out.Close()
+func getResetFileName(baseDir string, baseFileName string, processEntry string) string {
+	logger.Debugf("Getting reset file name for process entry %s ...", processEntry)
 
-	// Now let's run what's left
-	
-	if totalWritten > 0 {
-		runRMAN(newCmdFile,setup.TmpFileName)
+	// Reset files are named after the run ID so a reused PID cannot pick up an old file
+	// Entries written by older versions only hold the PID
 
-		// No need for the output 
+	pid, _, _, runID := utils.SplitProcessEntry(processEntry)
 
-		if err := os.Remove(setup.TmpFileName); err != nil {
-			logger.Errorf("Unable to remove output file %s",setup.TmpFileName)
-		}
-	} else {
-		logger.Info("No changes to be made")
+	if runID == "" {
+		runID = strconv.Itoa(pid)
 	}
 
-	// May remove the command file
+	resetFileName := strings.Join( []string{ baseFileName, runID, "reset"}, ".")
+	resetFileName = filepath.Join(baseDir, resetFileName)
 
-	if err := os.Remove(newCmdFile); err != nil {
-		logger.Errorf("Unable to remove command file %s",newCmdFile)
-	}
+	logger.Debugf("Returning %s", resetFileName)
 
-	logger.Debug("Process complete")
+	return resetFileName
 }
 
 func removeLockEntry(lockFile string, lockPID string, resetFile string) {
 	logger.Debug("Removing lock entry and associated file ...")
 
-	locker.RemoveLockEntry(lockFile, lockPID)
+	// The caller holds the coordinator lock on the lock file
+
+	locker.RemoveHeldLockEntry(lockFile, lockPID)
 
 	// Remember to remove the associated file 
 
@@ -424,29 +389,29 @@ func CheckConfig () {
 		baseRMANDir            := filepath.Dir(config.ConfigValues["RMANConfig"])
 		baseRMANConfigFileName := filepath.Base(config.ConfigValues["RMANConfig"])
 
-		ResetConfigFileName = strings.Join( []string{ baseRMANConfigFileName, setup.CurrentPID, "reset"}, ".")
-		ResetConfigFileName = filepath.Join(baseRMANDir, ResetConfigFileName)
+		ResetConfigFileName = getResetFileName(baseRMANDir, baseRMANConfigFileName, setup.ProcessEntry)
 
 		ResetConfigLockFileName = strings.Join( []string{ baseRMANConfigFileName, "lock" }, ".")
 		ResetConfigLockFileName = filepath.Join(baseRMANDir, ResetConfigLockFileName)
 		
 		// Put a lock on the lock file during the save so that we have list in time order of when the file as used 
 		// Have to wait for longer than typical to allow for show all to run - allowing 20 secs
+		// Runs on other hosts can share the config so the lock is taken through the coordinator
 
-		filelock.LockFile(config.ConfigValues["RMANConfig"],20)
+		coordinator.Current.Lock(ResetConfigLockFileName,20)
 
-		// See if file exists
-		_, err := os.Stat(ResetConfigLockFileName)
+		// See if any other process is using the config
+		isFirst := len(locker.GetLockEntries(ResetConfigLockFileName)) == 0
 
-		locker.AddLockEntry(ResetConfigLockFileName,setup.CurrentPID,"0")
+		locker.AddHeldLockEntry(ResetConfigLockFileName,setup.ProcessEntry,"0")
 
 		saveConfig(ResetConfigFileName)
 
-		filelock.UnlockFile(config.ConfigValues["RMANConfig"])
+		coordinator.Current.Unlock(ResetConfigLockFileName)
 
-		// If previous check satted that file did not exist then set the config to the new configj
-		if err != nil {
-			setConfig(ResetConfigFileName, config.ConfigValues["RMANConfig"])
+		// If previous check stated that no other process is using the config then set the config to the new config
+		if isFirst {
+			setConfig(ResetConfigFileName, config.ConfigValues["RMANConfig"], false)
 		}
 	} else {
 		logger.Warn("Not using a custom RMAN config - relying upon control file entries")
@@ -455,6 +420,93 @@ func CheckConfig () {
 	logger.Debug("Process complete")
 }
 
+func CheckDrift (applyFix bool) bool {
+	logger.Info("Checking RMAN configuration drift ...")
+
+	desiredConfig := config.ConfigValues["RMANConfig"]
+
+	if desiredConfig == "" {
+		logger.Errorf("No RMANConfig set for database %s. Nothing to compare against", setup.Database)
+	}
+
+	if _, err := os.Stat(desiredConfig); err != nil {
+		logger.Errorf("Unable to open RMAN Config file %s", desiredConfig)
+	}
+
+	// Get the current settings to compare
+
+	currentConfig := strings.Join( []string{ setup.TmpFileName, "drift" }, ".")
+
+	saveConfig(currentConfig)
+
+	changes := rmanconfig.Diff(rmanconfig.ParseFile(currentConfig), rmanconfig.ParseFile(desiredConfig), false)
+
+	if len(changes) == 0 {
+		fmt.Printf("No configuration drift for %s against %s\n", setup.Database, desiredConfig)
+	} else {
+		fmt.Printf("Configuration drift for %s against %s\n", setup.Database, desiredConfig)
+
+		for _, change := range changes {
+			currentValue := "(not set)"
+
+			if change.Current != nil {
+				currentValue = change.Current.String()
+			}
+
+			fmt.Printf("\n  %s\n    current : %s\n    desired : %s\n    fix     : %s\n", change.Key, currentValue, change.Desired.String(), change.Command)
+
+			logger.Warnf("Setting %s has drifted from %s. Fix with %s", change.Key, desiredConfig, change.Command)
+		}
+	}
+
+	drifted := len(changes) > 0
+
+	if drifted && applyFix {
+		// Runs using the config reset it to their baseline when they finish so a fix now would be undone
+
+		baseRMANDir            := filepath.Dir(desiredConfig)
+		baseRMANConfigFileName := filepath.Base(desiredConfig)
+
+		ResetConfigLockFileName = strings.Join( []string{ baseRMANConfigFileName, "lock" }, ".")
+		ResetConfigLockFileName = filepath.Join(baseRMANDir, ResetConfigLockFileName)
+
+		coordinator.Current.Lock(ResetConfigLockFileName,20)
+
+		if len(locker.GetLockEntries(ResetConfigLockFileName)) > 0 {
+			logger.Warnf("Other runs are using %s. Not applying changes", desiredConfig)
+		} else {
+			logger.Info("Applying changes to fix drift ...")
+
+			setConfig(currentConfig, desiredConfig, false)
+
+			drifted = false
+		}
+
+		coordinator.Current.Unlock(ResetConfigLockFileName)
+	}
+
+	if err := os.Remove(currentConfig); err != nil {
+		logger.Errorf("Unable to remove file %s", currentConfig)
+	}
+
+	logger.Info("Process complete")
+
+	return drifted
+}
+
+func CloseSession () {
+	if rmanSession == nil {
+		logger.Debug("No RMAN session to close")
+		return
+	}
+
+	if err := rmanSession.Close(); err != nil {
+		logger.Warnf("RMAN session ended with error - %s", err)
+	}
+
+	rmanSession = nil
+}
+
 func RunScript () {
 	logger.Info("Running main RMAN script ...")
 
@@ -464,15 +516,18 @@ func RunScript () {
 
 	formatCommand(config.RMANScript, newCommandFile)
 
-	// Set the NLS_DATE_FORMAT for better output 
+	// The NLS_DATE_FORMAT is set when the session starts
 
 	if config.ConfigValues["NLS_DATE_FORMAT"] == "" {
 		logger.Warnf("NLS_DATE_FORMAT is not set.  Time will not be recorded. This is not recommended")
 	}
 
-	os.Setenv("NLS_DATE_FORMAT", config.ConfigValues["NLS_DATE_FORMAT"])
+	commands, err := ioutil.ReadFile(newCommandFile)
+	if err != nil {
+		logger.Errorf("Unable to read command file %s", newCommandFile)
+	}
 
-	runRMAN(newCommandFile,setup.TmpFileName)
+	runRMAN(string(commands),setup.TmpFileName)
 
 	// Do not need the log or command file
 
@@ -508,8 +563,7 @@ func ResetConfig () {
 		// Remove any associated files 
 
 		for _, lockPID := range lockPIDS {
-			resetFileName := strings.Join( []string{ baseFileName, lockPID, "reset"}, ".")
-			resetFileName = filepath.Join(baseDir, resetFileName)
+			resetFileName := getResetFileName(baseDir, baseFileName, lockPID)
 			
 			if _, err := os.Stat(resetFileName); err != nil {
 				logger.Warnf("File %s has already been removed", resetFileName)
@@ -525,21 +579,23 @@ func ResetConfig () {
 
 		// Lock up the config to avoid anyone else using it whilst we are checking
 
-		filelock.LockFile(config.ConfigValues["RMANConfig"],20)
+		coordinator.Current.Lock(ResetConfigLockFileName,20)
 
-		lineCount := utils.CountLines(ResetConfigLockFileName)
+		lockEntries := locker.GetLockEntries(ResetConfigLockFileName)
+		lineCount   := len(lockEntries)
 
-		logger.Debugf("%d lines found in file %s", lineCount, ResetConfigLockFileName)
+		logger.Debugf("%d entries found for %s", lineCount, ResetConfigLockFileName)
 
 		if lineCount == 0 { 
-			logger.Errorf("File %s is missing or empty. Something has gone wrong", ResetConfigLockFileName)
+			coordinator.Current.Unlock(ResetConfigLockFileName)
+			logger.Errorf("Lock entries for %s are missing. Something has gone wrong", ResetConfigLockFileName)
 		}
 
 		logger.Debugf("Found %d processes using the config file %s", lineCount, config.ConfigValues["RMANConfig"])
 
 		// Get the first PID in the file
 
-		lockPID := utils.LookupFile(ResetConfigLockFileName, "0", 2, 1, " ", 1)
+		lockPID := strings.SplitN(lockEntries[0], " ", 2)[0]
 
 		logger.Debugf("First PID in file is %s", lockPID)
 
@@ -548,29 +604,27 @@ func ResetConfig () {
 		if lineCount == 1 { 
 			// If the line count is one then it must be our process so we can just set it back
 
-			if lockPID != setup.CurrentPID {
+			if !utils.SameProcessEntry(lockPID, setup.ProcessEntry) {
+				coordinator.Current.Unlock(ResetConfigLockFileName)
 				logger.Errorf("Only PID in the file is not our own.  Something has gone wrong. Exiting ...")
 			}
 
-			setConfig(config.ConfigValues["RMANConfig"], ResetConfigFileName)
+			resetConfig(ResetConfigFileName)
 
 			// Get rid of our entry and files
 
-			removeLockEntry(ResetConfigLockFileName,setup.CurrentPID,ResetConfigFileName)
+			removeLockEntry(ResetConfigLockFileName,setup.ProcessEntry,ResetConfigFileName)
 		} else {
 			// This means there is our process and others
 			// First of all check the first process is not us
 
-			if lockPID != setup.CurrentPID {
+			if !utils.SameProcessEntry(lockPID, setup.ProcessEntry) {
 
-				ilockPID , err := strconv.Atoi(lockPID)
-				if err != nil {
-					logger.Errorf("Lock PID is corrupted %s, should be a number", lockPID)
-				}
+				ilockPID, _, _, _ := utils.SplitProcessEntry(lockPID)
 
 				logger.Debugf("Checking PID %d to see if is running ...", ilockPID)
 
-				pidAlive, pidIsName := utils.CheckProcess(ilockPID, setup.BaseName)
+				pidAlive, pidIsName := coordinator.Current.CheckProcess(lockPID, setup.BaseName)
 
 				if pidAlive && pidIsName {
 					logger.Warnf("Process %d is still running. Will not reset the config", ilockPID)
@@ -584,10 +638,9 @@ func ResetConfig () {
 
 						logger.Warnf("Process %d is not running %s. Cleaning up config", ilockPID, setup.BaseName)
 
-						resetFileName := strings.Join( []string{ baseFileName, lockPID, "reset"}, ".")
-						resetFileName = filepath.Join(baseDir, resetFileName)
+						resetFileName := getResetFileName(baseDir, baseFileName, lockPID)
 
-						setConfig(config.ConfigValues["RMANConfig"], resetFileName)
+						resetConfig(resetFileName)
 
 						removeLockEntry(ResetConfigLockFileName,lockPID,resetFileName)
 					} 
@@ -596,7 +649,7 @@ func ResetConfig () {
 
 				// Then remove our entry as we do not need it here - some other process will reset the config
 
-				removeLockEntry(ResetConfigLockFileName,setup.CurrentPID,ResetConfigFileName)
+				removeLockEntry(ResetConfigLockFileName,setup.ProcessEntry,ResetConfigFileName)
 			} else {
 				// First PID is our PID but there are other processes using this config file 
 				// So we do not want to remove our entry - the remaining processes will do it
@@ -605,9 +658,9 @@ func ResetConfig () {
 			}
 		} 
 
-		// Unlock the main config file
+		// Unlock the lock entries
 
-		filelock.UnlockFile(config.ConfigValues["RMANConfig"])
+		coordinator.Current.Unlock(ResetConfigLockFileName)
 	}
 
 	logger.Debug("Process complete")
@@ -0,0 +1,293 @@
+package rman
+
+// Standard imports
+
+import "bufio"
+import "bytes"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "os/exec"
+import "regexp"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/run_rman/environment"
+
+// Global Variables
+
+// ErrSessionEnded is returned when rman exits before the commands sent have all run e.g. after an exit command
+
+var ErrSessionEnded = errors.New("RMAN session ended")
+
+// ErrCommandFailed is returned when a command in a script fails and the rest are not sent, as rman does with a command file
+
+var ErrCommandFailed = errors.New("RMAN command failed")
+
+// Session is a running rman fed commands on stdin
+// The output of each set of commands is framed by echoing a marker through a host command after them
+
+type Session struct {
+	command     *exec.Cmd
+	input       io.WriteCloser
+	output      *bufio.Reader
+	counter     int
+	hostPending bool
+	ended       bool
+}
+
+// local Variables
+
+const hostComplete = "host command complete"
+
+// rman heads the errors of a failed command with RMAN-00569. SQL commands may only show the ORA- error
+
+var failedRegEx = regexp.MustCompile(`(?m)^\s*(RMAN-00569|ORA-[0-9]{5})`)
+
+// Local functions
+
+func (s *Session) readUntil(marker string, output io.Writer) error {
+	for {
+		line, err := s.output.ReadString('\n')
+
+		trimmedLine := strings.TrimSpace(line)
+
+		// The host command used for the previous marker reports completion after it
+
+		if s.hostPending && trimmedLine != "" && !strings.HasPrefix(trimmedLine, "RMAN>") {
+			s.hostPending = false
+
+			if trimmedLine == hostComplete {
+				continue
+			}
+		}
+
+		if marker != "" && strings.HasSuffix(trimmedLine, marker) && !strings.Contains(trimmedLine, "echo") {
+			// Keep anything before the marker such as a prompt
+
+			if prefix := strings.TrimSuffix(trimmedLine, marker); prefix != "" {
+				fmt.Fprintln(output, prefix)
+			}
+
+			s.hostPending = true
+
+			return nil
+		}
+
+		if line != "" {
+			if _, writeErr := io.WriteString(output, line); writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if err != nil {
+			s.ended = true
+
+			if err == io.EOF {
+				return ErrSessionEnded
+			}
+
+			return err
+		}
+	}
+}
+
+func splitCommands(commands string) []string {
+	// Splits a script into commands ending with ; outside quotes and comments
+	// A run block ends at its closing brace and @ runs the rest of the line as a command file
+
+	var statements []string
+	var statement  bytes.Buffer
+
+	var quote rune
+
+	depth     := 0
+	comment   := false
+	atCommand := false
+	hasCode   := false
+
+	endStatement := func() {
+		if hasCode {
+			statements = append(statements, statement.String())
+		}
+
+		statement.Reset()
+
+		atCommand = false
+		hasCode   = false
+	}
+
+	for _, c := range commands {
+		statement.WriteRune(c)
+
+		switch {
+		case comment:
+			if c == '\n' {
+				comment = false
+
+				if atCommand {
+					endStatement()
+				}
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '#':
+			comment = true
+		case c == '\n' && atCommand:
+			endStatement()
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		case c == '@' && !hasCode && depth == 0:
+			atCommand = true
+			hasCode   = true
+		case c == '\'' || c == '"':
+			quote   = c
+			hasCode = true
+		case c == '{':
+			depth++
+			hasCode = true
+		case c == '}' && depth > 0:
+			depth--
+
+			if depth == 0 {
+				endStatement()
+			}
+		case c == ';' && depth == 0:
+			hasCode = true
+			endStatement()
+		default:
+			hasCode = true
+		}
+	}
+
+	endStatement()
+
+	return statements
+}
+
+// Global functions
+
+func StartSession(rmanPath string, args ...string) (*Session, error) {
+	logger.Infof("Starting RMAN session using %s ...", rmanPath)
+
+	s := &Session{ command: exec.Command(rmanPath, args...) }
+
+	// rman gets the environment built for the database rather than that of this process
+
+	s.command.Env = environment.Environ()
+
+	// Merge stdout and stderr so errors appear in order with the output
+
+	outputReader, outputWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	s.command.Stdout = outputWriter
+	s.command.Stderr = outputWriter
+
+	if s.input, err = s.command.StdinPipe(); err != nil {
+		outputReader.Close()
+		outputWriter.Close()
+		return nil, err
+	}
+
+	if err := s.command.Start(); err != nil {
+		outputReader.Close()
+		outputWriter.Close()
+		return nil, err
+	}
+
+	// Only rman should hold the write end so reading ends when it exits
+
+	outputWriter.Close()
+
+	s.output = bufio.NewReader(outputReader)
+
+	logger.Infof("RMAN session started with PID %d", s.command.Process.Pid)
+
+	return s, nil
+}
+
+func (s *Session) Run(commands string, output io.Writer) error {
+	logger.Debug("Running commands in RMAN session ...")
+
+	if s.ended {
+		return ErrSessionEnded
+	}
+
+	s.counter++
+
+	marker := fmt.Sprintf("RUN_RMAN_%s_%d_COMPLETE", setup.CurrentPID, s.counter)
+
+	if !strings.HasSuffix(commands, "\n") {
+		commands = commands + "\n"
+	}
+
+	if _, err := io.WriteString(s.input, commands + "host 'echo " + marker + "';\n"); err != nil {
+		// rman may have exited already so collect what it wrote
+
+		s.readUntil("", output)
+
+		s.ended = true
+
+		return ErrSessionEnded
+	}
+
+	err := s.readUntil(marker, output)
+
+	logger.Debug("Process complete")
+
+	return err
+}
+
+func (s *Session) RunScript(commands string, output io.Writer) error {
+	logger.Debug("Running script in RMAN session ...")
+
+	// Each command is sent after the one before has finished so that nothing more runs after a failure
+
+	for _, command := range splitCommands(commands) {
+		var commandOutput bytes.Buffer
+
+		if err := s.Run(command, io.MultiWriter(output, &commandOutput)); err != nil {
+			return err
+		}
+
+		if failedRegEx.MatchString(commandOutput.String()) {
+			logger.Warn("RMAN command failed. Remaining commands not run")
+			return ErrCommandFailed
+		}
+	}
+
+	logger.Debug("Process complete")
+
+	return nil
+}
+
+func (s *Session) Close() error {
+	logger.Info("Closing RMAN session ...")
+
+	if !s.ended {
+		io.WriteString(s.input, "exit;\n")
+	}
+
+	s.input.Close()
+
+	// Drain anything left so rman is not blocked writing
+
+	io.Copy(ioutil.Discard, s.output)
+
+	s.ended = true
+
+	err := s.command.Wait()
+
+	logger.Info("Process complete")
+
+	return err
+}
@@ -0,0 +1,199 @@
+package rman
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestHelperRMAN is not a real test. It stands in for rman when run by startStub
+func TestHelperRMAN(t *testing.T) {
+	if os.Getenv("RUN_RMAN_STUB") != "1" {
+		return
+	}
+
+	hostEcho := regexp.MustCompile(`^host 'echo (.*)';$`)
+
+	fmt.Print("\nRecovery Manager: Release 19.0.0.0.0 - Production\n\n")
+
+	inputScanner := bufio.NewScanner(os.Stdin)
+
+	for fmt.Print("RMAN> "); inputScanner.Scan(); fmt.Print("RMAN> ") {
+		line := strings.TrimSpace(inputScanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "connect target"):
+			fmt.Println("\nconnected to target database: STUB (DBID=1)")
+		case hostEcho.MatchString(line):
+			fmt.Println(hostEcho.FindStringSubmatch(line)[1])
+			fmt.Println("host command complete")
+		case line == "show all;":
+			fmt.Println("\nRMAN configuration parameters for database with db_unique_name STUB are:")
+			fmt.Println("CONFIGURE RETENTION POLICY TO REDUNDANCY 1; # default")
+			fmt.Println("CONFIGURE CONTROLFILE AUTOBACKUP ON;")
+		case line == "exit;":
+			fmt.Println("\n\nRecovery Manager complete.")
+			os.Exit(0)
+		case line == "fail;":
+			fmt.Fprintln(os.Stderr, "RMAN-00571: ===========================================================")
+			fmt.Fprintln(os.Stderr, "RMAN-00569: =============== ERROR MESSAGE STACK FOLLOWS ===============")
+			fmt.Fprintln(os.Stderr, "RMAN-00571: ===========================================================")
+			fmt.Fprintln(os.Stderr, "RMAN-03002: failure of fail command")
+		case line == "crash;":
+			fmt.Fprintln(os.Stderr, "RMAN-00600: internal error")
+			os.Exit(1)
+		default:
+			fmt.Printf("\n%s\n", line)
+		}
+	}
+
+	os.Exit(0)
+}
+
+func startStub(t *testing.T) *Session {
+	os.Setenv("RUN_RMAN_STUB", "1")
+	defer os.Unsetenv("RUN_RMAN_STUB")
+
+	s, err := StartSession(os.Args[0], "-test.run=TestHelperRMAN")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return s
+}
+
+func TestSession(t *testing.T) {
+	s := startStub(t)
+
+	var output bytes.Buffer
+
+	if err := s.Run("connect target /", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "connected to target database: STUB") || strings.Contains(output.String(), "_COMPLETE") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	// Each run only sees its own output
+	output.Reset()
+
+	if err := s.Run("show all;\n", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "CONFIGURE CONTROLFILE AUTOBACKUP ON;") || strings.Contains(output.String(), "connected") || strings.Contains(output.String(), hostComplete) {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	output.Reset()
+
+	if err := s.Run("backup database;", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "backup database;") || strings.Contains(output.String(), "CONFIGURE") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSessionEnded(t *testing.T) {
+	// A script that exits ends the session cleanly
+	s := startStub(t)
+
+	var output bytes.Buffer
+
+	if err := s.Run("exit;", &output); err != ErrSessionEnded {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "Recovery Manager complete.") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	if err := s.Run("show all;", &output); err != ErrSessionEnded {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// rman dying is reported when the session is closed
+	s = startStub(t)
+	output.Reset()
+
+	if err := s.Run("crash;", &output); err != ErrSessionEnded {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "RMAN-00600") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	if err := s.Close(); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestSessionStopsOnError(t *testing.T) {
+	s := startStub(t)
+
+	var output bytes.Buffer
+
+	// A failed command stops the rest of the script as with a command file
+	if err := s.RunScript("show all;\nfail;\nbackup database;\n", &output); err != ErrCommandFailed {
+		t.Fatalf("bad: %v", err)
+	}
+
+	if !strings.Contains(output.String(), "RMAN-00569") || !strings.Contains(output.String(), "CONFIGURE") || strings.Contains(output.String(), "backup database;") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	// The session can still be used e.g. to reset the configuration
+	output.Reset()
+
+	if err := s.RunScript("show all;\nbackup database;\n", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "backup database;") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	script := `# Full backup
+run {
+  allocate channel c1 type disk format '/backup/{%U}';
+  backup database;
+}
+sql "alter system archive log current; ";
+@/opt/run_rman/other.rman
+delete noprompt obsolete; # tidy up
+`
+
+	expected := []string{
+		"# Full backup\nrun {\n  allocate channel c1 type disk format '/backup/{%U}';\n  backup database;\n}",
+		"\nsql \"alter system archive log current; \";",
+		"\n@/opt/run_rman/other.rman\n",
+		"delete noprompt obsolete;",
+	}
+
+	if commands := splitCommands(script); !reflect.DeepEqual(commands, expected) {
+		t.Fatalf("bad: %q", commands)
+	}
+}
@@ -0,0 +1,137 @@
+package rman
+
+// Standard imports
+
+import "fmt"
+import "os/exec"
+import "path/filepath"
+import "regexp"
+import "strconv"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/credential"
+import "github.com/daviesluke/run_rman/environment"
+import "github.com/daviesluke/run_rman/general"
+import "github.com/daviesluke/run_rman/oracle"
+
+// local variables
+
+var bannerRegEx = regexp.MustCompile(`Recovery Manager: Release ([0-9.]+)`)
+
+// local functions
+
+func getRMANVersion(rmanPath string, args ...string) (string, error) {
+	// rman prints its release as it starts and exits at once given exit
+
+	command := exec.Command(rmanPath, args...)
+
+	command.Env   = environment.Environ()
+	command.Stdin = strings.NewReader("exit;\n")
+
+	output, err := command.CombinedOutput()
+
+	if banner := bannerRegEx.FindSubmatch(output); banner != nil {
+		return string(banner[1]), nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no release found in the output of %s", rmanPath)
+}
+
+func getRelease(version string) ([]int, error) {
+	// From 18c the first number is the release e.g. 19.0.0.0.0 before that the first two e.g. 12.2.0.1.0
+
+	var release []int
+
+	fields := strings.SplitN(version, ".", 3)
+
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid version %s", version)
+	}
+
+	for _, field := range fields[:2] {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %s", version)
+		}
+
+		release = append(release, number)
+	}
+
+	if release[0] >= 18 {
+		release = release[:1]
+	}
+
+	return release, nil
+}
+
+func compareVersions(rmanVersion string, databaseVersion string) (int, error) {
+	rmanRelease, err := getRelease(rmanVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	databaseRelease, err := getRelease(databaseVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(rmanRelease) && i < len(databaseRelease); i++ {
+		if rmanRelease[i] != databaseRelease[i] {
+			return rmanRelease[i] - databaseRelease[i], nil
+		}
+	}
+
+	return len(rmanRelease) - len(databaseRelease), nil
+}
+
+// Global functions
+
+func CheckVersion() {
+	logger.Info("Checking RMAN version against the database ...")
+
+	if oracle.Target.Version == "" {
+		logger.Warn("Database version not known. Unable to check the RMAN version")
+		return
+	}
+
+	rmanVersion, err := getRMANVersion(general.RMAN)
+	if err != nil {
+		logger.Warnf("Unable to get the version of %s - %s", general.RMAN, err)
+		return
+	}
+
+	logger.Infof("RMAN %s is release %s and database %s is release %s", general.RMAN, rmanVersion, oracle.Target.InstanceName, oracle.Target.Version)
+
+	difference, err := compareVersions(rmanVersion, oracle.Target.Version)
+	if err != nil {
+		logger.Warnf("Unable to check the RMAN version - %s", err)
+		return
+	}
+
+	switch {
+	case difference < 0:
+		logger.Errorf("RMAN release %s is older than database %s release %s. Set RMANHome to a home of release %s or later", rmanVersion, oracle.Target.InstanceName, oracle.Target.Version, oracle.Target.Version)
+	case difference > 0:
+		logger.Warnf("RMAN release %s is newer than database %s release %s. Check this combination is supported and that any catalog schema is upgraded to release %s", rmanVersion, oracle.Target.InstanceName, oracle.Target.Version, rmanVersion)
+	default:
+		logger.Info("RMAN and database releases match")
+	}
+
+	// Without a net service name rman starts the oracle binary of its own home
+
+	rmanHome := config.ConfigValues["RMANHome"]
+
+	if rmanHome != "" && filepath.Clean(rmanHome) != filepath.Clean(general.OracleHome) && !strings.Contains(credential.Connection("TargetConnection"), "@") {
+		logger.Errorf("TargetConnection must use a net service name when RMANHome %s is not the database home %s", rmanHome, general.OracleHome)
+	}
+
+	logger.Info("Process complete")
+}
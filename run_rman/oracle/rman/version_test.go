@@ -0,0 +1,56 @@
+package rman
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		rman     string
+		database string
+		expected int
+	}{
+		{"19.0.0.0.0", "19.0.0.0.0", 0},
+		{"19.3.0.0.0", "19.0.0.0.0", 0},
+		{"19.0.0.0.0", "12.2.0.1.0", 1},
+		{"12.2.0.1.0", "19.0.0.0.0", -1},
+		{"12.1.0.2.0", "12.2.0.1.0", -1},
+		{"21.0.0.0.0", "19.0.0.0.0", 1},
+	}
+
+	for _, c := range cases {
+		difference, err := compareVersions(c.rman, c.database)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if (difference > 0) != (c.expected > 0) || (difference < 0) != (c.expected < 0) {
+			t.Fatalf("bad: %s %s -> %d", c.rman, c.database, difference)
+		}
+	}
+
+	for _, version := range []string{"", "19", "x.y.z"} {
+		if _, err := compareVersions(version, "19.0.0.0.0"); err == nil {
+			t.Fatalf("expected error for %q", version)
+		}
+	}
+}
+
+func TestGetRMANVersion(t *testing.T) {
+	os.Setenv("RUN_RMAN_STUB", "1")
+	defer os.Unsetenv("RUN_RMAN_STUB")
+
+	version, err := getRMANVersion(os.Args[0], "-test.run=TestHelperRMAN")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if version != "19.0.0.0.0" {
+		t.Fatalf("bad: %s", version)
+	}
+
+	if _, err := getRMANVersion(os.Args[0], "-test.run=NoSuchTest"); err == nil {
+		t.Fatal("expected error without a banner")
+	}
+}
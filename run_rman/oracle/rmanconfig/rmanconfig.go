@@ -0,0 +1,440 @@
+package rmanconfig
+
+// Standard imports
+
+import "bufio"
+import "fmt"
+import "io"
+import "os"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+
+// Global Variables
+
+// Setting is a single persistent RMAN setting
+// Key identifies the setting (e.g. CHANNEL DEVICE TYPE DISK) and Value is the rest of the statement
+// Default is set for settings SHOW ALL marks as # default and for CLEAR statements
+
+type Setting struct {
+	Key     string
+	Value   string
+	Default bool
+}
+
+// Configuration holds settings by key in the order they were read
+
+type Configuration struct {
+	Keys     []string
+	Settings map[string]Setting
+}
+
+// Change is one difference between two configurations and the command that resolves it
+// Current or Desired are nil when the setting is missing from that configuration
+
+type Change struct {
+	Key     string
+	Current *Setting
+	Desired *Setting
+	Command string
+}
+
+// local Variables
+
+const configurePrefix = "CONFIGURE"
+const clearValue      = "CLEAR"
+
+// Settings are identified by the longest of these token patterns matching the start of the statement
+// ? matches any single token such as a device type, channel number or tablespace name
+
+var keyPatterns = [][]string{
+	{ "RETENTION", "POLICY" },
+	{ "BACKUP", "OPTIMIZATION" },
+	{ "DEFAULT", "DEVICE", "TYPE" },
+	{ "CONTROLFILE", "AUTOBACKUP" },
+	{ "CONTROLFILE", "AUTOBACKUP", "FORMAT", "FOR", "DEVICE", "TYPE", "?" },
+	{ "DEVICE", "TYPE", "?" },
+	{ "DATAFILE", "BACKUP", "COPIES", "FOR", "DEVICE", "TYPE", "?" },
+	{ "ARCHIVELOG", "BACKUP", "COPIES", "FOR", "DEVICE", "TYPE", "?" },
+	{ "MAXSETSIZE" },
+	{ "ENCRYPTION", "FOR", "DATABASE" },
+	{ "ENCRYPTION", "FOR", "TABLESPACE", "?" },
+	{ "ENCRYPTION", "ALGORITHM" },
+	{ "COMPRESSION", "ALGORITHM" },
+	{ "RMAN", "OUTPUT" },
+	{ "ARCHIVELOG", "DELETION", "POLICY" },
+	{ "SNAPSHOT", "CONTROLFILE", "NAME" },
+	{ "CHANNEL", "DEVICE", "TYPE", "?" },
+	{ "CHANNEL", "?", "DEVICE", "TYPE", "?" },
+	{ "EXCLUDE", "FOR", "TABLESPACE", "?" },
+	{ "AUXNAME", "FOR", "DATAFILE", "?" },
+	{ "DB_UNIQUE_NAME", "?" },
+}
+
+// Local functions
+
+func tokenize(statement string) []string {
+	// Split on white space and commas outside quotes
+	// Unquoted words are upper cased and quoted strings kept as they are
+
+	var tokens []string
+
+	var token strings.Builder
+	var quote byte
+
+	addToken := func() {
+		if token.Len() > 0 {
+			tokenString := token.String()
+
+			if tokenString[0] == '\'' || tokenString[0] == '"' {
+				// Double quoted strings are equivalent to single quoted ones
+
+				if tokenString[0] == '"' && !strings.Contains(tokenString, "'") {
+					tokenString = "'" + strings.Trim(tokenString, "\"") + "'"
+				}
+			} else {
+				tokenString = strings.ToUpper(tokenString)
+			}
+
+			tokens = append(tokens, tokenString)
+			token.Reset()
+		}
+	}
+
+	for position := 0; position < len(statement); position++ {
+		character := statement[position]
+
+		switch {
+		case quote != 0:
+			token.WriteByte(character)
+
+			if character == quote {
+				quote = 0
+				addToken()
+			}
+		case character == '\'' || character == '"':
+			addToken()
+			quote = character
+			token.WriteByte(character)
+		case character == ',':
+			addToken()
+			tokens = append(tokens, ",")
+		case character == ' ' || character == '\t' || character == '\r' || character == '\n':
+			addToken()
+		default:
+			token.WriteByte(character)
+		}
+	}
+
+	addToken()
+
+	// Device types may be quoted and SBT is the same as SBT_TAPE
+
+	for position := 2; position < len(tokens); position++ {
+		if tokens[position-2] == "DEVICE" && tokens[position-1] == "TYPE" {
+			deviceType := strings.ToUpper(strings.Trim(tokens[position], "'\""))
+
+			if deviceType == "SBT" {
+				deviceType = "SBT_TAPE"
+			}
+
+			tokens[position] = deviceType
+		}
+	}
+
+	return tokens
+}
+
+func matchKey(tokens []string) []string {
+	// Returns the longest pattern matching the start of the tokens
+
+	var keyPattern []string
+
+	for _, pattern := range keyPatterns {
+		if len(pattern) <= len(keyPattern) || len(pattern) > len(tokens) {
+			continue
+		}
+
+		matched := true
+
+		for position, patternToken := range pattern {
+			if patternToken != "?" && patternToken != tokens[position] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			keyPattern = pattern
+		}
+	}
+
+	return keyPattern
+}
+
+func orderDeviceValue(valueTokens []string) []string {
+	// PARALLELISM and BACKUP TYPE may be given in either order so put them in the order SHOW ALL uses
+
+	var parallelism []string
+	var backupType  []string
+	var others      []string
+
+	for position := 0; position < len(valueTokens); position++ {
+		switch {
+		case valueTokens[position] == "PARALLELISM" && position+1 < len(valueTokens):
+			parallelism = valueTokens[position:position+2]
+			position++
+		case valueTokens[position] == "BACKUP" && position+3 < len(valueTokens) && valueTokens[position+1] == "TYPE" && valueTokens[position+2] == "TO":
+			clauseLength := 4
+
+			if valueTokens[position+3] == "COMPRESSED" && position+4 < len(valueTokens) {
+				clauseLength = 5
+			}
+
+			backupType = valueTokens[position:position+clauseLength]
+			position += clauseLength - 1
+		default:
+			others = append(others, valueTokens[position])
+		}
+	}
+
+	orderedTokens := append(append(append([]string{}, parallelism...), backupType...), others...)
+
+	return orderedTokens
+}
+
+func parseStatement(statement string) (Setting, error) {
+	tokens := tokenize(statement)
+
+	if len(tokens) < 2 || tokens[0] != configurePrefix {
+		return Setting{}, fmt.Errorf("Invalid CONFIGURE statement %s", statement)
+	}
+
+	tokens = tokens[1:]
+
+	keyPattern := matchKey(tokens)
+	keyLength  := len(keyPattern)
+
+	if keyLength == 0 {
+		// Unknown settings can only be compared as a whole
+
+		logger.Debugf("Unrecognised setting %s. Using whole statement as key", statement)
+
+		keyLength = len(tokens)
+	}
+
+	// Names in the key such as tablespaces may be given with or without quotes
+
+	for position, patternToken := range keyPattern {
+		if patternToken == "?" {
+			tokens[position] = strings.ToUpper(strings.Trim(tokens[position], "'\""))
+		}
+	}
+
+	setting := Setting{ Key: strings.Join(tokens[:keyLength], " ") }
+
+	valueTokens := tokens[keyLength:]
+
+	if len(valueTokens) == 1 && valueTokens[0] == clearValue {
+		setting.Default = true
+		return setting, nil
+	}
+
+	if len(tokens) > 2 && tokens[0] == "DEVICE" && tokens[1] == "TYPE" {
+		valueTokens = orderDeviceValue(valueTokens)
+	}
+
+	setting.Value = strings.Join(valueTokens, " ")
+
+	return setting, nil
+}
+
+func (c *Configuration) add(setting Setting) {
+	if _, found := c.Settings[setting.Key]; !found {
+		c.Keys = append(c.Keys, setting.Key)
+	}
+
+	// Later statements override earlier ones just as they would in RMAN
+
+	c.Settings[setting.Key] = setting
+}
+
+// Global functions
+
+func NewConfiguration() *Configuration {
+	return &Configuration{ Settings: make(map[string]Setting) }
+}
+
+func Parse(reader io.Reader) (*Configuration, error) {
+	// Reads CONFIGURE statements from a config file or SHOW ALL output ignoring anything else
+	// Statements may span lines and a # default comment after a statement marks it as a default
+
+	configuration := NewConfiguration()
+
+	var statement strings.Builder
+
+	collecting := false
+
+	lineScanner := bufio.NewScanner(reader)
+
+	for lineScanner.Scan() {
+		line    := lineScanner.Text()
+		lastKey := ""
+
+		var quote byte
+
+		for position := 0; position < len(line); position++ {
+			if !collecting {
+				rest := strings.TrimLeft(line[position:], " \t")
+
+				if strings.HasPrefix(rest, "#") {
+					if lastKey != "" && strings.ToLower(strings.TrimSpace(strings.TrimPrefix(rest, "#"))) == "default" {
+						setting := configuration.Settings[lastKey]
+						setting.Default = true
+						configuration.Settings[lastKey] = setting
+					}
+
+					break
+				}
+
+				if !strings.HasPrefix(strings.ToUpper(rest), configurePrefix) {
+					break
+				}
+
+				collecting = true
+				position   = len(line) - len(rest)
+				statement.Reset()
+			}
+
+			character := line[position]
+
+			switch {
+			case quote != 0:
+				if character == quote {
+					quote = 0
+				}
+
+				statement.WriteByte(character)
+			case character == '\'' || character == '"':
+				quote = character
+				statement.WriteByte(character)
+			case character == '#':
+				position = len(line)
+			case character == ';':
+				setting, err := parseStatement(statement.String())
+				if err != nil {
+					return nil, err
+				}
+
+				configuration.add(setting)
+
+				lastKey    = setting.Key
+				collecting = false
+			default:
+				statement.WriteByte(character)
+			}
+		}
+
+		if collecting {
+			statement.WriteByte(' ')
+		}
+	}
+
+	if err := lineScanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if collecting {
+		return nil, fmt.Errorf("Unterminated CONFIGURE statement %s", strings.TrimSpace(statement.String()))
+	}
+
+	return configuration, nil
+}
+
+func ParseFile(fileName string) *Configuration {
+	logger.Infof("Reading RMAN configuration from %s ...", fileName)
+
+	configFile, err := os.Open(fileName)
+	if err != nil {
+		logger.Errorf("Unable to open RMAN configuration file %s", fileName)
+	}
+
+	defer configFile.Close()
+
+	configuration, err := Parse(configFile)
+	if err != nil {
+		logger.Errorf("Unable to parse RMAN configuration file %s - %s", fileName, err)
+	}
+
+	logger.Infof("Found %d settings", len(configuration.Keys))
+
+	return configuration
+}
+
+func (s Setting) Command() string {
+	if s.Default {
+		return s.ClearCommand()
+	}
+
+	return strings.Join( []string{ configurePrefix, " ", s.Key, " ", s.Value, ";" }, "")
+}
+
+func (s Setting) ClearCommand() string {
+	return strings.Join( []string{ configurePrefix, " ", s.Key, " ", clearValue, ";" }, "")
+}
+
+func (s Setting) String() string {
+	if s.Default {
+		if s.Value == "" {
+			return strings.Join( []string{ s.Key, "(default)" }, " ")
+		}
+
+		return strings.Join( []string{ s.Key, s.Value, "(default)" }, " ")
+	}
+
+	return strings.Join( []string{ s.Key, s.Value }, " ")
+}
+
+func Diff(current *Configuration, desired *Configuration, clearMissing bool) []Change {
+	// Returns the changes needed to take the current configuration to the desired one
+	// Settings missing from the desired configuration are only cleared if clearMissing is set,
+	// as when returning to a complete SHOW ALL baseline
+
+	var changes []Change
+
+	for _, key := range desired.Keys {
+		desiredSetting := desired.Settings[key]
+
+		currentSetting, found := current.Settings[key]
+
+		if desiredSetting.Default {
+			// Return to the default unless already there
+
+			if found && !currentSetting.Default && currentSetting.Value != desiredSetting.Value {
+				changes = append(changes, Change{ key, &currentSetting, &desiredSetting, desiredSetting.ClearCommand() })
+			}
+
+			continue
+		}
+
+		if !found {
+			changes = append(changes, Change{ key, nil, &desiredSetting, desiredSetting.Command() })
+		} else if currentSetting.Value != desiredSetting.Value {
+			changes = append(changes, Change{ key, &currentSetting, &desiredSetting, desiredSetting.Command() })
+		}
+	}
+
+	if clearMissing {
+		for _, key := range current.Keys {
+			currentSetting := current.Settings[key]
+
+			if _, found := desired.Settings[key]; !found && !currentSetting.Default {
+				changes = append(changes, Change{ key, &currentSetting, nil, currentSetting.ClearCommand() })
+			}
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,214 @@
+package rmanconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// SHOW ALL output captured from a 19c database with a few settings changed
+const showAll19c = `
+Recovery Manager: Release 19.0.0.0.0 - Production on Mon Oct 19 02:00:01 2026
+
+RMAN> connect target *
+connected to target database: ORCL (DBID=1592734481)
+
+RMAN> show all;
+
+using target database control file instead of recovery catalog
+RMAN configuration parameters for database with db_unique_name ORCL are:
+CONFIGURE RETENTION POLICY TO RECOVERY WINDOW OF 7 DAYS;
+CONFIGURE BACKUP OPTIMIZATION OFF; # default
+CONFIGURE DEFAULT DEVICE TYPE TO DISK; # default
+CONFIGURE CONTROLFILE AUTOBACKUP ON;
+CONFIGURE CONTROLFILE AUTOBACKUP FORMAT FOR DEVICE TYPE DISK TO '%F'; # default
+CONFIGURE DEVICE TYPE DISK PARALLELISM 4 BACKUP TYPE TO COMPRESSED BACKUPSET;
+CONFIGURE DATAFILE BACKUP COPIES FOR DEVICE TYPE DISK TO 1; # default
+CONFIGURE ARCHIVELOG BACKUP COPIES FOR DEVICE TYPE DISK TO 1; # default
+CONFIGURE CHANNEL DEVICE TYPE DISK FORMAT   '/backup/ORCL/%U';
+CONFIGURE MAXSETSIZE TO UNLIMITED; # default
+CONFIGURE ENCRYPTION FOR DATABASE OFF; # default
+CONFIGURE ENCRYPTION ALGORITHM 'AES128'; # default
+CONFIGURE COMPRESSION ALGORITHM 'BASIC' AS OF RELEASE 'DEFAULT' OPTIMIZE FOR LOAD TRUE ; # default
+CONFIGURE RMAN OUTPUT TO KEEP FOR 7 DAYS; # default
+CONFIGURE ARCHIVELOG DELETION POLICY TO NONE; # default
+CONFIGURE SNAPSHOT CONTROLFILE NAME TO '/u01/app/oracle/product/19.0.0/dbhome_1/dbs/snapcf_ORCL.f'; # default
+
+RMAN>
+
+Recovery Manager complete.
+`
+
+// The same database with every setting at its default
+const showAllDefault = `
+RMAN configuration parameters for database with db_unique_name ORCL are:
+CONFIGURE RETENTION POLICY TO REDUNDANCY 1; # default
+CONFIGURE BACKUP OPTIMIZATION OFF; # default
+CONFIGURE DEFAULT DEVICE TYPE TO DISK; # default
+CONFIGURE CONTROLFILE AUTOBACKUP OFF; # default
+CONFIGURE CONTROLFILE AUTOBACKUP FORMAT FOR DEVICE TYPE DISK TO '%F'; # default
+CONFIGURE DEVICE TYPE DISK PARALLELISM 1 BACKUP TYPE TO BACKUPSET; # default
+CONFIGURE DATAFILE BACKUP COPIES FOR DEVICE TYPE DISK TO 1; # default
+CONFIGURE ARCHIVELOG BACKUP COPIES FOR DEVICE TYPE DISK TO 1; # default
+CONFIGURE MAXSETSIZE TO UNLIMITED; # default
+CONFIGURE ENCRYPTION FOR DATABASE OFF; # default
+CONFIGURE ENCRYPTION ALGORITHM 'AES128'; # default
+CONFIGURE COMPRESSION ALGORITHM 'BASIC' AS OF RELEASE 'DEFAULT' OPTIMIZE FOR LOAD TRUE ; # default
+CONFIGURE RMAN OUTPUT TO KEEP FOR 7 DAYS; # default
+CONFIGURE ARCHIVELOG DELETION POLICY TO NONE; # default
+CONFIGURE SNAPSHOT CONTROLFILE NAME TO '/u01/app/oracle/product/19.0.0/dbhome_1/dbs/snapcf_ORCL.f'; # default
+`
+
+func mustParse(t *testing.T, text string) *Configuration {
+	configuration, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return configuration
+}
+
+func commands(changes []Change) []string {
+	var commandList []string
+
+	for _, change := range changes {
+		commandList = append(commandList, change.Command)
+	}
+
+	return commandList
+}
+
+func TestParseShowAll(t *testing.T) {
+	configuration := mustParse(t, showAll19c)
+
+	if len(configuration.Keys) != 16 {
+		t.Fatalf("bad: %#v", configuration.Keys)
+	}
+
+	expected := map[string]Setting{
+		"RETENTION POLICY":         { "RETENTION POLICY", "TO RECOVERY WINDOW OF 7 DAYS", false },
+		"BACKUP OPTIMIZATION":      { "BACKUP OPTIMIZATION", "OFF", true },
+		"CONTROLFILE AUTOBACKUP":   { "CONTROLFILE AUTOBACKUP", "ON", false },
+		"DEVICE TYPE DISK":         { "DEVICE TYPE DISK", "PARALLELISM 4 BACKUP TYPE TO COMPRESSED BACKUPSET", false },
+		"CHANNEL DEVICE TYPE DISK": { "CHANNEL DEVICE TYPE DISK", "FORMAT '/backup/ORCL/%U'", false },
+		"COMPRESSION ALGORITHM":    { "COMPRESSION ALGORITHM", "'BASIC' AS OF RELEASE 'DEFAULT' OPTIMIZE FOR LOAD TRUE", true },
+		"CONTROLFILE AUTOBACKUP FORMAT FOR DEVICE TYPE DISK": { "CONTROLFILE AUTOBACKUP FORMAT FOR DEVICE TYPE DISK", "TO '%F'", true },
+	}
+
+	for key, setting := range expected {
+		if configuration.Settings[key] != setting {
+			t.Fatalf("bad: %#v", configuration.Settings[key])
+		}
+	}
+}
+
+func TestParseNormalizes(t *testing.T) {
+	// Spacing, case, quoting, device aliases and clause order do not change a setting
+	configuration := mustParse(t, `
+configure channel device type 'sbt' parms "ENV=(NB_ORA_POLICY=orcl)";
+Configure Device Type Disk Backup Type To Compressed Backupset
+    Parallelism 4;  configure controlfile autobackup on; # comment
+CONFIGURE CHANNEL 1 DEVICE TYPE DISK FORMAT '/a/%U','/b/%U';
+CONFIGURE EXCLUDE FOR TABLESPACE 'USERS';
+CONFIGURE RETENTION POLICY CLEAR;
+`)
+
+	expected := []Setting{
+		{ "CHANNEL DEVICE TYPE SBT_TAPE", "PARMS 'ENV=(NB_ORA_POLICY=orcl)'", false },
+		{ "DEVICE TYPE DISK", "PARALLELISM 4 BACKUP TYPE TO COMPRESSED BACKUPSET", false },
+		{ "CONTROLFILE AUTOBACKUP", "ON", false },
+		{ "CHANNEL 1 DEVICE TYPE DISK", "FORMAT '/a/%U' , '/b/%U'", false },
+		{ "EXCLUDE FOR TABLESPACE USERS", "", false },
+		{ "RETENTION POLICY", "", true },
+	}
+
+	if len(configuration.Keys) != len(expected) {
+		t.Fatalf("bad: %#v", configuration.Keys)
+	}
+
+	for position, setting := range expected {
+		if configuration.Settings[configuration.Keys[position]] != setting {
+			t.Fatalf("bad: %#v", configuration.Settings[configuration.Keys[position]])
+		}
+	}
+
+	// Formatted differently from SHOW ALL but the same settings
+	if changes := Diff(mustParse(t, showAll19c), mustParse(t, `
+configure retention policy to recovery window of 7 days;
+CONFIGURE CONTROLFILE AUTOBACKUP ON ;
+CONFIGURE DEVICE TYPE 'DISK' BACKUP TYPE TO COMPRESSED BACKUPSET PARALLELISM 4;
+CONFIGURE CHANNEL DEVICE TYPE DISK FORMAT "/backup/ORCL/%U";
+`), false); len(changes) != 0 {
+		t.Fatalf("bad: %#v", commands(changes))
+	}
+}
+
+func TestParseUnterminated(t *testing.T) {
+	if _, err := Parse(strings.NewReader("CONFIGURE CONTROLFILE AUTOBACKUP ON\n")); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestDiffApply(t *testing.T) {
+	// Applying a partial config only sets what it names
+	desired := mustParse(t, `
+CONFIGURE RETENTION POLICY TO RECOVERY WINDOW OF 14 DAYS;
+CONFIGURE CONTROLFILE AUTOBACKUP ON;
+CONFIGURE CHANNEL DEVICE TYPE SBT_TAPE PARMS 'ENV=(NB_ORA_POLICY=orcl)';
+CONFIGURE ARCHIVELOG DELETION POLICY CLEAR;
+`)
+
+	changes := Diff(mustParse(t, showAll19c), desired, false)
+
+	expected := []string{
+		"CONFIGURE RETENTION POLICY TO RECOVERY WINDOW OF 14 DAYS;",
+		"CONFIGURE CHANNEL DEVICE TYPE SBT_TAPE PARMS 'ENV=(NB_ORA_POLICY=orcl)';",
+	}
+
+	if !reflect.DeepEqual(commands(changes), expected) {
+		t.Fatalf("bad: %#v", commands(changes))
+	}
+
+	if changes[0].Current == nil || changes[0].Current.Value != "TO RECOVERY WINDOW OF 7 DAYS" || changes[1].Current != nil {
+		t.Fatalf("bad: %#v", changes)
+	}
+}
+
+func TestDiffReset(t *testing.T) {
+	// Returning to a baseline clears settings that were defaults and anything added since
+	current := mustParse(t, showAll19c + `
+CONFIGURE CHANNEL DEVICE TYPE 'SBT_TAPE' PARMS 'ENV=(NB_ORA_POLICY=orcl)';
+`)
+
+	changes := Diff(current, mustParse(t, showAllDefault), true)
+
+	expected := []string{
+		"CONFIGURE RETENTION POLICY CLEAR;",
+		"CONFIGURE CONTROLFILE AUTOBACKUP CLEAR;",
+		"CONFIGURE DEVICE TYPE DISK CLEAR;",
+		"CONFIGURE CHANNEL DEVICE TYPE DISK CLEAR;",
+		"CONFIGURE CHANNEL DEVICE TYPE SBT_TAPE CLEAR;",
+	}
+
+	if !reflect.DeepEqual(commands(changes), expected) {
+		t.Fatalf("bad: %#v", commands(changes))
+	}
+
+	// And back again sets only what differs
+	changes = Diff(mustParse(t, showAllDefault), mustParse(t, showAll19c), true)
+
+	expected = []string{
+		"CONFIGURE RETENTION POLICY TO RECOVERY WINDOW OF 7 DAYS;",
+		"CONFIGURE CONTROLFILE AUTOBACKUP ON;",
+		"CONFIGURE DEVICE TYPE DISK PARALLELISM 4 BACKUP TYPE TO COMPRESSED BACKUPSET;",
+		"CONFIGURE CHANNEL DEVICE TYPE DISK FORMAT '/backup/ORCL/%U';",
+	}
+
+	if !reflect.DeepEqual(commands(changes), expected) {
+		t.Fatalf("bad: %#v", commands(changes))
+	}
+
+	if changes := Diff(current, current, true); len(changes) != 0 {
+		t.Fatalf("bad: %#v", commands(changes))
+	}
+}
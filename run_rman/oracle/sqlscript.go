@@ -0,0 +1,125 @@
+package oracle
+
+// Standard imports
+
+import "bufio"
+import "database/sql"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+import "regexp"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+
+// local Variables
+
+// plsqlRegEx matches the start of statements that hold ; themselves and so end with a / line
+
+var plsqlRegEx = regexp.MustCompile(`^(?i)(DECLARE|BEGIN|CREATE\s+(OR\s+REPLACE\s+)?((NON)?EDITIONABLE\s+)?(FUNCTION|PROCEDURE|PACKAGE|TRIGGER|TYPE))\b`)
+
+// sqlplusCommands only affect SQL*Plus output so are skipped
+
+var sqlplusCommands = []string{ "REM", "REMARK", "SET", "PROMPT", "SPOOL", "WHENEVER", "SHOW", "COLUMN", "EXIT", "QUIT" }
+
+// Local functions
+
+func isSQLPlusCommand(line string) bool {
+	words := strings.Fields(line)
+
+	if len(words) == 0 {
+		return false
+	}
+
+	for _, command := range sqlplusCommands {
+		if strings.ToUpper(strings.TrimSuffix(words[0], ";")) == command {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitStatements(input io.Reader) ([]string, error) {
+	// Follows SQL*Plus rules. SQL ends with ; at the end of a line or a / line
+	// and PL/SQL ends with a / line
+
+	var statements []string
+	var statementLines []string
+
+	isPLSQL := false
+
+	scanner := bufio.NewScanner(input)
+
+	for scanner.Scan() {
+		line    := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+
+		if len(statementLines) == 0 {
+			if trimmed == "" || trimmed == "/" || strings.HasPrefix(trimmed, "--") || isSQLPlusCommand(trimmed) {
+				continue
+			}
+
+			isPLSQL = plsqlRegEx.MatchString(trimmed)
+		}
+
+		switch {
+		case trimmed == "/":
+			statements     = append(statements, strings.Join(statementLines, "\n"))
+			statementLines = nil
+		case !isPLSQL && strings.HasSuffix(trimmed, ";"):
+			statementLines = append(statementLines, strings.TrimSuffix(line, ";"))
+			statements     = append(statements, strings.Join(statementLines, "\n"))
+			statementLines = nil
+		default:
+			statementLines = append(statementLines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(statementLines) > 0 {
+		return nil, errors.New("last statement is not terminated by ; or /")
+	}
+
+	return statements, nil
+}
+
+// Global functions
+
+func RunSQLFile(db *sql.DB, fileName string) error {
+	logger.Infof("Running SQL script %s ...", fileName)
+
+	sqlFile, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer sqlFile.Close()
+
+	statements, err := splitStatements(sqlFile)
+	if err != nil {
+		return fmt.Errorf("unable to read %s - %s", fileName, err)
+	}
+
+	// Statements are not logged as they may set passwords
+
+	for i, statement := range statements {
+		logger.Debugf("Running statement %d of %d", i + 1, len(statements))
+
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("statement %d in %s failed - %s", i + 1, fileName, err)
+		}
+	}
+
+	logger.Infof("Ran %d statements", len(statements))
+
+	logger.Info("Process complete")
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package oracle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testScript = `-- Mask the copy
+SET ECHO ON
+WHENEVER SQLERROR EXIT FAILURE
+update customers
+   set email = 'x@example.com';
+
+alter user app identified by "new;pass"
+/
+begin
+  dbms_stats.gather_schema_stats('APP');
+end;
+/
+create or replace procedure tidy as
+begin
+  null;
+end;
+/
+commit;
+exit
+`
+
+func TestSplitStatements(t *testing.T) {
+	statements, err := splitStatements(strings.NewReader(testScript))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{
+		"update customers\n   set email = 'x@example.com'",
+		"alter user app identified by \"new;pass\"",
+		"begin\n  dbms_stats.gather_schema_stats('APP');\nend;",
+		"create or replace procedure tidy as\nbegin\n  null;\nend;",
+		"commit",
+	}
+
+	if !reflect.DeepEqual(statements, expected) {
+		t.Fatalf("bad: %#v", statements)
+	}
+
+	if _, err := splitStatements(strings.NewReader("begin\n  null;\nend;\n")); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestRunSQLFile(t *testing.T) {
+	d := useTestDriver(t)
+	defer resetTestDriver()
+
+	dir, err := ioutil.TempDir("", "sqlscript")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "mask.sql")
+
+	if err := ioutil.WriteFile(fileName, []byte(testScript), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	db, err := openConnection("sys/pass@aux?as=sysdba")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+
+	if err := RunSQLFile(db, fileName); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(d.executed) != 5 || d.executed[4] != "commit" {
+		t.Fatalf("bad: %#v", d.executed)
+	}
+
+	// A failing statement stops the script
+	d.executed = nil
+
+	if err := ioutil.WriteFile(fileName, []byte("delete from missing_table;\ncommit;\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := RunSQLFile(db, fileName); err == nil || !strings.Contains(err.Error(), "statement 1") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	if len(d.executed) != 0 {
+		t.Fatalf("bad: %#v", d.executed)
+	}
+}
@@ -0,0 +1,256 @@
+package oracle
+
+// Standard imports
+
+import "database/sql"
+import "errors"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+
+// Global Variables
+
+// RecoveryArea is the space usage of the fast recovery area in bytes
+
+type RecoveryArea struct {
+	Limit       int64
+	Used        int64
+	Reclaimable int64
+}
+
+// RecoverableWindow is the range a restore of the latest backups can be recovered to
+
+type RecoverableWindow struct {
+	FromSCN  int64
+	ToSCN    int64
+	FromTime string
+	ToTime   string
+}
+
+// Local functions
+
+func openTarget() (*sql.DB, error) {
+	return openConnection(targetConnectionString())
+}
+
+// Global functions
+
+func OpenTarget() *sql.DB {
+	logger.Info("Opening target connection ...")
+
+	db, err := openTarget()
+	if err != nil {
+		connectionFailed("target", targetConnectionString(), err)
+	}
+
+	logger.Debug("Process complete")
+
+	return db
+}
+
+func (r RecoveryArea) UsedPct() float64 {
+	// Space RMAN can reclaim by deleting obsolete files is counted as free
+
+	if r.Limit <= 0 {
+		return 0
+	}
+
+	return float64(r.Used - r.Reclaimable) * 100 / float64(r.Limit)
+}
+
+func GetRecoveryArea() (RecoveryArea, bool, error) {
+	logger.Debug("Getting recovery area usage ...")
+
+	var recoveryArea RecoveryArea
+
+	db, err := openTarget()
+	if err != nil {
+		return recoveryArea, false, err
+	}
+
+	defer db.Close()
+
+	err = db.QueryRow("select space_limit, space_used, space_reclaimable from v$recovery_file_dest").Scan(&recoveryArea.Limit, &recoveryArea.Used, &recoveryArea.Reclaimable)
+
+	if err == sql.ErrNoRows || (err == nil && recoveryArea.Limit == 0) {
+		logger.Debug("No recovery area configured")
+		return recoveryArea, false, nil
+	}
+
+	logger.Debug("Process complete")
+
+	return recoveryArea, err == nil, err
+}
+
+func GetRunningBackupJobs() (int, error) {
+	logger.Debug("Getting running RMAN jobs ...")
+
+	db, err := openTarget()
+	if err != nil {
+		return 0, err
+	}
+
+	defer db.Close()
+
+	var jobCount int
+
+	err = db.QueryRow("select count(*) from v$rman_backup_job_details where status like 'RUNNING%'").Scan(&jobCount)
+
+	logger.Debug("Process complete")
+
+	return jobCount, err
+}
+
+func GetBackupBytes(since time.Time) int64 {
+	logger.Debug("Getting size of backups written ...")
+
+	db, err := openTarget()
+	if err != nil {
+		logger.Warnf("Unable to get the size of the backup - %s", err)
+		return 0
+	}
+
+	defer db.Close()
+
+	var outputBytes sql.NullInt64
+
+	err = db.QueryRow("select sum(output_bytes) from v$rman_backup_job_details where start_time >= :1", since).Scan(&outputBytes)
+	if err != nil {
+		logger.Warnf("Unable to get the size of the backup - %s", err)
+		return 0
+	}
+
+	logger.Debugf("Backups wrote %d bytes", outputBytes.Int64)
+
+	logger.Debug("Process complete")
+
+	return outputBytes.Int64
+}
+
+func GetRecoverableWindow() (RecoverableWindow, error) {
+	logger.Debug("Getting recoverable window ...")
+
+	var window RecoverableWindow
+
+	db, err := openTarget()
+	if err != nil {
+		return window, err
+	}
+
+	defer db.Close()
+
+	// Restoring uses the latest level 0 or full backup of each file so recovery can only start
+	// once every file has reached its checkpoint, and goes as far as the latest archived log on disk or in a backup
+
+	var fromSCN, toSCN   sql.NullInt64
+	var fromTime, toTime sql.NullString
+
+	rows, err := db.Query("select file#, max(checkpoint_change#), to_char(max(checkpoint_time), 'YYYY/MM/DD:HH24:MI:SS') from v$backup_datafile where file# > 0 and nvl(incremental_level, 0) = 0 group by file#")
+	if err != nil {
+		return window, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var fileNumber int
+		var fileSCN    sql.NullInt64
+		var fileTime   sql.NullString
+
+		if err := rows.Scan(&fileNumber, &fileSCN, &fileTime); err != nil {
+			return window, err
+		}
+
+		logger.Debugf("Latest backup of file %d has checkpoint SCN %d", fileNumber, fileSCN.Int64)
+
+		if fileSCN.Valid && (!fromSCN.Valid || fileSCN.Int64 < fromSCN.Int64) {
+			fromSCN  = fileSCN
+			fromTime = fileTime
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return window, err
+	}
+
+	err = db.QueryRow("select max(next_change#), to_char(max(next_time), 'YYYY/MM/DD:HH24:MI:SS') from (select next_change#, next_time from v$archived_log where status = 'A' union all select next_change#, next_time from v$backup_redolog)").Scan(&toSCN, &toTime)
+	if err != nil {
+		return window, err
+	}
+
+	if !fromSCN.Valid {
+		return window, errors.New("no datafile backups found")
+	}
+
+	window = RecoverableWindow{ FromSCN: fromSCN.Int64, ToSCN: fromSCN.Int64, FromTime: fromTime.String, ToTime: fromTime.String }
+
+	if toSCN.Valid && toSCN.Int64 > fromSCN.Int64 {
+		window.ToSCN  = toSCN.Int64
+		window.ToTime = toTime.String
+	}
+
+	logger.Debugf("Recoverable window is SCN %d to %d", window.FromSCN, window.ToSCN)
+
+	logger.Debug("Process complete")
+
+	return window, nil
+}
+
+func GetDatafiles() ([]int, error) {
+	logger.Debug("Getting datafile numbers ...")
+
+	db, err := openTarget()
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	rows, err := db.Query("select file# from v$datafile order by file#")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var datafiles []int
+
+	for rows.Next() {
+		var fileNumber int
+
+		if err := rows.Scan(&fileNumber); err != nil {
+			return nil, err
+		}
+
+		datafiles = append(datafiles, fileNumber)
+	}
+
+	logger.Debugf("Found %d datafiles", len(datafiles))
+
+	logger.Debug("Process complete")
+
+	return datafiles, rows.Err()
+}
+
+func GetUnbackedArchiveBytes() (int64, error) {
+	logger.Debug("Getting size of archived logs not backed up ...")
+
+	db, err := openTarget()
+	if err != nil {
+		return 0, err
+	}
+
+	defer db.Close()
+
+	// Only logs still on disk count as they are what fills the recovery area
+
+	var archiveBytes int64
+
+	err = db.QueryRow("select nvl(sum(blocks * block_size), 0) from v$archived_log where backup_count = 0 and deleted = 'NO' and status = 'A' and standby_dest = 'NO'").Scan(&archiveBytes)
+
+	logger.Debug("Process complete")
+
+	return archiveBytes, err
+}
@@ -0,0 +1,48 @@
+package oracle
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/daviesluke/run_rman/internal/testdb"
+)
+
+func TestGetRecoverableWindow(t *testing.T) {
+	// Rows are each file's latest level 0 backup as returned by the group by
+	datafiles := [][]driver.Value{
+		{int64(1), int64(5000), "2024/01/02:10:00:00"},
+		{int64(2), int64(3000), "2024/01/01:10:00:00"},
+		{int64(3), int64(4000), "2024/01/01:22:00:00"},
+	}
+
+	query := func(query string, args []driver.Value) ([][]driver.Value, error) {
+		if strings.Contains(query, "v$backup_datafile") {
+			return datafiles, nil
+		}
+
+		return [][]driver.Value{{int64(6000), "2024/01/03:10:00:00"}}, nil
+	}
+
+	sql.Register("oracletest"+t.Name(), &testdb.Driver{Query: query})
+
+	driverName = "oracletest" + t.Name()
+	defer resetTestDriver()
+
+	window, err := GetRecoverableWindow()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Recovery cannot start before the file with the oldest backup
+	if window != (RecoverableWindow{FromSCN: 3000, ToSCN: 6000, FromTime: "2024/01/01:10:00:00", ToTime: "2024/01/03:10:00:00"}) {
+		t.Fatalf("bad: %#v", window)
+	}
+
+	datafiles = nil
+
+	if _, err := GetRecoverableWindow(); err == nil {
+		t.Fatal("expected error with no datafile backups")
+	}
+}
@@ -0,0 +1,71 @@
+package oratab
+
+// Standard imports
+
+import "bufio"
+import "bytes"
+import "errors"
+import "fmt"
+import "os"
+import "os/exec"
+import "runtime"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+
+// Global functions
+
+func OraEnv(envFile string, sid string) (map[string]string, error) {
+	logger.Debugf("Getting environment for %s from %s ...", sid, envFile)
+
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("EnvFile is not supported on Windows")
+	}
+
+	// oraenv only changes the shell that sources it so the environment is printed afterwards
+	// Nothing is read from stdin and ORAENV_ASK=NO stops it prompting
+
+	// The file is passed as an argument so the shell never parses its name
+
+	command := exec.Command("/bin/sh", "-c", `. "$1" > /dev/null 2>&1; env`, "sh", envFile)
+
+	// A home already set must not be mistaken for one found by oraenv
+
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "ORACLE_HOME=") && !strings.HasPrefix(variable, "ORACLE_SID=") {
+			command.Env = append(command.Env, variable)
+		}
+	}
+
+	command.Env = append(command.Env, "ORACLE_SID=" + sid, "ORAENV_ASK=NO")
+
+	var output bytes.Buffer
+
+	command.Stdout = &output
+
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("unable to run %s - %s", envFile, err)
+	}
+
+	environment := make(map[string]string)
+
+	scanner := bufio.NewScanner(&output)
+
+	for scanner.Scan() {
+		if envTokens := strings.SplitN(scanner.Text(), "=", 2); len(envTokens) == 2 {
+			environment[envTokens[0]] = envTokens[1]
+		}
+	}
+
+	if environment["ORACLE_HOME"] == "" {
+		return nil, fmt.Errorf("%s did not set ORACLE_HOME for %s", envFile, sid)
+	}
+
+	logger.Debugf("ORACLE_HOME for %s is %s", sid, environment["ORACLE_HOME"])
+
+	logger.Debug("Process complete")
+
+	return environment, nil
+}
@@ -0,0 +1,229 @@
+package oratab
+
+// Standard imports
+
+import "bufio"
+import "fmt"
+import "io"
+import "os"
+import "path/filepath"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+
+// Global Variables
+
+// Entry is a line of an oratab file
+
+type Entry struct {
+	SID   string
+	Home  string
+	Start bool
+	File  string
+	Line  int
+}
+
+// ParseError describes a line of an oratab file that could not be used
+
+type ParseError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+// local Variables
+
+var startFlags = map[string]bool{ "Y": true, "N": false, "W": false }
+
+// Local functions
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s line %d - %s", e.File, e.Line, e.Message)
+}
+
+func parseLine(line string) (Entry, string) {
+	// Returns the entry or why the line is not valid
+
+	var entry Entry
+
+	fields := strings.Split(line, setup.PathDelimiter)
+
+	if len(fields) < 2 || len(fields) > 3 {
+		return entry, "expected SID:ORACLE_HOME:Y|N"
+	}
+
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	entry.SID  = fields[0]
+	entry.Home = fields[1]
+
+	if entry.SID == "" {
+		return entry, "no SID"
+	}
+
+	if !filepath.IsAbs(entry.Home) {
+		return entry, fmt.Sprintf("ORACLE_HOME %s is not a full path", entry.Home)
+	}
+
+	if len(fields) == 3 {
+		start, valid := startFlags[strings.ToUpper(fields[2])]
+
+		if !valid {
+			return entry, fmt.Sprintf("start flag %s is not Y, N or W", fields[2])
+		}
+
+		entry.Start = start
+	}
+
+	return entry, ""
+}
+
+// Global functions
+
+func (entry Entry) IsDatabase() bool {
+	// ASM, the management database and * entries are not backed up by RMAN scripts
+
+	return entry.SID != "*" && !strings.HasPrefix(entry.SID, "+") && !strings.HasPrefix(entry.SID, "-")
+}
+
+func Parse(input io.Reader, fileName string) ([]Entry, []error) {
+	var entries  []Entry
+	var problems []error
+
+	found := make(map[string]int)
+
+	scanner := bufio.NewScanner(input)
+
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+
+		// Comments may follow an entry
+
+		line := strings.TrimSpace(strings.SplitN(scanner.Text(), "#", 2)[0])
+
+		if line == "" {
+			continue
+		}
+
+		entry, problem := parseLine(line)
+
+		if problem != "" {
+			problems = append(problems, &ParseError{ File: fileName, Line: lineNo, Message: problem })
+			continue
+		}
+
+		// The first entry for a SID is used as it is by oraenv
+
+		if firstLine, duplicate := found[entry.SID]; duplicate {
+			problems = append(problems, &ParseError{ File: fileName, Line: lineNo, Message: fmt.Sprintf("%s already listed on line %d", entry.SID, firstLine) })
+			continue
+		}
+
+		found[entry.SID] = lineNo
+
+		entry.File = fileName
+		entry.Line = lineNo
+
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		problems = append(problems, err)
+	}
+
+	return entries, problems
+}
+
+func Read(fileName string) ([]Entry, error) {
+	logger.Debugf("Reading oratab %s ...", fileName)
+
+	oratabFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer oratabFile.Close()
+
+	entries, problems := Parse(oratabFile, fileName)
+
+	for _, problem := range problems {
+		logger.Warnf("Ignoring oratab entry - %s", problem)
+	}
+
+	logger.Debugf("Found %d entries", len(entries))
+
+	logger.Debug("Process complete")
+
+	return entries, nil
+}
+
+func ReadAll(oratabPath string) ([]Entry, error) {
+	// Files earlier in the path take precedence when a SID is in more than one
+
+	var entries []Entry
+
+	found := make(map[string]Entry)
+
+	for _, fileName := range strings.Split(oratabPath, setup.PathDelimiter) {
+		if _, err := os.Stat(fileName); err != nil {
+			logger.Tracef("oratab %s not found. Ignoring ...", fileName)
+			continue
+		}
+
+		fileEntries, err := Read(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range fileEntries {
+			if first, duplicate := found[entry.SID]; duplicate {
+				logger.Debugf("%s in %s line %d is overridden by %s line %d", entry.SID, entry.File, entry.Line, first.File, first.Line)
+				continue
+			}
+
+			found[entry.SID] = entry
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func Databases(entries []Entry) []Entry {
+	var databases []Entry
+
+	for _, entry := range entries {
+		if entry.IsDatabase() {
+			databases = append(databases, entry)
+		}
+	}
+
+	return databases
+}
+
+func Lookup(oratabPath string, sid string) (Entry, error) {
+	logger.Debugf("Looking up %s in %s ...", sid, oratabPath)
+
+	entries, err := ReadAll(oratabPath)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	for _, entry := range entries {
+		if entry.SID == sid {
+			logger.Debugf("Found %s in %s line %d", sid, entry.File, entry.Line)
+
+			return entry, nil
+		}
+	}
+
+	return Entry{}, fmt.Errorf("%s is not listed in %s", sid, oratabPath)
+}
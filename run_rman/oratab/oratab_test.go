@@ -0,0 +1,135 @@
+package oratab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/daviesluke/setup"
+)
+
+const testOratab = `# comment
++ASM1:/u01/app/grid:N
+-MGMTDB:/u01/app/grid:N
+ORCL:/u01/app/oracle/product/19c:Y    # production
+
+TEST:/u01/app/oracle/product/12c:n  
+BAD
+REL:app/oracle:Y
+FLAG:/u01/app/oracle/product/19c:X
+ORCL:/u01/app/oracle/product/12c:N
+*:/u01/app/oracle/product/19c:N
+`
+
+func writeFile(t *testing.T, dir string, name string, contents string) string {
+	fileName := filepath.Join(dir, name)
+
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return fileName
+}
+
+func TestParse(t *testing.T) {
+	setup.PathDelimiter = ":"
+
+	entries, problems := Parse(strings.NewReader(testOratab), "oratab")
+
+	expected := []Entry{
+		{SID: "+ASM1", Home: "/u01/app/grid", File: "oratab", Line: 2},
+		{SID: "-MGMTDB", Home: "/u01/app/grid", File: "oratab", Line: 3},
+		{SID: "ORCL", Home: "/u01/app/oracle/product/19c", Start: true, File: "oratab", Line: 4},
+		{SID: "TEST", Home: "/u01/app/oracle/product/12c", File: "oratab", Line: 6},
+		{SID: "*", Home: "/u01/app/oracle/product/19c", File: "oratab", Line: 11},
+	}
+
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	var lines []int
+
+	for _, problem := range problems {
+		lines = append(lines, problem.(*ParseError).Line)
+	}
+
+	if !reflect.DeepEqual(lines, []int{7, 8, 9, 10}) || !strings.Contains(problems[3].Error(), "already listed on line 4") {
+		t.Fatalf("bad: %v", problems)
+	}
+
+	databases := Databases(entries)
+
+	if len(databases) != 2 || databases[0].SID != "ORCL" || databases[1].SID != "TEST" {
+		t.Fatalf("bad: %#v", databases)
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oratab")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	setup.PathDelimiter = ":"
+
+	first := writeFile(t, dir, "oratab", "ORCL:/u01/app/oracle/product/19c:Y\n")
+	second := writeFile(t, dir, "oratab2", "ORCL:/u01/app/oracle/product/12c:Y\nTEST:/u01/app/oracle/product/12c:N\n")
+
+	oratabPath := strings.Join([]string{filepath.Join(dir, "missing"), first, second}, ":")
+
+	entries, err := ReadAll(oratabPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The first file listed takes precedence
+	if len(entries) != 2 || entries[0].Home != "/u01/app/oracle/product/19c" || entries[1].File != second {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	if entry, err := Lookup(oratabPath, "TEST"); err != nil || entry.Line != 2 {
+		t.Fatalf("bad: %#v %v", entry, err)
+	}
+
+	if _, err := Lookup(oratabPath, "DEV"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestOraEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("oraenv is not used on Windows")
+	}
+
+	// Spaces and shell characters in the path are not interpreted
+	dir, err := ioutil.TempDir("", "ora env;$(false)")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oraenv := writeFile(t, dir, "oraenv", `echo "Setting environment"
+case "$ORACLE_SID" in
+ORCL) ORACLE_HOME=/u01/app/oracle/product/19c; export ORACLE_HOME ;;
+esac
+`)
+
+	os.Setenv("ORACLE_HOME", "/old/home")
+	defer os.Unsetenv("ORACLE_HOME")
+
+	environment, err := OraEnv(oraenv, "ORCL")
+	if err != nil || environment["ORACLE_HOME"] != "/u01/app/oracle/product/19c" || environment["ORAENV_ASK"] != "NO" {
+		t.Fatalf("bad: %v %v", environment["ORACLE_HOME"], err)
+	}
+
+	// The home already set is not taken as found
+	if _, err := OraEnv(oraenv, "TEST"); err == nil {
+		t.Fatal("should error")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package preflight
+
+// Local functions
+
+func getFreeSpace(dirName string) (int64, error) {
+	return 0, errNotSupported
+}
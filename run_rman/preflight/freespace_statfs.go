@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package preflight
+
+// Standard imports
+
+import "syscall"
+
+// Local functions
+
+func getFreeSpace(dirName string) (int64, error) {
+	var fsStat syscall.Statfs_t
+
+	if err := syscall.Statfs(dirName, &fsStat); err != nil {
+		return 0, err
+	}
+
+	// Space available to non root users
+
+	return int64(fsStat.Bavail) * int64(fsStat.Bsize), nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package preflight
+
+// Standard imports
+
+import "syscall"
+import "unsafe"
+
+// local Variables
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Local functions
+
+func getFreeSpace(dirName string) (int64, error) {
+	dirNamePtr, err := syscall.UTF16PtrFromString(dirName)
+	if err != nil {
+		return 0, err
+	}
+
+	var freeBytes int64
+
+	// Space available to the user running the backup
+
+	if result, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(dirNamePtr)), uintptr(unsafe.Pointer(&freeBytes)), 0, 0); result == 0 {
+		return 0, err
+	}
+
+	return freeBytes, nil
+}
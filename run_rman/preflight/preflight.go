@@ -0,0 +1,274 @@
+package preflight
+
+// Standard imports
+
+import "bufio"
+import "errors"
+import "fmt"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/oracle"
+
+// local Variables
+
+// checkList holds the checks in the order they are run. PreflightChecks=ALL runs them all
+
+var checkList = []struct {
+	name  string
+	check func() error
+}{
+	{ "SPACE"   , checkSpace    },
+	{ "OPENMODE", checkOpenMode },
+	{ "FRA"     , checkFRA      },
+	{ "ROLE"    , checkRole     },
+	{ "RMANJOB" , checkRMANJob  },
+}
+
+// getFreeSpace returns errNotSupported on platforms it cannot check so the space check is skipped there
+
+var errNotSupported = errors.New("free space checks are not supported on this platform")
+
+// Local functions
+
+func getConfigInt(configName string) int64 {
+	configValue, err := strconv.ParseInt(config.ConfigValues[configName], 10, 64)
+	if err != nil || configValue < 0 {
+		logger.Errorf("%s must be a whole number not %s", configName, config.ConfigValues[configName])
+	}
+
+	return configValue
+}
+
+func formatBytes(byteCount int64) string {
+	units := []string{ "B", "KB", "MB", "GB", "TB", "PB" }
+
+	size := float64(byteCount)
+	unit := 0
+
+	for size >= 1024 && unit < len(units) - 1 {
+		size /= 1024
+		unit++
+	}
+
+	return strconv.FormatFloat(size, 'f', 1, 64) + units[unit]
+}
+
+func getChecks() []string {
+	logger.Debug("Getting pre-flight checks ...")
+
+	var checks []string
+
+	for _, checkName := range strings.FieldsFunc(strings.ToUpper(config.ConfigValues["PreflightChecks"]), func(c rune) bool { return c == ',' || c == ' ' }) {
+		if checkName == "ALL" {
+			checks = nil
+
+			for _, knownCheck := range checkList {
+				checks = append(checks, knownCheck.name)
+			}
+
+			break
+		}
+
+		found := false
+
+		for _, knownCheck := range checkList {
+			found = found || knownCheck.name == checkName
+		}
+
+		if !found {
+			logger.Errorf("Unknown pre-flight check %s in PreflightChecks", checkName)
+		}
+
+		checks = append(checks, checkName)
+	}
+
+	logger.Debugf("Checks set to %v", checks)
+
+	return checks
+}
+
+func lastBackupBytes(historyFileName string, database string, scriptName string) int64 {
+	logger.Debugf("Getting size of last successful backup from %s ...", historyFileName)
+
+	historyFile, err := os.Open(historyFileName)
+	if err != nil {
+		logger.Debugf("Unable to open history file %s", historyFileName)
+		return 0
+	}
+
+	defer historyFile.Close()
+
+	var backupBytes int64
+
+	historyScanner := bufio.NewScanner(historyFile)
+
+	// Lines are date database script seconds status and then the bytes written if known
+
+	for historyScanner.Scan() {
+		historyTokens := strings.Fields(historyScanner.Text())
+
+		if len(historyTokens) < 6 || historyTokens[1] != database || historyTokens[2] != scriptName || historyTokens[4] != "SUCCESS" {
+			continue
+		}
+
+		if lineBytes, err := strconv.ParseInt(historyTokens[5], 10, 64); err == nil {
+			backupBytes = lineBytes
+		}
+	}
+
+	logger.Debugf("Last backup wrote %d bytes", backupBytes)
+
+	return backupBytes
+}
+
+func checkSpace() error {
+	fileFormat := config.ConfigValues["FileFormat"]
+
+	if fileFormat == "" || strings.HasPrefix(fileFormat, "+") {
+		logger.Info("Backups are not written to a file system - skipping space check")
+		return nil
+	}
+
+	backupDir := filepath.Dir(fileFormat)
+
+	estimate := lastBackupBytes(setup.HistFileName, setup.Database, config.RMANScriptBase)
+
+	if estimate == 0 {
+		logger.Info("No successful run with a recorded size in the history - skipping space check")
+		return nil
+	}
+
+	required := estimate + estimate / 100 * getConfigInt("SpaceMarginPct")
+
+	freeSpace, err := getFreeSpace(backupDir)
+	if err == errNotSupported {
+		logger.Warnf("Unable to check free space in %s - %s. Skipping space check", backupDir, err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to get free space for %s - %s", backupDir, err)
+	}
+
+	logger.Infof("%s free in %s. Last backup wrote %s", formatBytes(freeSpace), backupDir, formatBytes(estimate))
+
+	if freeSpace < required {
+		return fmt.Errorf("only %s free in %s but %s is needed based on the last backup", formatBytes(freeSpace), backupDir, formatBytes(required))
+	}
+
+	return nil
+}
+
+func checkOpenMode() error {
+	if oracle.Target.Status == "STARTED" {
+		return fmt.Errorf("instance %s is not mounted", oracle.Target.InstanceName)
+	}
+
+	if oracle.Target.LogMode == "NOARCHIVELOG" && oracle.Target.OpenMode != "MOUNTED" {
+		return fmt.Errorf("database is open %s in NOARCHIVELOG mode so cannot be backed up online", oracle.Target.OpenMode)
+	}
+
+	return nil
+}
+
+func checkFRA() error {
+	recoveryArea, configured, err := oracle.GetRecoveryArea()
+	if err != nil {
+		return fmt.Errorf("unable to get recovery area usage - %s", err)
+	}
+
+	if !configured {
+		logger.Info("No recovery area configured - skipping recovery area check")
+		return nil
+	}
+
+	usedPct := recoveryArea.UsedPct()
+
+	logger.Infof("Recovery area is %.1f%% used of %s", usedPct, formatBytes(recoveryArea.Limit))
+
+	if usedPct > float64(getConfigInt("FRAMaxPct")) {
+		return fmt.Errorf("recovery area is %.1f%% used which is over FRAMaxPct %s%%", usedPct, config.ConfigValues["FRAMaxPct"])
+	}
+
+	return nil
+}
+
+func checkRole() error {
+	runOnRole := config.ConfigValues["RunOnRole"]
+
+	matches, err := oracle.RoleMatches(runOnRole, oracle.Target.DatabaseRole)
+	if err != nil {
+		return fmt.Errorf("invalid RunOnRole - %s", err)
+	}
+
+	if !matches {
+		return fmt.Errorf("database role is %s and this script only runs on %s", oracle.Target.DatabaseRole, runOnRole)
+	}
+
+	return nil
+}
+
+func checkRMANJob() error {
+	jobCount, err := oracle.GetRunningBackupJobs()
+	if err != nil {
+		return fmt.Errorf("unable to check for running RMAN jobs - %s", err)
+	}
+
+	if jobCount > 0 {
+		return fmt.Errorf("%d RMAN jobs are already running against the database", jobCount)
+	}
+
+	return nil
+}
+
+// Global functions
+
+func IsEnabled(checkName string) bool {
+	for _, enabledCheck := range getChecks() {
+		if enabledCheck == checkName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func RunChecks() []string {
+	logger.Info("Running pre-flight checks ...")
+
+	var failures []string
+
+	checks := getChecks()
+
+	if len(checks) == 0 {
+		logger.Info("No pre-flight checks configured")
+	}
+
+	for _, checkName := range checks {
+		for _, knownCheck := range checkList {
+			if knownCheck.name != checkName {
+				continue
+			}
+
+			logger.Infof("Running %s check ...", checkName)
+
+			if err := knownCheck.check(); err != nil {
+				logger.Warnf("Pre-flight check %s failed - %s", checkName, err)
+
+				failures = append(failures, checkName + ": " + err.Error())
+			} else {
+				logger.Infof("Pre-flight check %s passed", checkName)
+			}
+		}
+	}
+
+	logger.Info("Process complete")
+
+	return failures
+}
@@ -0,0 +1,185 @@
+package preflight
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/run_rman/oracle"
+	"github.com/daviesluke/setup"
+)
+
+const testHistory = `2020/01/01:01:00:00 ORCL backup 100 SUCCESS
+2020/01/02:01:00:00 ORCL backup 100 SUCCESS 1000
+2020/01/02:02:00:00 TEST backup 100 SUCCESS 99999
+2020/01/02:03:00:00 ORCL archive 100 SUCCESS 99999
+2020/01/03:01:00:00 ORCL backup 100 SUCCESS 2000
+2020/01/04:01:00:00 ORCL backup 100 FAILURE 99999
+`
+
+func TestGetChecks(t *testing.T) {
+	defer func() { config.ConfigValues["PreflightChecks"] = "" }()
+
+	config.ConfigValues["PreflightChecks"] = ""
+
+	if checks := getChecks(); len(checks) != 0 {
+		t.Fatalf("bad: %v", checks)
+	}
+
+	config.ConfigValues["PreflightChecks"] = "space, fra"
+
+	if checks := getChecks(); !reflect.DeepEqual(checks, []string{"SPACE", "FRA"}) {
+		t.Fatalf("bad: %v", checks)
+	}
+
+	config.ConfigValues["PreflightChecks"] = "ALL"
+
+	if checks := getChecks(); !reflect.DeepEqual(checks, []string{"SPACE", "OPENMODE", "FRA", "ROLE", "RMANJOB"}) {
+		t.Fatalf("bad: %v", checks)
+	}
+}
+
+func TestLastBackupBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	historyFileName := filepath.Join(dir, "run_rman.hist")
+
+	if err := ioutil.WriteFile(historyFileName, []byte(testHistory), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if backupBytes := lastBackupBytes(historyFileName, "ORCL", "backup"); backupBytes != 2000 {
+		t.Fatalf("bad: %d", backupBytes)
+	}
+
+	if backupBytes := lastBackupBytes(historyFileName, "ORCL", "missing"); backupBytes != 0 {
+		t.Fatalf("bad: %d", backupBytes)
+	}
+
+	if backupBytes := lastBackupBytes(filepath.Join(dir, "missing"), "ORCL", "backup"); backupBytes != 0 {
+		t.Fatalf("bad: %d", backupBytes)
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" && runtime.GOOS != "windows" {
+		t.Skip("free space not supported")
+	}
+
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	setup.HistFileName = filepath.Join(dir, "run_rman.hist")
+	setup.Database = "ORCL"
+	config.RMANScriptBase = "backup"
+	config.ConfigValues["FileFormat"] = filepath.Join(dir, "%d_%U")
+	defer func() { config.ConfigValues["FileFormat"] = "" }()
+
+	// No history so nothing to compare against
+	if err := checkSpace(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ioutil.WriteFile(setup.HistFileName, []byte(testHistory), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := checkSpace(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A last backup bigger than any file system
+	history := testHistory + "2020/01/05:01:00:00 ORCL backup 100 SUCCESS 9000000000000000000\n"
+
+	if err := ioutil.WriteFile(setup.HistFileName, []byte(history), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config.ConfigValues["SpaceMarginPct"] = "0"
+	defer func() { config.ConfigValues["SpaceMarginPct"] = "10" }()
+
+	if err := checkSpace(); err == nil || !strings.Contains(err.Error(), "free in") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// ASM destinations are skipped
+	config.ConfigValues["FileFormat"] = "+FRA"
+
+	if err := checkSpace(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	defer func() {
+		oracle.Target = oracle.DatabaseStatus{}
+		config.ConfigValues["RunOnRole"] = ""
+	}()
+
+	oracle.Target = oracle.DatabaseStatus{InstanceName: "ORCL", Status: "OPEN", DatabaseRole: "PRIMARY", OpenMode: "READ WRITE", LogMode: "ARCHIVELOG"}
+
+	if err := checkOpenMode(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := checkRole(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	oracle.Target = oracle.DatabaseStatus{InstanceName: "ORCL", Status: "OPEN", DatabaseRole: "PHYSICAL STANDBY", OpenMode: "MOUNTED", LogMode: "NOARCHIVELOG"}
+
+	if err := checkOpenMode(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The role is checked against RunOnRole
+	config.ConfigValues["RunOnRole"] = "ANY"
+
+	if err := checkRole(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config.ConfigValues["RunOnRole"] = "PRIMARY"
+
+	if err := checkRole(); err == nil {
+		t.Fatal("should error")
+	}
+
+	config.ConfigValues["RunOnRole"] = "PRIMARY,PHYSICAL STANDBY"
+
+	if err := checkRole(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	oracle.Target.OpenMode = "READ WRITE"
+
+	if err := checkOpenMode(); err == nil {
+		t.Fatal("should error")
+	}
+
+	oracle.Target = oracle.DatabaseStatus{InstanceName: "ORCL", Status: "STARTED", OpenMode: "NOT MOUNTED"}
+
+	if err := checkOpenMode(); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestUsedPct(t *testing.T) {
+	recoveryArea := oracle.RecoveryArea{Limit: 1000, Used: 950, Reclaimable: 200}
+
+	if usedPct := recoveryArea.UsedPct(); usedPct != 75 {
+		t.Fatalf("bad: %f", usedPct)
+	}
+}
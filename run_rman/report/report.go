@@ -0,0 +1,518 @@
+package report
+
+// Standard imports
+
+import "database/sql"
+import "encoding/csv"
+import "encoding/json"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+import "text/tabwriter"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/oracle"
+
+// Global Variables
+
+// Report is a titled table of results
+
+type Report struct {
+	Name    string     `json:"name"`
+	Title   string     `json:"title"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+// Formats lists the output formats Write understands
+
+var Formats = []string{ "text", "csv", "json" }
+
+// local Variables
+
+const timeFormat = "YYYY-MM-DD HH24:MI:SS"
+const goTimeFormat = "2006-01-02 15:04:05"
+
+// source is where the backup records are read from. The catalog holds the same views
+// as the control file with an RC_ prefix and a DB_KEY for each registered database
+
+type source struct {
+	db      *sql.DB
+	catalog bool
+	dbKey   int64
+	days    int
+	now     time.Time
+}
+
+var reportList = []struct {
+	name     string
+	generate func(source) (Report, error)
+}{
+	{ "last"     , lastBackups     },
+	{ "trend"    , backupTrend     },
+	{ "datafiles", needBackup      },
+	{ "gaps"     , archivelogGaps  },
+	{ "window"   , recoveryWindow  },
+}
+
+var windowRegEx     = regexp.MustCompile(`RECOVERY WINDOW OF ([0-9]+) DAYS`)
+var redundancyRegEx = regexp.MustCompile(`REDUNDANCY ([0-9]+)`)
+
+// Local functions
+
+func (s source) view(viewName string) string {
+	if s.catalog {
+		return "rc_" + viewName
+	}
+
+	return "v$" + viewName
+}
+
+func (s source) filter(alias string) string {
+	// Restricts catalog views to the target database
+
+	if !s.catalog {
+		return "1 = 1"
+	}
+
+	if alias != "" {
+		alias = alias + "."
+	}
+
+	return fmt.Sprintf("%sdb_key = %d", alias, s.dbKey)
+}
+
+func queryRows(db *sql.DB, query string) ([][]string, error) {
+	logger.Debugf("Running query %s ...", query)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var results [][]string
+
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(columns))
+
+		for i, value := range values {
+			row[i] = value.String
+		}
+
+		results = append(results, row)
+	}
+
+	logger.Debugf("Returned %d rows", len(results))
+
+	return results, rows.Err()
+}
+
+func lastBackups(s source) (Report, error) {
+	report := Report{ Name: "last", Title: "Last successful backup by type", Columns: []string{ "TYPE", "COMPLETED", "AGE DAYS" } }
+
+	rows, err := queryRows(s.db, fmt.Sprintf("select input_type, to_char(max(end_time), '%s') from %s where status = 'COMPLETED' and %s group by input_type order by input_type", timeFormat, s.view("rman_backup_job_details"), s.filter("")))
+	if err != nil {
+		return report, err
+	}
+
+	for _, row := range rows {
+		age := ""
+
+		if endTime, err := time.ParseInLocation(goTimeFormat, row[1], time.Local); err == nil {
+			age = strconv.FormatFloat(s.now.Sub(endTime).Hours() / 24, 'f', 1, 64)
+		}
+
+		report.Rows = append(report.Rows, []string{ row[0], row[1], age })
+	}
+
+	return report, nil
+}
+
+func backupTrend(s source) (Report, error) {
+	report := Report{ Name: "trend", Title: fmt.Sprintf("Backup size and throughput over the last %d days", s.days), Columns: []string{ "STARTED", "TYPE", "STATUS", "INPUT MB", "OUTPUT MB", "SECONDS", "MB/SEC" } }
+
+	rows, err := queryRows(s.db, fmt.Sprintf("select to_char(start_time, '%s'), input_type, status, input_bytes, output_bytes, elapsed_seconds from %s where start_time > sysdate - %d and %s order by start_time", timeFormat, s.view("rman_backup_job_details"), s.days, s.filter("")))
+	if err != nil {
+		return report, err
+	}
+
+	megabytes := func(byteString string) string {
+		byteCount, err := strconv.ParseFloat(byteString, 64)
+		if err != nil {
+			return byteString
+		}
+
+		return strconv.FormatFloat(byteCount / 1048576, 'f', 1, 64)
+	}
+
+	for _, row := range rows {
+		throughput := ""
+
+		outputBytes, outputErr := strconv.ParseFloat(row[4], 64)
+		seconds, secondsErr     := strconv.ParseFloat(row[5], 64)
+
+		if outputErr == nil && secondsErr == nil && seconds > 0 {
+			throughput = strconv.FormatFloat(outputBytes / 1048576 / seconds, 'f', 1, 64)
+		}
+
+		report.Rows = append(report.Rows, []string{ row[0], row[1], row[2], megabytes(row[3]), megabytes(row[4]), row[5], throughput })
+	}
+
+	return report, nil
+}
+
+func needBackup(s source) (Report, error) {
+	report := Report{ Name: "datafiles", Title: fmt.Sprintf("Datafiles not backed up in the last %d days", s.days), Columns: []string{ "FILE", "NAME", "LAST BACKUP" } }
+
+	dropFilter := "1 = 1"
+
+	if s.catalog {
+		dropFilter = "d.drop_change# is null"
+	}
+
+	rows, err := queryRows(s.db, fmt.Sprintf("select d.file#, d.name, to_char(max(b.completion_time), '%s') from %s d left outer join %s b on b.file# = d.file# and %s where %s and %s group by d.file#, d.name having max(b.completion_time) is null or max(b.completion_time) < sysdate - %d order by d.file#", timeFormat, s.view("datafile"), s.view("backup_datafile"), s.filter("b"), s.filter("d"), dropFilter, s.days))
+	if err != nil {
+		return report, err
+	}
+
+	for _, row := range rows {
+		if row[2] == "" {
+			row[2] = "NEVER"
+		}
+
+		report.Rows = append(report.Rows, row)
+	}
+
+	return report, nil
+}
+
+func archivelogGaps(s source) (Report, error) {
+	report := Report{ Name: "gaps", Title: fmt.Sprintf("Archivelog sequences neither on disk nor backed up in the last %d days", s.days), Columns: []string{ "THREAD", "RESETLOGS", "FROM SEQUENCE", "TO SEQUENCE" } }
+
+	rows, err := queryRows(s.db, fmt.Sprintf("select distinct thread#, resetlogs_change#, sequence# from (select thread#, resetlogs_change#, sequence# from %s where status = 'A' and first_time > sysdate - %d and %s union select thread#, resetlogs_change#, sequence# from %s where first_time > sysdate - %d and %s) order by 1, 2, 3", s.view("archived_log"), s.days, s.filter(""), s.view("backup_redolog"), s.days, s.filter("")))
+	if err != nil {
+		return report, err
+	}
+
+	report.Rows = findGaps(rows)
+
+	return report, nil
+}
+
+func findGaps(rows [][]string) [][]string {
+	// Rows are thread, resetlogs change and sequence in order
+	// Sequences restart for each thread and incarnation
+
+	var gaps [][]string
+
+	for i := 1; i < len(rows); i++ {
+		if rows[i][0] != rows[i-1][0] || rows[i][1] != rows[i-1][1] {
+			continue
+		}
+
+		previous, previousErr := strconv.ParseInt(rows[i-1][2], 10, 64)
+		current, currentErr   := strconv.ParseInt(rows[i][2], 10, 64)
+
+		if previousErr == nil && currentErr == nil && current > previous + 1 {
+			gaps = append(gaps, []string{ rows[i][0], rows[i][1], strconv.FormatInt(previous + 1, 10), strconv.FormatInt(current - 1, 10) })
+		}
+	}
+
+	return gaps
+}
+
+func recoveryWindow(s source) (Report, error) {
+	report := Report{ Name: "window", Title: "Recoverability against the retention policy", Columns: []string{ "POLICY", "REQUIRED", "ACTUAL", "STATUS" } }
+
+	policyRows, err := queryRows(s.db, fmt.Sprintf("select value from %s where name = 'RETENTION POLICY' and %s", s.view("rman_configuration"), s.filter("")))
+	if err != nil {
+		return report, err
+	}
+
+	// Not being in the configuration means the default
+
+	policy := "TO REDUNDANCY 1"
+
+	if len(policyRows) > 0 {
+		policy = strings.ToUpper(policyRows[0][0])
+	}
+
+	dropFilter := "1 = 1"
+
+	if s.catalog {
+		dropFilter = "drop_change# is null"
+	}
+
+	datafileRows, err := queryRows(s.db, fmt.Sprintf("select file# from %s where %s and %s", s.view("datafile"), s.filter(""), dropFilter))
+	if err != nil {
+		return report, err
+	}
+
+	// Pieces are counted so backups with deleted or expired pieces can be left out
+	// A piece is available if any copy of it is
+
+	backupRows, err := queryRows(s.db, fmt.Sprintf("select d.file#, to_char(d.completion_time, '%s'), count(distinct p.piece#), count(distinct case when p.status = 'A' then p.piece# end) from %s d, %s p where p.set_stamp = d.set_stamp and p.set_count = d.set_count and d.file# > 0 and (d.incremental_level = 0 or d.incremental_level is null) and %s and %s group by d.file#, d.completion_time, d.set_stamp, d.set_count", timeFormat, s.view("backup_datafile"), s.view("backup_piece"), s.filter("d"), s.filter("p")))
+	if err != nil {
+		return report, err
+	}
+
+	windowStart, copies := getRecoverability(datafileRows, backupRows)
+
+	if windowMatch := windowRegEx.FindStringSubmatch(policy); windowMatch != nil {
+		requiredDays, _ := strconv.Atoi(windowMatch[1])
+
+		actual := "NONE"
+		status := "SHORT"
+
+		if !windowStart.IsZero() {
+			actualDays := s.now.Sub(windowStart).Hours() / 24
+
+			actual = strconv.FormatFloat(actualDays, 'f', 1, 64) + " days from " + windowStart.Format(goTimeFormat)
+
+			if actualDays >= float64(requiredDays) {
+				status = "OK"
+			}
+		}
+
+		report.Rows = append(report.Rows, []string{ "RECOVERY WINDOW", windowMatch[1] + " days", actual, status })
+	} else if redundancyMatch := redundancyRegEx.FindStringSubmatch(policy); redundancyMatch != nil {
+		requiredCopies, _ := strconv.Atoi(redundancyMatch[1])
+
+		status := "SHORT"
+
+		if copies >= requiredCopies {
+			status = "OK"
+		}
+
+		report.Rows = append(report.Rows, []string{ "REDUNDANCY", redundancyMatch[1] + " copies", strconv.Itoa(copies) + " copies", status })
+	} else {
+		report.Rows = append(report.Rows, []string{ "NONE", "-", "-", "-" })
+	}
+
+	return report, nil
+}
+
+func getRecoverability(datafileRows [][]string, backupRows [][]string) (time.Time, int) {
+	// The database can be restored to any time after every datafile has a full backup
+	// Redundancy is the fewest full backups held of any datafile
+	// Backup rows hold the number of pieces and of those available
+
+	backupTimes := make(map[string][]time.Time)
+
+	for _, row := range backupRows {
+		if row[2] != row[3] {
+			continue
+		}
+
+		if completionTime, err := time.ParseInLocation(goTimeFormat, row[1], time.Local); err == nil {
+			backupTimes[row[0]] = append(backupTimes[row[0]], completionTime)
+		}
+	}
+
+	var windowStart time.Time
+
+	copies := -1
+
+	for _, row := range datafileRows {
+		fileTimes := backupTimes[row[0]]
+
+		if len(fileTimes) == 0 {
+			return time.Time{}, 0
+		}
+
+		sort.Slice(fileTimes, func(i, j int) bool { return fileTimes[i].Before(fileTimes[j]) })
+
+		if fileTimes[0].After(windowStart) {
+			windowStart = fileTimes[0]
+		}
+
+		if copies < 0 || len(fileTimes) < copies {
+			copies = len(fileTimes)
+		}
+	}
+
+	if copies < 0 {
+		copies = 0
+	}
+
+	return windowStart, copies
+}
+
+func generate(s source, reportNames []string) ([]Report, error) {
+	var reports []Report
+
+	for _, reportName := range reportNames {
+		found := false
+
+		for _, knownReport := range reportList {
+			if knownReport.name != reportName {
+				continue
+			}
+
+			found = true
+
+			logger.Infof("Generating %s report ...", reportName)
+
+			report, err := knownReport.generate(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s report failed - %s", reportName, err)
+			}
+
+			reports = append(reports, report)
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown report %s", reportName)
+		}
+	}
+
+	return reports, nil
+}
+
+func openSource() source {
+	logger.Info("Opening report source ...")
+
+	s := source{ now: time.Now() }
+
+	if s.days, _ = strconv.Atoi(config.ConfigValues["ReportDays"]); s.days <= 0 {
+		logger.Errorf("ReportDays must be a positive number not %s", config.ConfigValues["ReportDays"])
+	}
+
+	s.db = oracle.OpenTarget()
+
+	if config.ConfigValues["CatalogConnection"] == "" {
+		logger.Info("Reporting from the control file")
+		return s
+	}
+
+	// The catalog holds many databases so find the target by its DBID
+
+	var dbid int64
+
+	if err := s.db.QueryRow("select dbid from v$database").Scan(&dbid); err != nil {
+		logger.Errorf("Unable to get the DBID of the target database - %s", err)
+	}
+
+	s.db.Close()
+
+	s.db      = oracle.OpenCatalog()
+	s.catalog = true
+
+	if err := s.db.QueryRow(fmt.Sprintf("select db_key from rc_database where dbid = %d", dbid)).Scan(&s.dbKey); err != nil {
+		logger.Errorf("Database with DBID %d is not registered in the catalog - %s", dbid, err)
+	}
+
+	logger.Infof("Reporting from the catalog for DBID %d", dbid)
+
+	logger.Info("Process complete")
+
+	return s
+}
+
+// Global functions
+
+func Write(reports []Report, format string, output io.Writer) error {
+	switch format {
+	case "json":
+		jsonOutput, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(output, string(jsonOutput))
+
+		return err
+	case "csv":
+		// Each record starts with the report name as the reports have different columns
+
+		csvOutput := csv.NewWriter(output)
+
+		for _, report := range reports {
+			csvOutput.Write(append([]string{ "REPORT" }, report.Columns...))
+
+			for _, row := range report.Rows {
+				csvOutput.Write(append([]string{ report.Name }, row...))
+			}
+		}
+
+		csvOutput.Flush()
+
+		return csvOutput.Error()
+	case "text", "":
+		textOutput := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+
+		for _, report := range reports {
+			fmt.Fprintf(textOutput, "\n%s\n\n", report.Title)
+			fmt.Fprintln(textOutput, strings.Join(report.Columns, "\t"))
+
+			for _, row := range report.Rows {
+				fmt.Fprintln(textOutput, strings.Join(row, "\t"))
+			}
+
+			if len(report.Rows) == 0 {
+				fmt.Fprintln(textOutput, "no rows")
+			}
+		}
+
+		return textOutput.Flush()
+	}
+
+	return fmt.Errorf("unknown format %s - use one of %s", format, strings.Join(Formats, ", "))
+}
+
+func Run(reportNames []string, format string, output io.Writer) {
+	logger.Info("Running reports ...")
+
+	if len(reportNames) == 0 {
+		for _, knownReport := range reportList {
+			reportNames = append(reportNames, knownReport.name)
+		}
+	}
+
+	// Check the format before connecting
+
+	if err := Write(nil, format, ioutil.Discard); err != nil {
+		logger.Errorf("Unable to report - %s", err)
+	}
+
+	s := openSource()
+
+	defer s.db.Close()
+
+	reports, err := generate(s, reportNames)
+	if err != nil {
+		logger.Errorf("Unable to report - %s", err)
+	}
+
+	if err := Write(reports, format, output); err != nil {
+		logger.Errorf("Unable to write reports - %s", err)
+	}
+
+	logger.Info("Process complete")
+}
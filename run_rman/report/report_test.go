@@ -0,0 +1,207 @@
+package report
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/internal/testdb"
+)
+
+func init() {
+	sql.Register("reporttest", &testdb.Driver{Query: cannedQuery})
+}
+
+// cannedRows is keyed by the last view in the query and shared by the control file and catalog
+var cannedRows = map[string][][]driver.Value{
+	"rman_backup_job_details where status = 'COMPLETED'": {
+		{"ARCHIVELOG", "2020-01-10 06:00:00"},
+		{"DB INCR", "2020-01-09 00:00:00"},
+	},
+	"rman_backup_job_details where start_time": {
+		{"2020-01-09 00:00:00", "DB INCR", "COMPLETED", int64(2097152000), int64(1048576000), int64(100)},
+		{"2020-01-10 06:00:00", "ARCHIVELOG", "FAILED", int64(0), nil, int64(0)},
+	},
+	"backup_datafile b": {
+		{int64(3), "/u01/oradata/ORCL/undo01.dbf", nil},
+	},
+	"archived_log": {
+		{int64(1), int64(100), int64(10)},
+		{int64(1), int64(100), int64(11)},
+		{int64(1), int64(100), int64(14)},
+		{int64(1), int64(200), int64(1)},
+		{int64(2), int64(200), int64(5)},
+		{int64(2), int64(200), int64(7)},
+	},
+	"rman_configuration": {
+		{"TO RECOVERY WINDOW OF 7 DAYS"},
+	},
+	"file# from": {
+		{int64(1)},
+		{int64(2)},
+	},
+	"backup_piece p": {
+		{int64(1), "2020-01-01 00:00:00", int64(1), int64(1)},
+		{int64(1), "2020-01-08 00:00:00", int64(1), int64(1)},
+		{int64(2), "2020-01-01 12:00:00", int64(2), int64(1)},
+		{int64(2), "2020-01-02 00:00:00", int64(1), int64(1)},
+		{int64(2), "2020-01-09 00:00:00", int64(1), int64(1)},
+	},
+}
+
+// queries records every query run so the views used can be checked
+var queries []string
+
+// cannedQuery answers queries with canned rows chosen by the view queried
+func cannedQuery(query string, args []driver.Value) ([][]driver.Value, error) {
+	queries = append(queries, query)
+
+	for key, rows := range cannedRows {
+		if strings.Contains(query, key) {
+			return rows, nil
+		}
+	}
+
+	return nil, errors.New("ORA-00942: table or view does not exist")
+}
+
+func testSource(t *testing.T, catalog bool) source {
+	db, err := sql.Open("reporttest", "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	queries = nil
+
+	now, _ := time.ParseInLocation(goTimeFormat, "2020-01-11 00:00:00", time.Local)
+
+	return source{db: db, catalog: catalog, dbKey: 42, days: 7, now: now}
+}
+
+func TestGenerate(t *testing.T) {
+	s := testSource(t, false)
+	defer s.db.Close()
+
+	reports, err := generate(s, []string{"last", "trend", "datafiles", "gaps", "window"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := [][][]string{
+		{{"ARCHIVELOG", "2020-01-10 06:00:00", "0.8"}, {"DB INCR", "2020-01-09 00:00:00", "2.0"}},
+		{{"2020-01-09 00:00:00", "DB INCR", "COMPLETED", "2000.0", "1000.0", "100", "10.0"}, {"2020-01-10 06:00:00", "ARCHIVELOG", "FAILED", "0.0", "", "0", ""}},
+		{{"3", "/u01/oradata/ORCL/undo01.dbf", "NEVER"}},
+		{{"1", "100", "12", "13"}, {"2", "200", "6", "6"}},
+		{{"RECOVERY WINDOW", "7 days", "9.0 days from 2020-01-02 00:00:00", "OK"}},
+	}
+
+	for i, report := range reports {
+		if !reflect.DeepEqual(report.Rows, expected[i]) {
+			t.Fatalf("bad %s: %#v", report.Name, report.Rows)
+		}
+	}
+
+	for _, query := range queries {
+		if strings.Contains(query, "rc_") || strings.Contains(query, "db_key") {
+			t.Fatalf("control file query uses the catalog: %s", query)
+		}
+	}
+
+	if _, err := generate(s, []string{"missing"}); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestGenerateCatalog(t *testing.T) {
+	s := testSource(t, true)
+	defer s.db.Close()
+
+	if _, err := generate(s, []string{"last", "datafiles", "window"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, query := range queries {
+		if strings.Contains(query, "v$") || !strings.Contains(query, "db_key = 42") {
+			t.Fatalf("catalog query not restricted to the database: %s", query)
+		}
+	}
+}
+
+func TestRecoverability(t *testing.T) {
+	datafiles := [][]string{{"1"}, {"2"}}
+
+	windowStart, copies := getRecoverability(datafiles, [][]string{{"1", "2020-01-01 00:00:00", "1", "1"}, {"1", "2020-01-05 00:00:00", "1", "1"}, {"2", "2020-01-03 00:00:00", "1", "1"}})
+
+	if windowStart.Format(goTimeFormat) != "2020-01-03 00:00:00" || copies != 1 {
+		t.Fatalf("bad: %s %d", windowStart, copies)
+	}
+
+	// A datafile with no backup cannot be restored
+	if windowStart, copies := getRecoverability(datafiles, [][]string{{"1", "2020-01-01 00:00:00", "1", "1"}}); !windowStart.IsZero() || copies != 0 {
+		t.Fatalf("bad: %s %d", windowStart, copies)
+	}
+
+	// Nor can one whose only backup has a deleted piece
+	if windowStart, copies := getRecoverability(datafiles, [][]string{{"1", "2020-01-01 00:00:00", "1", "1"}, {"2", "2020-01-02 00:00:00", "2", "1"}}); !windowStart.IsZero() || copies != 0 {
+		t.Fatalf("bad: %s %d", windowStart, copies)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	reports := []Report{
+		{Name: "last", Title: "Last", Columns: []string{"TYPE", "COMPLETED"}, Rows: [][]string{{"DB FULL", "2020-01-01 00:00:00"}}},
+		{Name: "gaps", Title: "Gaps", Columns: []string{"THREAD"}},
+	}
+
+	var output bytes.Buffer
+
+	if err := Write(reports, "text", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !strings.Contains(output.String(), "DB FULL  2020-01-01 00:00:00") || !strings.Contains(output.String(), "no rows") {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	output.Reset()
+
+	if err := Write(reports, "csv", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Each report has its own columns
+	csvInput := csv.NewReader(&output)
+	csvInput.FieldsPerRecord = -1
+
+	records, err := csvInput.ReadAll()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(records[1], []string{"last", "DB FULL", "2020-01-01 00:00:00"}) || len(records) != 3 {
+		t.Fatalf("bad: %#v", records)
+	}
+
+	output.Reset()
+
+	if err := Write(reports, "json", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var decoded []Report
+
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded[0], reports[0]) {
+		t.Fatalf("bad: %#v %v", decoded, err)
+	}
+
+	if err := Write(reports, "xml", &output); err == nil {
+		t.Fatal("should error")
+	}
+}
@@ -4,24 +4,196 @@ package resource
 
 import "bufio"
 import "os"
+import "sort"
 import "strconv"
 import "strings"
 import "time"
 
 // Local imports
 
-import "github.com/daviesluke/filelock"
 import "github.com/daviesluke/logger"
 import "github.com/daviesluke/setup"
 import "github.com/daviesluke/utils"
 import "github.com/daviesluke/run_rman/config"
-//import "github.com/daviesluke/mitchellh/go-ps"
+import "github.com/daviesluke/run_rman/coordinator"
+import "github.com/daviesluke/run_rman/metrics"
 
 
 // local Variables
 
+// Obtained resource entries start with a line identifying the owning process
+
+const ownerPrefix = "#"
+
+// Implicit resources requested by every run when a limit is set for them in the resources file
+// Host slots are counted per host as HOST_SLOTS:<host> so a shared coordinator does not pool them across hosts
+// Host limits are looked up by host first then fall back to the HOST_SLOTS entry
+// Database limits are looked up by SID first then fall back to the DB:* entry
+
+const hostSlotsResource  = "HOST_SLOTS"
+const databasePrefix     = "DB:"
+const allDatabasesSuffix = "*"
+
 // Local functions
 
+func splitResourceEntry(resourceEntry string) (string, string, bool) {
+	// Resource names may contain a colon (DB:<sid>) so the value is after the last one
+
+	separator := strings.LastIndex(resourceEntry, ":")
+
+	if separator <= 0 {
+		return "", "", false
+	}
+
+	return resourceEntry[:separator], resourceEntry[separator+1:], true
+}
+
+func getHostSlotsResource() string {
+	return strings.Join( []string{ hostSlotsResource, setup.HostName }, ":")
+}
+
+func getDefaultResource(resourceName string) string {
+	// The entry whose limit applies when a resource has no entry of its own
+
+	if strings.HasPrefix(resourceName, hostSlotsResource + ":") {
+		return hostSlotsResource
+	}
+
+	if strings.HasPrefix(resourceName, databasePrefix) {
+		return databasePrefix + allDatabasesSuffix
+	}
+
+	return ""
+}
+
+func getResourceRank(resourceName string) int {
+	// Implicit resources are always taken first
+
+	if strings.HasPrefix(resourceName, hostSlotsResource) {
+		return 0
+	}
+
+	if strings.HasPrefix(resourceName, databasePrefix) {
+		return 1
+	}
+
+	return 2
+}
+
+func sortResources(resources map[string]int) []string {
+	logger.Debug("Sorting resources ...")
+
+	// Every run takes resources in the same order so two runs cannot each hold one the other is waiting for
+
+	var resourceNames []string
+
+	for resourceName := range resources {
+		resourceNames = append(resourceNames, resourceName)
+	}
+
+	sort.Slice(resourceNames, func(i, j int) bool {
+		iRank := getResourceRank(resourceNames[i])
+		jRank := getResourceRank(resourceNames[j])
+
+		if iRank != jRank {
+			return iRank < jRank
+		}
+
+		return resourceNames[i] < resourceNames[j]
+	})
+
+	logger.Debugf("Returning %s", strings.Join(resourceNames, ", "))
+
+	return resourceNames
+}
+
+func getResourceLimit(resourceName string) string {
+	logger.Debugf("Getting limit for resource %s ...", resourceName)
+
+	resourceLimit := ""
+	defaultLimit  := ""
+
+	resourceFile, err := os.Open(setup.ResourceFileName)
+	if err != nil {
+		logger.Debugf("Unable to open resource file %s", setup.ResourceFileName)
+		return resourceLimit
+	}
+
+	defer resourceFile.Close()
+
+	resourceScanner := bufio.NewScanner(resourceFile)
+
+	for resourceScanner.Scan() {
+		resourceLine := strings.TrimSpace(resourceScanner.Text())
+
+		// Ignore blank lines and comments
+
+		if resourceLine == "" || resourceLine[0] == '#' {
+			continue
+		}
+
+		lineName, lineValue, ok := splitResourceEntry(resourceLine)
+
+		if !ok {
+			logger.Warnf("Invalid line %s in resource file %s.  Ignoring ...", resourceLine, setup.ResourceFileName)
+			continue
+		}
+
+		if lineName == resourceName && resourceLimit == "" {
+			resourceLimit = lineValue
+		} else if lineName == getDefaultResource(resourceName) && defaultLimit == "" {
+			defaultLimit = lineValue
+		}
+	}
+
+	if resourceLimit == "" && defaultLimit != "" {
+		logger.Debugf("No limit for %s. Using default limit", resourceName)
+		resourceLimit = defaultLimit
+	}
+
+	logger.Debugf("Returning %s", resourceLimit)
+
+	return resourceLimit
+}
+
+func addImplicitResources(resources map[string]int) {
+	logger.Info("Adding implicit resources ...")
+
+	implicitNames := []string{ getHostSlotsResource() }
+
+	// Host slots asked for on the command line are also counted against this host
+
+	if resourceValue, requested := resources[hostSlotsResource]; requested {
+		logger.Infof("Counting requested %s against %s", hostSlotsResource, getHostSlotsResource())
+
+		delete(resources, hostSlotsResource)
+
+		resources[getHostSlotsResource()] += resourceValue
+	}
+
+	if setup.Database != "" {
+		implicitNames = append(implicitNames, databasePrefix + setup.Database)
+	}
+
+	for _, resourceName := range implicitNames {
+		if _, requested := resources[resourceName]; requested {
+			logger.Infof("Resource %s explicitly requested. Not adding ...", resourceName)
+			continue
+		}
+
+		if getResourceLimit(resourceName) == "" {
+			logger.Debugf("No limit set for %s. Not adding ...", resourceName)
+			continue
+		}
+
+		logger.Infof("Adding implicit resource %s with 1 unit", resourceName)
+
+		resources[resourceName] = 1
+	}
+
+	logger.Debug("Process complete")
+}
+
 func getResource ( resourceName string, resourceValue int, timeOutMins int) {
 	logger.Infof("Resource Name  : %s", resourceName)
 	logger.Infof("Resource Value : %d", resourceValue)
@@ -39,7 +211,7 @@ func getResource ( resourceName string, resourceValue int, timeOutMins int) {
 		logger.Debugf("File %s exists", setup.ResourceFileName)
 	}
 
-	maxResource :=  utils.LookupFile(setup.ResourceFileName, resourceName, 1, 2, ":", 1)
+	maxResource := getResourceLimit(resourceName)
 	logger.Debugf("Maximum for %s is %s", resourceName, maxResource)
 
 	if maxResource == "" {
@@ -63,45 +235,33 @@ func getResource ( resourceName string, resourceValue int, timeOutMins int) {
 		logger.Debugf("Remaining resource - %d", remainingResource)
 		logger.Debugf("Allocated resource - %d", allocatedResource)
 
-		// Check to see of any resources are currently being used 
-
-		iusedResource  = 0
-
-		// Check if used file exists and clean it 
-
-		if _, err = os.Stat(setup.ResourceUsageFileName); err == nil {
-			// Clean up resource file just in case there are old entries
+		// Clean up resource usage just in case there are old entries
 
-			cleanResources()
-		}
+		cleanResources()
 
-		// Lock the usage file to prevent anyone else using the file
+		// Lock the usage entries to prevent anyone else using them
 
-		filelock.LockFile(setup.ResourceUsageFileName,1)
+		coordinator.Current.Lock(setup.ResourceUsageFileName,1)
 	
-		// Check again as after the clean the file may have been removed
-
-		if _, err = os.Stat(setup.ResourceUsageFileName); err == nil {
-		
-			usedResource  := utils.LookupFile(setup.ResourceUsageFileName, resourceName, 1, 2, ":", 1)
+		// Check to see of any resources are currently being used 
 
-			// Loop through used file looking for usage 
+		iusedResource  = 0
 
-			for usedCounter := 2; usedResource != ""; usedCounter++ {
-				logger.Debugf("Found %s units used", usedResource)
-				if usedAmount, err := strconv.Atoi(usedResource); err != nil {
-					filelock.UnlockFile(setup.ResourceUsageFileName)
-					logger.Errorf("Resource %s not configured properly in %s with value %s", resourceName, setup.ResourceUsageFileName, usedResource)
-				} else {
-					iusedResource+=usedAmount
-					logger.Debugf("Cumulative units used - %d", iusedResource)
-				}
+		for _, usedEntry := range coordinator.Current.ReadEntries(setup.ResourceUsageFileName) {
+			usedName, usedValue, ok := splitResourceEntry(usedEntry)
 
-				// Get next one 
+			if !ok || usedName != resourceName {
+				continue
+			}
 
-				logger.Debugf("Looking up %d occurrence ...", usedCounter)
+			logger.Debugf("Found %s units used", usedValue)
 
-				usedResource = utils.LookupFile(setup.ResourceUsageFileName, resourceName, 1, 2, ":", usedCounter)
+			if usedAmount, err := strconv.Atoi(usedValue); err != nil {
+				coordinator.Current.Unlock(setup.ResourceUsageFileName)
+				logger.Errorf("Resource %s not configured properly in %s with value %s", resourceName, setup.ResourceUsageFileName, usedValue)
+			} else {
+				iusedResource+=usedAmount
+				logger.Debugf("Cumulative units used - %d", iusedResource)
 			}
 		}
 
@@ -109,7 +269,7 @@ func getResource ( resourceName string, resourceValue int, timeOutMins int) {
 		logger.Debugf("Amount of free resource is %d", freeResource)
 
 		if freeResource < 0 {
-			filelock.UnlockFile(setup.ResourceUsageFileName)
+			coordinator.Current.Unlock(setup.ResourceUsageFileName)
 			logger.Errorf("Resource calculation got negative resources. Fix resource allocation files. Exiting with error ...")
 		}
 
@@ -136,9 +296,9 @@ func getResource ( resourceName string, resourceValue int, timeOutMins int) {
 			}
 		} 
 
-		// Unlock the usage file
+		// Unlock the usage entries
 
-		filelock.UnlockFile(setup.ResourceUsageFileName)
+		coordinator.Current.Unlock(setup.ResourceUsageFileName)
 
 		logger.Debugf("Remaining resource to be allocated - %d", remainingResource)
 
@@ -160,163 +320,104 @@ func getResource ( resourceName string, resourceValue int, timeOutMins int) {
 func addResource(resourceName string, resourceValue int) {
 	logger.Infof("Recording resource %s used %d units ...", resourceName, resourceValue)
 
-	// File is already locked when reading and adding entries so do not need to lock again
-	// but if there is any error then perform an unlock first 
-
-	// So we can open the file for writing
-
-	// Write string to both files
+	// Usage entries are already locked when reading and adding entries so do not need to lock again
 
 	writeString := strings.Join( []string{ resourceName, ":", strconv.Itoa(resourceValue) }, "")
 
-	// Write to Used file
-
-	if resourceUsageFile , err := os.OpenFile(setup.ResourceUsageFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err == nil {
-		if _, err := resourceUsageFile.WriteString(writeString+"\n"); err != nil {
-			filelock.UnlockFile(setup.ResourceUsageFileName)
-			logger.Errorf("Unable to write to resource file %s", setup.ResourceUsageFileName)
-		} else {
-			logger.Infof("Added entry %s to resource usage file", writeString)
-		}
-
-		resourceUsageFile.Close()
-		logger.Debug("Closed resource usage file")
-	}
+	// Write to both the used and the process obtained entries
 
-	// Write to process used file
+	coordinator.Current.AddEntry(setup.ResourceUsageFileName, writeString)
+	logger.Infof("Added entry %s to resource usage file", writeString)
 
-	if resourceObtainedFile , err := os.OpenFile(setup.ResourceObtainedFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err == nil {
-		if _, err := resourceObtainedFile.WriteString(writeString+"\n"); err != nil {
-			filelock.UnlockFile(setup.ResourceUsageFileName)
-			logger.Errorf("Unable to write to resource file %s", setup.ResourceObtainedFileName)
-		} else {
-			logger.Infof("Added entry %s to resource obtained file", writeString)
-		}
-
-		resourceObtainedFile.Close()
-		logger.Debug("Closed resource usage file")
-	}
+	coordinator.Current.AddEntry(setup.ResourceObtainedFileName, writeString)
+	logger.Infof("Added entry %s to resource obtained file", writeString)
 
 	logger.Debug("Process complete")
 }
 
-func removeUsedResource(resString string) {
-	logger.Infof("Removing resource %s", resString)
+func cleanResources() {
+	logger.Info("Cleaning resources file ...")
 
-	// Create a temporary file 
+	// Find any obtained resources and see if process is still running
 
-	tempFileName := strings.Join( []string { setup.ResourceUsageFileName, ".", setup.CurrentPID }, "")
+	obtainedPrefix := strings.Join( []string{ setup.ResourceFileName, setup.ObtainedResSuffix, "" }, ".")
+	logger.Debugf("Obtained resources prefix set to %s", obtainedPrefix)
 
-	logger.Debugf("Temp file name set to %s", tempFileName)
+	for _, fileName := range coordinator.Current.ListSets(obtainedPrefix) {
+		logger.Infof("Found file %s. Checking it is an obsolete process ...", fileName)
 
-	tempFile , err := os.OpenFile(tempFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		// Getting process entry from the file
 
-	if err != nil {
-		filelock.UnlockFile(setup.ResourceUsageFileName)
-		logger.Errorf("Unable to create temporary file %s", tempFileName)
-	}
-	
-	missedWrite := false
+		processEntry := getResourceOwner(fileName)
+
+		if !utils.SameProcessEntry(processEntry, setup.ProcessEntry) {
 
-	// Open up the used file
+			pid, _, _, _ := utils.SplitProcessEntry(processEntry)
 
-	logger.Debugf("Opening file %s", setup.ResourceUsageFileName)
+			// Check that process is not currently running
+			
+			if pidAlive, pidIsName := coordinator.Current.CheckProcess(processEntry, setup.BaseName); pidAlive {
+				if pidIsName {
+					leaseAge, expired := coordinator.LeaseExpired(processEntry)
 
-	if usedFile, err := os.Open(setup.ResourceUsageFileName); err == nil {
-		// Set up scanner 
+					if !expired {
+						logger.Infof("Live file %s found.  Ignoring ...", fileName)
+						continue
+					}
 
-		usedScanner := bufio.NewScanner(usedFile)
+					coordinator.ReportExpiredLease(fileName, processEntry, leaseAge)
 
-		for usedScanner.Scan() {
-			usedString := usedScanner.Text()
-			logger.Debugf("Found string to write - %s", usedString)
+					if !coordinator.BreakExpiredLeases() {
+						logger.Infof("Live file %s found with expired lease.  Ignoring ...", fileName)
+						continue
+					}
 
-			if missedWrite || usedString != resString {
-				logger.Debug("Writing string to temp file")
-				if _, err := tempFile.WriteString(usedString+"\n"); err != nil {
-					filelock.UnlockFile(setup.ResourceUsageFileName)
-					logger.Errorf("Unable to write file %s", tempFileName)
+					logger.Warnf("Breaking expired lease for PID %d.  Releasing resources ...", pid)
+				} else {
+					logger.Warnf("Found running PID %d but not %s.  Releasing resources ...", pid, setup.BaseName)
 				}
 			} else {
-				logger.Debug("Skipped write")
-				missedWrite = true
+				logger.Warnf("Found old PID %d not currently running. Releasing resources ...", pid)
 			}
+					
+			ReleaseResources(fileName)
+		} else {
+			logger.Debug("File found is from current process. Ignoring ...")
 		}
-	
-		usedFile.Close()
-		logger.Debug("Closed used file")
 	}
 
-	tempFile.Close()
-	logger.Debug("Closed temp file. Renaming temp file to used file")
-
-	if err := os.Rename(tempFileName, setup.ResourceUsageFileName); err != nil {
-		filelock.UnlockFile(setup.ResourceUsageFileName)
-		logger.Errorf("Unable to rename file %s to %s", tempFileName, setup.ResourceUsageFileName)
-	}
-
-	if usageInfo, err := os.Stat(setup.ResourceUsageFileName); err == nil {
-		logger.Debugf("File %s is size %d bytes", setup.ResourceUsageFileName, usageInfo.Size())
-		if usageInfo.Size() == 0 {
-			logger.Info("Usage file is empty. Deleting file ...")
-			if err := os.Remove(setup.ResourceUsageFileName); err != nil {
-				filelock.UnlockFile(setup.ResourceUsageFileName)
-				logger.Errorf("Unable to remove used file %s", setup.ResourceUsageFileName)
-			}
-		}
-	} else {
-		filelock.UnlockFile(setup.ResourceUsageFileName)
-		logger.Errorf("Unable to find file %s", setup.ResourceUsageFileName)
-	}
-	
 	logger.Debug("Process complete")
 }
 
-func cleanResources() {
-	logger.Info("Cleaning resources file ...")
+func getResourceOwner(resFileName string) string {
+	logger.Debugf("Getting owner of resource file %s ...", resFileName)
 
-	// Find any files and see if process is still running
+	// Files written by older versions have no owner line so fall back to the PID in the file name
 
-	// Open config directory ( location of resource files )
+	partString   := strings.Split(resFileName,".")
+	processEntry := partString[len(partString)-1]
 
-	regEx := strings.Join( []string{ "^", setup.BaseName, "\\.", setup.ResourceSuffix, "\\.", setup.ObtainedResSuffix, "\\.[0-9]+$" }, "" )
-	logger.Debugf("Regular expression set to %s", regEx)
+	if resEntries := coordinator.Current.ReadEntries(resFileName); len(resEntries) > 0 && strings.HasPrefix(resEntries[0], ownerPrefix) {
+		processEntry = strings.TrimPrefix(resEntries[0], ownerPrefix)
+	}
 
-	fileList := utils.FindFiles(setup.ConfigDir, regEx, 0) 
+	logger.Debugf("Returning %s", processEntry)
 
-	for _, fileName := range fileList {
-		logger.Infof("Found file %s. Checking it is an obsolete process ...", fileName)
+	return processEntry
+}
 
-		// Getting process ID from file name 
+func setResourceOwner() {
+	logger.Info("Recording owner of obtained resources ...")
 
-		partString := strings.Split(fileName,".")
-		pidString  := partString[len(partString)-1]
+	// The owner entry carries a heartbeat so hung processes can be detected
 
-		if pidString != setup.CurrentPID {
+	coordinator.Current.Lock(setup.ResourceObtainedFileName,1)
 
-			pid , err := strconv.Atoi(pidString)
-			if err != nil {
-				logger.Errorf("Unable to convert %s to a number", pidString)
-			}
+	ownerEntry := coordinator.AddHeartbeat(setup.ResourceObtainedFileName, ownerPrefix+setup.ProcessEntry)
 
-			// Check that process is not currently running
-			
-			if pidAlive, pidIsName := utils.CheckProcess(pid, setup.BaseName); pidAlive {
-				if pidIsName {
-					logger.Infof("Live file %s found.  Ignoring ...", fileName)
-					continue
-				} else {
-					logger.Warnf("Found running PID %d but not %s.  Releasing resources ...", pid, setup.BaseName)
-				}
-			} else {
-				logger.Warnf("Found old PID %d not currently running. Releasing resources ...", pid)
-			}
-					
-			ReleaseResources(fileName)
-		} else {
-			logger.Debug("File found is from current PID. Ignoring ...")
-		}
-	}
+	coordinator.Current.AddEntry(setup.ResourceObtainedFileName, ownerEntry)
+
+	coordinator.Current.Unlock(setup.ResourceObtainedFileName)
 
 	logger.Debug("Process complete")
 }
@@ -332,7 +433,19 @@ func GetResources ( resources map[string]int ) {
 
 	checkResourceMins, _ := strconv.Atoi(config.ConfigValues["CheckResourceMins"])
 
-	for resourceName, resourceValue := range resources {
+	// Implicit resources are added to the map passed so they are released with the rest
+
+	addImplicitResources(resources)
+
+	if len(resources) > 0 {
+		setResourceOwner()
+	}
+
+	metrics.StartWait(metrics.ResourceWait)
+
+	for _, resourceName := range sortResources(resources) {
+		resourceValue := resources[resourceName]
+
 		logger.Infof("Checking resource %s, attempting to allocate %d units ...", resourceName, resourceValue)
 
 		getResource(resourceName, resourceValue, checkResourceMins)
@@ -340,6 +453,8 @@ func GetResources ( resources map[string]int ) {
 		resourceCount++
 	}
 
+	metrics.StopWait(metrics.ResourceWait)
+
 	if resourceCount == 0 {
 		logger.Info("No resources to provision")
 	}
@@ -350,39 +465,39 @@ func GetResources ( resources map[string]int ) {
 func ReleaseResources(resFileName string) {
 	logger.Info("Releasing resources ...")
 
-	// Need to get file lock to affect these files
+	// Stop refreshing our own owner entry before it goes
 
-	// Lock the usage file to prevent anyone else using the file
+	if resFileName == setup.ResourceObtainedFileName {
+		coordinator.RemoveHeartbeat(resFileName)
+	}
 
-	filelock.LockFile(setup.ResourceUsageFileName,1)
+	// Lock the usage entries to prevent anyone else using them
 
-	// Open up the file used for cleaning
+	coordinator.Current.Lock(setup.ResourceUsageFileName,1)
 
-	if resFile, err := os.Open(resFileName); err == nil {
-		// Set up a scanner 
+	resEntries := coordinator.Current.ReadEntries(resFileName)
 
-		resScanner := bufio.NewScanner(resFile)
+	if len(resEntries) == 0 {
+		logger.Warnf("No entries found for %s.  Nothing to do", resFileName)
+	}
 
-		for resScanner.Scan() {
-			resString := resScanner.Text();
+	for _, resString := range resEntries {
+		if !strings.HasPrefix(resString, ownerPrefix) {
+			logger.Infof("Removing resource %s", resString)
 
-			removeUsedResource(resString)
+			coordinator.Current.RemoveEntry(setup.ResourceUsageFileName, resString)
+		} else {
+			logger.Debugf("Skipping owner entry %s", resString)
 		}
 
-		resFile.Close()
-
-		// Now can remove the file 
+		// Now can remove the obtained entry
 
-		if err := os.Remove(resFileName); err != nil {
-			logger.Errorf("Unable to remove file %s", resFileName)
-		}
-	} else {
-		logger.Warnf("Unable to open file %s.  Nothing to do", resFileName)
+		coordinator.Current.RemoveEntry(resFileName, resString)
 	}
 
-	// Unlock the usage file
+	// Unlock the usage entries
 
-	filelock.UnlockFile(setup.ResourceUsageFileName)
+	coordinator.Current.Unlock(setup.ResourceUsageFileName)
 	
 	logger.Info("Process complete")
 }
@@ -0,0 +1,156 @@
+package resource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/coordinator"
+	"github.com/daviesluke/setup"
+)
+
+func testHost(hostName string, runID string) {
+	setup.HostName = hostName
+	setup.RunID = runID
+	setup.ProcessEntry = fmt.Sprintf("%d:1:%s:%s", os.Getpid(), hostName, runID)
+	setup.ResourceObtainedFileName = setup.ResourceFileName + "." + setup.ObtainedResSuffix + "." + runID
+}
+
+func testResources(t *testing.T, lines string) string {
+	dir, err := ioutil.TempDir("", "resource")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	setup.CurrentPID = fmt.Sprintf("%d", os.Getpid())
+	setup.BaseName = "run_rman"
+	setup.ResourceFileName = filepath.Join(dir, "run_rman.resources")
+	setup.ResourceUsageFileName = setup.ResourceFileName + "." + setup.UsedResSuffix
+
+	if err := ioutil.WriteFile(setup.ResourceFileName, []byte(lines), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return dir
+}
+
+func TestGetResourceLimit(t *testing.T) {
+	dir := testResources(t, "# Comment\nTAPE:10\nDB:ORCL:1\nDB:*:2\nHOST_SLOTS:3\nHOST_SLOTS:hostb:5\n")
+	defer os.RemoveAll(dir)
+
+	cases := map[string]string{
+		"TAPE":             "10",
+		"DB:ORCL":          "1",
+		"DB:TEST":          "2",
+		"HOST_SLOTS:hosta": "3",
+		"HOST_SLOTS:hostb": "5",
+		"DISK":             "",
+	}
+
+	for resourceName, want := range cases {
+		if got := getResourceLimit(resourceName); got != want {
+			t.Fatalf("%s: got %q, want %q", resourceName, got, want)
+		}
+	}
+}
+
+func TestAddImplicitResources(t *testing.T) {
+	dir := testResources(t, "TAPE:10\nHOST_SLOTS:3\nDB:*:2\n")
+	defer os.RemoveAll(dir)
+
+	testHost("hosta", "11111111-1111-4111-8111-111111111111")
+
+	cases := []struct {
+		database  string
+		requested map[string]int
+		want      map[string]int
+	}{
+		{"ORCL", map[string]int{"TAPE": 2}, map[string]int{"TAPE": 2, "HOST_SLOTS:hosta": 1, "DB:ORCL": 1}},
+		{"ORCL", map[string]int{"DB:ORCL": 2}, map[string]int{"DB:ORCL": 2, "HOST_SLOTS:hosta": 1}},
+		{"ORCL", map[string]int{"HOST_SLOTS": 2}, map[string]int{"HOST_SLOTS:hosta": 2, "DB:ORCL": 1}},
+		{"", map[string]int{}, map[string]int{"HOST_SLOTS:hosta": 1}},
+	}
+
+	for _, c := range cases {
+		setup.Database = c.database
+
+		addImplicitResources(c.requested)
+
+		if !reflect.DeepEqual(c.requested, c.want) {
+			t.Fatalf("bad: %#v", c.requested)
+		}
+	}
+
+	// Nothing is added without a limit
+	if err := ioutil.WriteFile(setup.ResourceFileName, []byte("TAPE:10\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	resources := map[string]int{}
+
+	if addImplicitResources(resources); len(resources) != 0 {
+		t.Fatalf("bad: %#v", resources)
+	}
+}
+
+func TestSortResources(t *testing.T) {
+	resources := map[string]int{"TAPE": 1, "DISK": 1, "DB:ORCL": 1, "HOST_SLOTS:hosta": 1}
+
+	want := []string{"HOST_SLOTS:hosta", "DB:ORCL", "DISK", "TAPE"}
+
+	for i := 0; i < 10; i++ {
+		if got := sortResources(resources); !reflect.DeepEqual(got, want) {
+			t.Fatalf("bad: %#v", got)
+		}
+	}
+}
+
+func TestHostSlotsPerHost(t *testing.T) {
+	dir := testResources(t, "HOST_SLOTS:1\n")
+	defer os.RemoveAll(dir)
+
+	sharedDir, err := ioutil.TempDir("", "shared")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(sharedDir)
+
+	oldCurrent := coordinator.Current
+	defer func() { coordinator.Current = oldCurrent }()
+
+	b := coordinator.NewSharedBackend(sharedDir, time.Minute)
+	coordinator.Current = b
+
+	setup.Database = ""
+
+	// Both hosts use one shared directory so each sees the other's usage
+	testHost("hosta", "11111111-1111-4111-8111-111111111111")
+	b.Start()
+	defer b.Stop()
+
+	GetResources(map[string]int{})
+
+	testHost("hostb", "22222222-2222-4222-8222-222222222222")
+
+	GetResources(map[string]int{})
+
+	used := b.ReadEntries(setup.ResourceUsageFileName)
+	sort.Strings(used)
+
+	if !reflect.DeepEqual(used, []string{"HOST_SLOTS:hosta:1", "HOST_SLOTS:hostb:1"}) {
+		t.Fatalf("bad: %#v", used)
+	}
+
+	ReleaseResources(setup.ResourceObtainedFileName)
+
+	testHost("hosta", "11111111-1111-4111-8111-111111111111")
+
+	if used := b.ReadEntries(setup.ResourceUsageFileName); !reflect.DeepEqual(used, []string{"HOST_SLOTS:hosta:1"}) {
+		t.Fatalf("bad: %#v", used)
+	}
+}
@@ -0,0 +1,185 @@
+package restoretest
+
+// Standard imports
+
+import "fmt"
+import "io/ioutil"
+import "math/rand"
+import "os"
+import "path/filepath"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/oracle"
+
+// Global Variables
+
+// ScriptBase is the script name restore tests are logged and recorded in the history under
+
+const ScriptBase = "restoretest"
+
+// local Variables
+
+var window oracle.RecoverableWindow
+
+// Local functions
+
+func getScratchFileName(scratchDir string, fileNumber int) string {
+	return filepath.Join(scratchDir, fmt.Sprintf("%s_%s_%d.dbf", ScriptBase, setup.Database, fileNumber))
+}
+
+func sampleDatafiles(datafiles []int, sampleSize int, random *rand.Rand) []int {
+	// A different random sample each run so over time every datafile is proven
+
+	if sampleSize >= len(datafiles) {
+		return datafiles
+	}
+
+	var sample []int
+
+	for _, i := range random.Perm(len(datafiles))[:sampleSize] {
+		sample = append(sample, datafiles[i])
+	}
+
+	sort.Ints(sample)
+
+	return sample
+}
+
+func buildScript(window oracle.RecoverableWindow, sample []int, scratchDir string) string {
+	scriptLines := []string{
+		"run {",
+		"<parallel>",
+		"restore database validate;",
+		fmt.Sprintf("restore archivelog from scn %d validate;", window.FromSCN),
+	}
+
+	// Restoring to a new name without a switch leaves the database using its own files
+
+	for _, fileNumber := range sample {
+		scriptLines = append(scriptLines, fmt.Sprintf("set newname for datafile %d to '%s';", fileNumber, getScratchFileName(scratchDir, fileNumber)))
+	}
+
+	if len(sample) > 0 {
+		sampleList := make([]string, len(sample))
+
+		for i, fileNumber := range sample {
+			sampleList[i] = strconv.Itoa(fileNumber)
+		}
+
+		scriptLines = append(scriptLines, fmt.Sprintf("restore datafile %s;", strings.Join(sampleList, ", ")))
+	}
+
+	scriptLines = append(scriptLines, "}")
+
+	return strings.Join(scriptLines, "\n") + "\n"
+}
+
+func removeScratchFiles(scratchDir string) {
+	logger.Debugf("Removing restored datafiles from %s ...", scratchDir)
+
+	regEx := strings.Join( []string{ "^", ScriptBase, "_", setup.Database, "_[0-9]+\\.dbf$" }, "")
+
+	for _, fileName := range utils.FindFiles(scratchDir, regEx, 0) {
+		if err := os.Remove(fileName); err != nil {
+			logger.Warnf("Unable to remove restored datafile %s", fileName)
+		} else {
+			logger.Debugf("Removed %s", fileName)
+		}
+	}
+
+	logger.Debug("Process complete")
+}
+
+// Global functions
+
+func SetScript() {
+	logger.Debug("Setting the restore test script ...")
+
+	// The script is generated once connected so only the name is set here
+
+	config.RMANScript     = filepath.Join(setup.TmpDir, strings.Join( []string{ ScriptBase, setup.CurrentPID, "rman" }, "."))
+	config.RMANScriptBase = ScriptBase
+
+	logger.Infof("Restore test script set to %s", config.RMANScript)
+
+	logger.Debug("Process complete")
+}
+
+func WriteScript() {
+	logger.Info("Generating restore test script ...")
+
+	var err error
+
+	if window, err = oracle.GetRecoverableWindow(); err != nil {
+		logger.Errorf("Unable to find the recoverable window - %s", err)
+	}
+
+	logger.Infof("Latest backups recover from SCN %d (%s) to SCN %d (%s)", window.FromSCN, window.FromTime, window.ToSCN, window.ToTime)
+
+	var sample []int
+
+	sampleSize, err := strconv.Atoi(config.ConfigValues["RestoreTestDatafiles"])
+	if err != nil || sampleSize < 0 {
+		logger.Errorf("RestoreTestDatafiles must be a whole number not %s", config.ConfigValues["RestoreTestDatafiles"])
+	}
+
+	scratchDir := config.ConfigValues["RestoreTestScratch"]
+
+	if sampleSize > 0 {
+		if scratchDir == "" {
+			logger.Errorf("RestoreTestScratch must be set to restore datafiles")
+		}
+
+		if _, err := os.Stat(scratchDir); err != nil {
+			logger.Errorf("Unable to find restore test scratch directory %s", scratchDir)
+		}
+
+		// Files left by a failed test would stop the restore
+
+		removeScratchFiles(scratchDir)
+
+		datafiles, err := oracle.GetDatafiles()
+		if err != nil {
+			logger.Errorf("Unable to get the datafiles - %s", err)
+		}
+
+		sample = sampleDatafiles(datafiles, sampleSize, rand.New(rand.NewSource(time.Now().UnixNano())))
+
+		logger.Infof("Restoring datafiles %v to %s", sample, scratchDir)
+	}
+
+	if err := ioutil.WriteFile(config.RMANScript, []byte(buildScript(window, sample, scratchDir)), 0600); err != nil {
+		logger.Errorf("Unable to write restore test script %s", config.RMANScript)
+	}
+
+	logger.Info("Process complete")
+}
+
+func Finish() {
+	logger.Info("Finishing restore test ...")
+
+	if config.ConfigValues["RestoreTestScratch"] != "" {
+		removeScratchFiles(config.ConfigValues["RestoreTestScratch"])
+	}
+
+	if err := os.Remove(config.RMANScript); err != nil {
+		logger.Warnf("Unable to remove restore test script %s", config.RMANScript)
+	}
+
+	// Record what the test proved can be recovered
+
+	logger.SetHistoryDetail(fmt.Sprintf("scn=%d-%d time=%s-%s", window.FromSCN, window.ToSCN, window.FromTime, window.ToTime))
+
+	logger.Infof("Restore test proved recovery from SCN %d (%s) to SCN %d (%s)", window.FromSCN, window.FromTime, window.ToSCN, window.ToTime)
+
+	logger.Info("Process complete")
+}
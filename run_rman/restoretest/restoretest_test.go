@@ -0,0 +1,66 @@
+package restoretest
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daviesluke/run_rman/oracle"
+	"github.com/daviesluke/setup"
+)
+
+func TestSampleDatafiles(t *testing.T) {
+	datafiles := []int{1, 2, 3, 4, 5, 6, 7, 8}
+
+	sample := sampleDatafiles(datafiles, 3, rand.New(rand.NewSource(1)))
+
+	if len(sample) != 3 {
+		t.Fatalf("bad: %v", sample)
+	}
+
+	seen := make(map[int]bool)
+
+	for i, fileNumber := range sample {
+		if seen[fileNumber] || fileNumber < 1 || fileNumber > 8 || (i > 0 && sample[i-1] > fileNumber) {
+			t.Fatalf("bad: %v", sample)
+		}
+
+		seen[fileNumber] = true
+	}
+
+	if sample := sampleDatafiles(datafiles, 10, rand.New(rand.NewSource(1))); !reflect.DeepEqual(sample, datafiles) {
+		t.Fatalf("bad: %v", sample)
+	}
+}
+
+func TestBuildScript(t *testing.T) {
+	setup.Database = "ORCL"
+
+	window := oracle.RecoverableWindow{FromSCN: 1000, ToSCN: 2000}
+
+	script := buildScript(window, nil, "")
+
+	expected := "run {\n<parallel>\nrestore database validate;\nrestore archivelog from scn 1000 validate;\n}\n"
+
+	if script != expected {
+		t.Fatalf("bad: %q", script)
+	}
+
+	script = buildScript(window, []int{3, 7}, "/scratch")
+
+	for _, line := range []string{
+		"set newname for datafile 3 to '/scratch/restoretest_ORCL_3.dbf';",
+		"set newname for datafile 7 to '/scratch/restoretest_ORCL_7.dbf';",
+		"restore datafile 3, 7;\n}",
+	} {
+		if !strings.Contains(script, line) {
+			t.Fatalf("missing %q: %s", line, script)
+		}
+	}
+
+	// Never switches the database to the restored files
+	if strings.Contains(script, "switch") {
+		t.Fatalf("bad: %s", script)
+	}
+}
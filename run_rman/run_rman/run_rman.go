@@ -18,6 +18,11 @@ package main
 
 // Standard imports
 
+import "fmt"
+import "os"
+import "strings"
+import "time"
+
 // Local imports
 
 import "github.com/daviesluke/logger"
@@ -25,11 +30,22 @@ import "github.com/daviesluke/setup"
 import "github.com/daviesluke/utils"
 
 import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/coordinator"
+import "github.com/daviesluke/run_rman/duplicate"
+import "github.com/daviesluke/run_rman/fanout"
 import "github.com/daviesluke/run_rman/general"
 import "github.com/daviesluke/run_rman/locker"
+import "github.com/daviesluke/run_rman/metrics"
 import "github.com/daviesluke/run_rman/resource"
 import "github.com/daviesluke/run_rman/oracle"
 import "github.com/daviesluke/run_rman/oracle/rman"
+import "github.com/daviesluke/run_rman/preflight"
+import "github.com/daviesluke/run_rman/report"
+import "github.com/daviesluke/run_rman/restoretest"
+import "github.com/daviesluke/run_rman/schedule"
+import "github.com/daviesluke/run_rman/status"
+import "github.com/daviesluke/run_rman/vault"
+import "github.com/daviesluke/run_rman/watch"
 
 // Local Variables
 
@@ -37,10 +53,217 @@ const (
 	version string = "V2.1.2"
 )
 
+func showStatus() {
+	// Read the config file 
+	config.GetConfig(setup.ConfigFileName)
+
+	// Set any database specific config
+	config.SetAllConfig(setup.Database)
+
+	// Set where locks and resources are recorded
+	coordinator.Initialize(oracle.OpenCatalog)
+
+	// Show the locks and resources held
+	status.ShowStatus()
+
+	// Deregister from the coordination backend
+	coordinator.Shutdown()
+
+	logger.Info("Process complete")
+}
+
+func checkDrift() {
+	// Read the config file 
+	config.GetConfig(setup.ConfigFileName)
+
+	// Check and set the environment
+	general.SetEnvironment(setup.Database)
+
+	// Set where locks and resources are recorded
+	coordinator.Initialize(oracle.OpenCatalog)
+
+	// Compare the RMAN configuration with RMANConfig
+	drifted := rman.CheckDrift(general.ApplyDrift)
+
+	rman.CloseSession()
+
+	// Deregister from the coordination backend
+	coordinator.Shutdown()
+
+	logger.Info("Process complete")
+
+	if drifted {
+		os.Exit(rman.DriftExitCode)
+	}
+}
+
+func recoverConfig() {
+	// Read the config file 
+	config.GetConfig(setup.ConfigFileName)
+
+	// Check and set the environment
+	general.SetEnvironment(setup.Database)
+
+	// Set where locks and resources are recorded
+	coordinator.Initialize(oracle.OpenCatalog)
+
+	// Restore any configuration left behind by failed runs
+	restored := rman.RecoverConfig()
+
+	rman.CloseSession()
+
+	if len(restored) == 0 {
+		fmt.Printf("Nothing to recover for %s\n", setup.Database)
+	}
+
+	for _, baselineFile := range restored {
+		fmt.Printf("Restored RMAN configuration for %s from %s\n", setup.Database, baselineFile)
+	}
+
+	// Deregister from the coordination backend
+	coordinator.Shutdown()
+
+	logger.Info("Process complete")
+}
+
+func runReport() {
+	// Read the config file 
+	config.GetConfig(setup.ConfigFileName)
+
+	// Check and set the environment
+	general.SetEnvironment(setup.Database)
+
+	// Report on the backups held for the database
+	report.Run(general.CommandArgs, general.ReportFormat, os.Stdout)
+
+	logger.Info("Process complete")
+}
+
+func manageVault() {
+	// Read the config file 
+	config.GetConfig(setup.ConfigFileName)
+
+	// Set any database specific config
+	config.SetAllConfig(setup.Database)
+
+	if general.Command != "vault list" && len(general.CommandArgs) != 1 {
+		logger.Errorf("Usage: %s %s <name>", setup.BaseName, general.Command)
+	}
+
+	var err error
+
+	switch general.Command {
+	case "vault add":
+		// The connection is read from stdin to keep it out of the process arguments
+
+		fmt.Fprintf(os.Stderr, "Connection for %s: ", general.CommandArgs[0])
+
+		var connection string
+
+		if connection, err = vault.ReadConnection(os.Stdin); err == nil {
+			err = vault.Add(general.CommandArgs[0], connection)
+		}
+	case "vault list":
+		var names []string
+
+		if names, err = vault.List(); err == nil {
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		}
+	case "vault remove":
+		err = vault.Remove(general.CommandArgs[0])
+	}
+
+	if err != nil {
+		logger.Errorf("Unable to %s - %s", general.Command, err)
+	}
+
+	logger.Info("Process complete")
+}
+
+func runDaemon() {
+	// Read the config file 
+	config.GetConfig(setup.ConfigFileName)
+
+	// Set any database specific config
+	config.SetAllConfig(setup.Database)
+
+	// Set where locks and resources are recorded
+	coordinator.Initialize(oracle.OpenCatalog)
+
+	// Only one daemon may run from the schedule
+	general.SetLock("daemon")
+
+	locker.LockProcess(general.LockName,setup.Database)
+
+	// Start runs as they fall due
+	schedule.Run()
+}
+
+func listSchedule() {
+	// Read the config file 
+	config.GetConfig(setup.ConfigFileName)
+
+	// Set any database specific config
+	config.SetAllConfig(setup.Database)
+
+	// Show the runs and when they are next due
+	schedule.List(os.Stdout)
+
+	logger.Info("Process complete")
+}
+
+func checkRole() bool {
+	// Only run where the database has the role the script is for
+	if oracle.CheckRole() {
+		return true
+	}
+
+	// The ROLE pre-flight check fails the run instead
+	if preflight.IsEnabled("ROLE") {
+		return true
+	}
+
+	// Not a failure so nothing is mailed
+	rman.CloseSession()
+
+	// Perform file removal and deregistration as for a normal finish
+	general.Cleanup()
+
+	logger.WriteHistory("SKIPPED")
+
+	logger.Infof("Skipping %s as database %s is a %s", config.RMANScriptBase, setup.Database, oracle.Target.DatabaseRole)
+
+	return false
+}
+
+func checkPreflight() {
+	// Run the configured checks before anything is locked
+	failures := preflight.RunChecks()
+
+	if len(failures) == 0 {
+		return
+	}
+
+	// Nothing has been locked yet so only the session and registration need tidying
+	rman.CloseSession()
+
+	coordinator.Shutdown()
+
+	logger.Errorf("Pre-flight checks failed - %s", strings.Join(failures, "; "))
+}
+
 func main() {
 	// Grab the start time
 	logger.SetStartTime()
 
+	// Keep passwords out of the logs
+	logger.SetMaskFunction(utils.MaskPasswords)
+
+	// Record the outcome of each run for monitoring
+	logger.SetHistoryFunction(metrics.Write)
+
 	// Initialise some global variables
 	setup.Initialize()
 
@@ -55,8 +278,51 @@ func main() {
 	// Validate the command line parameters
 	general.ValidateFlags()
 
-	// Check the command script provided
-	config.SetRMANScript()
+	// Check for a command other than running a script
+	general.SetCommand()
+
+	switch general.Command {
+	case "status":
+		showStatus()
+		return
+	case "config drift":
+		checkDrift()
+		return
+	case "recover":
+		recoverConfig()
+		return
+	case "vault add", "vault list", "vault remove":
+		manageVault()
+		return
+	case "report":
+		runReport()
+		return
+	case "watch":
+		watch.Run()
+		return
+	case "daemon":
+		runDaemon()
+		return
+	case "schedule list":
+		listSchedule()
+		return
+	}
+
+	// Check the command script provided or generate one to test restores or duplicate
+	switch general.Command {
+	case "restoretest":
+		restoretest.SetScript()
+	case "duplicate":
+		duplicate.SetScript()
+	default:
+		config.SetRMANScript()
+	}
+
+	// Run the script against each database when more than one is given
+	if general.Command == "run" && fanout.IsMulti(setup.Database) {
+		fanout.Run()
+		return
+	}
 
 	// Read the config file 
 	config.GetConfig(setup.ConfigFileName)
@@ -67,24 +333,78 @@ func main() {
 	// Reset logging to reflect the environment
 	general.RenameLog()
 
+	// Set where locks and resources are recorded
+	coordinator.Initialize(oracle.OpenCatalog)
+
+	// Restore any configuration left behind by failed runs
+	rman.RecoverConfig()
+
+	// Check the connections
+	oracle.CheckConnections()
+
+	// Make sure the rman found can be used against the database
+	rman.CheckVersion()
+
+	if general.Command == "duplicate" {
+		duplicate.CheckConnection()
+	}
+
+	// Skip if running on the wrong side of Data Guard
+	if !checkRole() {
+		logger.Info("Process complete")
+		return
+	}
+
+	// Make sure the backup can run
+	checkPreflight()
+
 	// Lock the process if supplied
 	locker.LockProcess(general.LockName,setup.Database)
 
 	// Set any resources supplied
 	resource.GetResources(general.Resources)
 
-	// Check the connections
-	oracle.CheckConnections()
-
 	// Get RMAN config
 	rman.CheckConfig()
 
+	switch general.Command {
+	case "restoretest":
+		restoretest.WriteScript()
+	case "duplicate":
+		duplicate.WriteScript()
+
+		// Run the SQL steps needed on the source first
+		duplicate.RunPreSteps()
+	}
+
 	// Run RMAN command
+	runStart := time.Now()
+
 	rman.RunScript()
 
+	switch general.Command {
+	case "restoretest":
+		// Tidy up and record the window proved
+		restoretest.Finish()
+	case "duplicate":
+		// Tidy up and record the point in time duplicated to
+		duplicate.Finish()
+	default:
+		// Record the size of the backup for later space checks
+		logger.SetHistoryBytes(oracle.GetBackupBytes(runStart))
+	}
+
 	// Reset RMAN config
 	rman.ResetConfig()
 
+	// Finished with rman
+	rman.CloseSession()
+
+	if general.Command == "duplicate" {
+		// Mask data and reset passwords on the new database while still locked
+		duplicate.RunPostSteps()
+	}
+
 	// Perform file removal, lock removal, resources cleanup needed
 	general.Cleanup()
 
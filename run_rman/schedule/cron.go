@@ -0,0 +1,189 @@
+package schedule
+
+// Standard imports
+
+import "fmt"
+import "strconv"
+import "strings"
+import "time"
+
+// local Variables
+
+// cronSpec holds the values each field of a cron expression allows
+
+type cronSpec struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+
+	// Standard cron runs on either day field when both are restricted
+
+	anyDay     bool
+	anyWeekday bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly"   : "0 * * * *",
+	"@daily"    : "0 0 * * *",
+	"@midnight" : "0 0 * * *",
+	"@weekly"   : "0 0 * * 0",
+	"@monthly"  : "0 0 1 * *",
+	"@yearly"   : "0 0 1 1 *",
+	"@annually" : "0 0 1 1 *",
+}
+
+var monthNames   = []string{ "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC" }
+var weekdayNames = []string{ "SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT" }
+
+// Local functions
+
+func parseValue(value string, names []string, nameOffset int) (int, error) {
+	for i, name := range names {
+		if strings.ToUpper(value) == name {
+			return i + nameOffset, nil
+		}
+	}
+
+	return strconv.Atoi(value)
+}
+
+func parseField(field string, min int, max int, names []string) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+
+		if stepParts := strings.SplitN(part, "/", 2); len(stepParts) == 2 {
+			var err error
+
+			if step, err = strconv.Atoi(stepParts[1]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %s", part)
+			}
+
+			part = stepParts[0]
+		}
+
+		low, high := min, max
+
+		if part != "*" {
+			rangeParts := strings.SplitN(part, "-", 2)
+
+			var err error
+
+			if low, err = parseValue(rangeParts[0], names, min); err != nil {
+				return nil, fmt.Errorf("invalid value %s", rangeParts[0])
+			}
+
+			high = low
+
+			if len(rangeParts) == 2 {
+				if high, err = parseValue(rangeParts[1], names, min); err != nil {
+					return nil, fmt.Errorf("invalid value %s", rangeParts[1])
+				}
+			} else if step > 1 {
+				// e.g. 5/15 runs from 5 to the end of the range
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("%s is outside %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func parseCron(expression string) (cronSpec, error) {
+	var spec cronSpec
+
+	if macro, found := cronMacros[strings.ToLower(expression)]; found {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+
+	if len(fields) != 5 {
+		return spec, fmt.Errorf("cron expression %s must have 5 fields", expression)
+	}
+
+	var err error
+
+	if spec.minutes, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return spec, fmt.Errorf("minute - %s", err)
+	}
+
+	if spec.hours, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return spec, fmt.Errorf("hour - %s", err)
+	}
+
+	if spec.days, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return spec, fmt.Errorf("day of month - %s", err)
+	}
+
+	if spec.months, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return spec, fmt.Errorf("month - %s", err)
+	}
+
+	// 7 is also Sunday
+
+	if spec.weekdays, err = parseField(fields[4], 0, 7, weekdayNames); err != nil {
+		return spec, fmt.Errorf("day of week - %s", err)
+	}
+
+	if spec.weekdays[7] {
+		spec.weekdays[0] = true
+	}
+
+	spec.anyDay     = strings.HasPrefix(fields[2], "*")
+	spec.anyWeekday = strings.HasPrefix(fields[4], "*")
+
+	return spec, nil
+}
+
+func (spec cronSpec) dayMatches(t time.Time) bool {
+	dayMatch     := spec.days[t.Day()]
+	weekdayMatch := spec.weekdays[int(t.Weekday())]
+
+	switch {
+	case spec.anyDay && spec.anyWeekday:
+		return true
+	case spec.anyDay:
+		return weekdayMatch
+	case spec.anyWeekday:
+		return dayMatch
+	default:
+		return dayMatch || weekdayMatch
+	}
+}
+
+func (spec cronSpec) next(after time.Time) time.Time {
+	// Moves forward a field at a time until every field matches
+	// Gives up after 5 years e.g. for 30 February
+
+	t     := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !spec.months[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month() + 1, 1, 0, 0, 0, 0, t.Location())
+		case !spec.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day() + 1, 0, 0, 0, 0, t.Location())
+		case !spec.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour() + 1, 0, 0, 0, t.Location())
+		case !spec.minutes[t.Minute()]:
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
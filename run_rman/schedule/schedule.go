@@ -0,0 +1,492 @@
+package schedule
+
+// Standard imports
+
+import "bufio"
+import "flag"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "text/tabwriter"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/general"
+
+// Global Variables
+
+// Entry is a line of the schedule file
+
+type Entry struct {
+	Expression string
+	Database   string
+	Script     string
+	Options    []string
+	Next       time.Time
+	Last       time.Time
+
+	spec       cronSpec
+}
+
+// Clock gives the time so tests can control it
+
+type Clock interface {
+	Now() time.Time
+	Sleep(time.Duration)
+}
+
+// local Variables
+
+type realClock struct{}
+
+var clock Clock = realClock{}
+
+// startRun starts a run in the background and calls finished once it has ended
+
+var startRun = startChild
+
+var entries       []*Entry
+var scheduleTime  time.Time
+var lastRuns      = make(map[string]time.Time)
+
+var running       = make(map[string]bool)
+var runMutex      sync.Mutex
+
+// runOptions are the run_rman options allowed on a schedule line. All of them take a value
+
+var runOptions = map[string]bool{
+	"l": true, "lock": true,
+	"r": true, "resource": true,
+	"e": true, "erroremail": true,
+	"E": true, "email": true,
+}
+
+// Local functions
+
+func (realClock) Now() time.Time               { return time.Now() }
+func (realClock) Sleep(duration time.Duration) { time.Sleep(duration) }
+
+func (entry *Entry) key() string {
+	return strings.Join( []string{ entry.Expression, entry.Database, entry.Script }, " ")
+}
+
+func getScheduleFileName() string {
+	if config.ConfigValues["ScheduleFile"] != "" {
+		return config.ConfigValues["ScheduleFile"]
+	}
+
+	return filepath.Join(setup.ConfigDir, strings.Join( []string{ setup.BaseName, "schedule" }, "."))
+}
+
+func getStateFileName() string {
+	return strings.Join( []string{ getScheduleFileName(), "state" }, ".")
+}
+
+func checkOptions(options []string) error {
+	for i := 0; i < len(options); i++ {
+		option := strings.TrimLeft(options[i], "-")
+
+		if !strings.HasPrefix(options[i], "-") {
+			return fmt.Errorf("unexpected %s", options[i])
+		}
+
+		if strings.Contains(option, "=") {
+			option = strings.SplitN(option, "=", 2)[0]
+		} else {
+			// The value is the next word
+			i++
+		}
+
+		if !runOptions[option] {
+			return fmt.Errorf("option -%s is not allowed", option)
+		}
+
+		if i >= len(options) {
+			return fmt.Errorf("option -%s has no value", option)
+		}
+	}
+
+	return nil
+}
+
+func parseLine(line string) (*Entry, error) {
+	// The schedule is a cron expression or @ macro then the database, script and run_rman options
+
+	fields := strings.Fields(line)
+
+	cronFields := 5
+
+	if strings.HasPrefix(fields[0], "@") {
+		cronFields = 1
+	}
+
+	if len(fields) < cronFields + 2 {
+		return nil, fmt.Errorf("expected schedule, database and script")
+	}
+
+	entry := &Entry{
+		Expression: strings.Join(fields[:cronFields], " "),
+		Database:   fields[cronFields],
+		Script:     fields[cronFields + 1],
+		Options:    fields[cronFields + 2:],
+	}
+
+	var err error
+
+	if entry.spec, err = parseCron(entry.Expression); err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(entry.Script) {
+		entry.Script = filepath.Join(setup.RMANScriptDir, entry.Script)
+	}
+
+	if err := checkOptions(entry.Options); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func readSchedule(input io.Reader) ([]*Entry, error) {
+	var scheduleEntries []*Entry
+
+	scanner := bufio.NewScanner(input)
+
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		entry, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d - %s", lineNo, err)
+		}
+
+		scheduleEntries = append(scheduleEntries, entry)
+	}
+
+	return scheduleEntries, scanner.Err()
+}
+
+func loadSchedule() ([]*Entry, time.Time, error) {
+	scheduleFileName := getScheduleFileName()
+
+	fileInfo, err := os.Stat(scheduleFileName)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	scheduleFile, err := os.Open(scheduleFileName)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	defer scheduleFile.Close()
+
+	scheduleEntries, err := readSchedule(scheduleFile)
+
+	return scheduleEntries, fileInfo.ModTime(), err
+}
+
+func readState() {
+	logger.Debug("Reading schedule state ...")
+
+	// Each line is the time the run was due then the schedule line it was for
+
+	stateFile, err := os.Open(getStateFileName())
+	if err != nil {
+		logger.Debug("No schedule state found")
+		return
+	}
+
+	defer stateFile.Close()
+
+	scanner := bufio.NewScanner(stateFile)
+
+	for scanner.Scan() {
+		stateTokens := strings.SplitN(scanner.Text(), " ", 2)
+
+		if len(stateTokens) != 2 {
+			continue
+		}
+
+		if lastRun, err := time.Parse(time.RFC3339, stateTokens[0]); err == nil {
+			lastRuns[stateTokens[1]] = lastRun
+		}
+	}
+
+	logger.Debug("Process complete")
+}
+
+func writeState() {
+	var stateLines []string
+
+	for key, lastRun := range lastRuns {
+		stateLines = append(stateLines, strings.Join( []string{ lastRun.Format(time.RFC3339), key }, " "))
+	}
+
+	sort.Strings(stateLines)
+
+	stateFileName := getStateFileName()
+	tmpFileName   := strings.Join( []string{ stateFileName, setup.CurrentPID }, ".")
+
+	if err := ioutil.WriteFile(tmpFileName, []byte(strings.Join(stateLines, "\n") + "\n"), 0600); err != nil {
+		logger.Warnf("Unable to write schedule state %s - %s", tmpFileName, err)
+		return
+	}
+
+	if err := os.Rename(tmpFileName, stateFileName); err != nil {
+		logger.Warnf("Unable to replace schedule state %s - %s", stateFileName, err)
+	}
+}
+
+func planEntries(scheduleEntries []*Entry, now time.Time) {
+	// Runs missed while the daemon was down are caught up according to ScheduleCatchUp
+
+	catchUp         := strings.ToUpper(config.ConfigValues["ScheduleCatchUp"])
+	catchUpHours, _ := strconv.Atoi(config.ConfigValues["ScheduleCatchUpHours"])
+
+	for _, entry := range scheduleEntries {
+		entry.Last = lastRuns[entry.key()]
+		entry.Next = entry.spec.next(now)
+
+		if entry.Last.IsZero() {
+			continue
+		}
+
+		missed := entry.spec.next(entry.Last)
+
+		if missed.IsZero() || missed.After(now) {
+			continue
+		}
+
+		switch {
+		case catchUp != "ONCE":
+			logger.Infof("Skipping run of %s for %s missed at %s", entry.Script, entry.Database, missed.Format(time.RFC3339))
+		case catchUpHours > 0 && now.Sub(missed) > time.Duration(catchUpHours) * time.Hour:
+			logger.Infof("Skipping run of %s for %s missed at %s as more than %d hours ago", entry.Script, entry.Database, missed.Format(time.RFC3339), catchUpHours)
+		default:
+			logger.Infof("Catching up run of %s for %s missed at %s", entry.Script, entry.Database, missed.Format(time.RFC3339))
+			entry.Next = now
+		}
+	}
+}
+
+func reloadSchedule() {
+	// Picks up changes to the schedule file without restarting
+
+	fileInfo, err := os.Stat(getScheduleFileName())
+	if err != nil || fileInfo.ModTime().Equal(scheduleTime) {
+		return
+	}
+
+	logger.Info("Schedule file changed. Reloading ...")
+
+	scheduleEntries, modTime, err := loadSchedule()
+	if err != nil {
+		logger.Warnf("Unable to reload schedule - %s. Keeping current schedule", err)
+		scheduleTime = fileInfo.ModTime()
+		return
+	}
+
+	planEntries(scheduleEntries, clock.Now())
+
+	entries      = scheduleEntries
+	scheduleTime = modTime
+
+	logger.Info("Process complete")
+}
+
+func childArgs(entry *Entry) []string {
+	// Runs use the same config and log directory as the daemon
+
+	var args []string
+
+	flag.Visit(func(flagParam *flag.Flag) {
+		switch flagParam.Name {
+		case "config", "c", "log", "L":
+			args = append(args, strings.Join( []string{ "-", flagParam.Name, "=", flagParam.Value.String() }, ""))
+		}
+	})
+
+	args = append(args, entry.Options...)
+
+	return append(args, "-d", entry.Database, entry.Script)
+}
+
+func startChild(entry *Entry, finished func()) error {
+	command, err := general.StartChild(childArgs(entry))
+	if err != nil {
+		return err
+	}
+
+	// Each run logs, records its history and mails as normal so only the outcome is noted here
+
+	go func() {
+		if err := command.Wait(); err != nil {
+			logger.Warnf("Run of %s for %s failed - %s. See its log for details", entry.Script, entry.Database, err)
+		} else {
+			logger.Infof("Run of %s for %s complete", entry.Script, entry.Database)
+		}
+
+		finished()
+	}()
+
+	return nil
+}
+
+func runEntry(entry *Entry) {
+	key := entry.key()
+
+	// Marked as running before starting so a run that ends at once is not left marked
+
+	runMutex.Lock()
+
+	if running[key] {
+		runMutex.Unlock()
+
+		logger.Warnf("Skipping run of %s for %s as the last run has not finished", entry.Script, entry.Database)
+		return
+	}
+
+	running[key] = true
+
+	runMutex.Unlock()
+
+	finished := func() {
+		runMutex.Lock()
+		delete(running, key)
+		runMutex.Unlock()
+	}
+
+	logger.Infof("Starting %s for %s due at %s", entry.Script, entry.Database, entry.Next.Format(time.RFC3339))
+
+	if err := startRun(entry, finished); err != nil {
+		logger.Warnf("Unable to start %s for %s - %s", entry.Script, entry.Database, err)
+
+		finished()
+		return
+	}
+
+	lastRuns[key] = entry.Next
+	entry.Last    = entry.Next
+}
+
+func tick() time.Duration {
+	// Starts the runs due and returns how long to wait for the next one
+
+	reloadSchedule()
+
+	now := clock.Now()
+
+	started := false
+
+	for _, entry := range entries {
+		if entry.Next.IsZero() || entry.Next.After(now) {
+			continue
+		}
+
+		runEntry(entry)
+
+		started    = true
+		entry.Next = entry.spec.next(now)
+	}
+
+	if started {
+		writeState()
+	}
+
+	// Wake at least every minute to pick up schedule changes
+
+	wait := time.Minute
+
+	for _, entry := range entries {
+		if !entry.Next.IsZero() && entry.Next.Sub(now) < wait {
+			wait = entry.Next.Sub(now)
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait
+}
+
+func startSchedule() {
+	switch strings.ToUpper(config.ConfigValues["ScheduleCatchUp"]) {
+	case "NONE", "ONCE":
+	default:
+		logger.Errorf("ScheduleCatchUp must be NONE or ONCE not %s", config.ConfigValues["ScheduleCatchUp"])
+	}
+
+	var err error
+
+	if entries, scheduleTime, err = loadSchedule(); err != nil {
+		logger.Errorf("Unable to read schedule %s - %s", getScheduleFileName(), err)
+	}
+
+	readState()
+
+	planEntries(entries, clock.Now())
+}
+
+// Global functions
+
+func Run() {
+	logger.Info("Starting scheduler ...")
+
+	startSchedule()
+
+	logger.Infof("Scheduled %d runs from %s", len(entries), getScheduleFileName())
+
+	for {
+		clock.Sleep(tick())
+	}
+}
+
+func List(w io.Writer) {
+	logger.Info("Listing schedule ...")
+
+	startSchedule()
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "SCHEDULE\tDATABASE\tSCRIPT\tOPTIONS\tLAST RUN\tNEXT RUN")
+
+	for _, entry := range entries {
+		lastRun := "-"
+		nextRun := "never"
+
+		if !entry.Last.IsZero() {
+			lastRun = entry.Last.Format("2006-01-02 15:04")
+		}
+
+		if !entry.Next.IsZero() {
+			nextRun = entry.Next.Format("2006-01-02 15:04")
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Expression, entry.Database, filepath.Base(entry.Script), strings.Join(entry.Options, " "), lastRun, nextRun)
+	}
+
+	table.Flush()
+
+	logger.Info("Process complete")
+}
@@ -0,0 +1,238 @@
+package schedule
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/setup"
+)
+
+// fakeClock only moves when the scheduler sleeps
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time               { return c.now }
+func (c *fakeClock) Sleep(duration time.Duration) { c.now = c.now.Add(duration) }
+
+func testTime(t *testing.T, value string) time.Time {
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return parsed
+}
+
+func TestNext(t *testing.T) {
+	cases := []struct {
+		expression string
+		after      string
+		next       string
+	}{
+		{"0 2 * * *", "2020-01-01 01:59", "2020-01-01 02:00"},
+		{"0 2 * * *", "2020-01-01 02:00", "2020-01-02 02:00"},
+		{"*/15 * * * *", "2020-01-01 10:07", "2020-01-01 10:15"},
+		{"30 22 * * MON-FRI", "2020-01-03 23:00", "2020-01-06 22:30"},
+		{"0 0 1 */3 *", "2020-02-10 00:00", "2020-04-01 00:00"},
+		{"0 0 13 * 5", "2020-03-01 00:00", "2020-03-06 00:00"},
+		{"0 0 29 feb *", "2021-01-01 00:00", "2024-02-29 00:00"},
+		{"@weekly", "2020-01-01 00:00", "2020-01-05 00:00"},
+		{"5,35 1-3 * * 7", "2020-01-05 03:40", "2020-01-12 01:05"},
+	}
+
+	for _, c := range cases {
+		spec, err := parseCron(c.expression)
+		if err != nil {
+			t.Fatalf("err: %s %s", c.expression, err)
+		}
+
+		if next := spec.next(testTime(t, c.after)); !next.Equal(testTime(t, c.next)) {
+			t.Fatalf("bad: %s after %s gave %s", c.expression, c.after, next)
+		}
+	}
+
+	if spec, _ := parseCron("0 0 30 2 *"); !spec.next(testTime(t, "2020-01-01 00:00")).IsZero() {
+		t.Fatal("30 February should never run")
+	}
+
+	for _, expression := range []string{"0 2 * *", "60 * * * *", "* * * * 8", "*/0 * * * *", "0 5-2 * * *", "@fortnightly"} {
+		if _, err := parseCron(expression); err == nil {
+			t.Fatalf("should error: %s", expression)
+		}
+	}
+}
+
+func TestReadSchedule(t *testing.T) {
+	setup.RMANScriptDir = "/opt/run_rman/rman_scripts"
+
+	schedule := `# Nightly backups
+0 1 * * *    ORCL  level_0_backup.rman  -l ORCL -r TAPE=1 -E dba@example.com
+@hourly      ORCL  /u01/scripts/arch_del_backup.rman -l=ORCL
+`
+
+	entries, err := readSchedule(strings.NewReader(schedule))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(entries) != 2 || entries[0].Expression != "0 1 * * *" || entries[1].Expression != "@hourly" {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	if entries[0].Script != filepath.Join(setup.RMANScriptDir, "level_0_backup.rman") || entries[1].Script != "/u01/scripts/arch_del_backup.rman" {
+		t.Fatalf("bad: %s %s", entries[0].Script, entries[1].Script)
+	}
+
+	if !reflect.DeepEqual(entries[0].Options, []string{"-l", "ORCL", "-r", "TAPE=1", "-E", "dba@example.com"}) {
+		t.Fatalf("bad: %#v", entries[0].Options)
+	}
+
+	for _, line := range []string{"0 1 * * * ORCL", "0 1 * * * ORCL a.rman -d TEST", "0 1 * * * ORCL a.rman -l", "0 1 * * * ORCL a.rman extra"} {
+		if _, err := readSchedule(strings.NewReader(line)); err == nil {
+			t.Fatalf("should error: %s", line)
+		}
+	}
+}
+
+// useTestSchedule writes the schedule and state and records the runs started
+func useTestSchedule(t *testing.T, schedule string, state string, now string) (*fakeClock, *[]string, func()) {
+	dir, err := ioutil.TempDir("", "schedule")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config.ConfigValues["ScheduleFile"] = filepath.Join(dir, "run_rman.schedule")
+	setup.CurrentPID = "1"
+
+	if err := ioutil.WriteFile(config.ConfigValues["ScheduleFile"], []byte(schedule), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if state != "" {
+		if err := ioutil.WriteFile(getStateFileName(), []byte(state), 0600); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	fake := &fakeClock{now: testTime(t, now)}
+	clock = fake
+
+	var started []string
+
+	startRun = func(entry *Entry, finished func()) error {
+		started = append(started, entry.Database+" "+fake.now.Format("2006-01-02 15:04"))
+		finished()
+
+		return nil
+	}
+
+	lastRuns = make(map[string]time.Time)
+
+	return fake, &started, func() {
+		os.RemoveAll(dir)
+
+		clock = realClock{}
+		startRun = startChild
+
+		config.ConfigValues["ScheduleFile"] = ""
+		config.ConfigValues["ScheduleCatchUp"] = "ONCE"
+		config.ConfigValues["ScheduleCatchUpHours"] = "24"
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	fake, started, cleanup := useTestSchedule(t, "0 */6 * * * ORCL /tmp/a.rman\n30 1 * * * TEST /tmp/b.rman\n", "", "2020-01-01 00:30")
+	defer cleanup()
+
+	startSchedule()
+
+	end := testTime(t, "2020-01-02 00:30")
+
+	for fake.now.Before(end) {
+		clock.Sleep(tick())
+	}
+
+	expected := []string{"TEST 2020-01-01 01:30", "ORCL 2020-01-01 06:00", "ORCL 2020-01-01 12:00", "ORCL 2020-01-01 18:00", "ORCL 2020-01-02 00:00"}
+
+	if !reflect.DeepEqual(*started, expected) {
+		t.Fatalf("bad: %#v", *started)
+	}
+
+	// The last runs are kept for catching up after a restart
+	state, err := ioutil.ReadFile(getStateFileName())
+	if err != nil || !strings.Contains(string(state), "2020-01-02T00:00:00") {
+		t.Fatalf("bad: %q %v", state, err)
+	}
+}
+
+func TestDaemonStillRunning(t *testing.T) {
+	fake, started, cleanup := useTestSchedule(t, "*/10 * * * * ORCL /tmp/a.rman\n", "", "2020-01-01 00:05")
+	defer cleanup()
+
+	// The run never finishes so later runs are skipped
+	startRun = func(entry *Entry, finished func()) error {
+		*started = append(*started, fake.now.Format("15:04"))
+
+		return nil
+	}
+
+	startSchedule()
+
+	end := testTime(t, "2020-01-01 01:00")
+
+	for fake.now.Before(end) {
+		clock.Sleep(tick())
+	}
+
+	running = make(map[string]bool)
+
+	if !reflect.DeepEqual(*started, []string{"00:10"}) {
+		t.Fatalf("bad: %#v", *started)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	schedule := "0 1 * * * ORCL /tmp/a.rman\n0 2 * * * TEST /tmp/b.rman\n"
+
+	// ORCL missed 2019-12-31 01:00 and TEST missed 2020-01-01 02:00
+	state := testTime(t, "2019-12-30 01:00").Format(time.RFC3339) + " 0 1 * * * ORCL /tmp/a.rman\n" +
+		testTime(t, "2019-12-31 02:00").Format(time.RFC3339) + " 0 2 * * * TEST /tmp/b.rman\n"
+
+	cases := []struct {
+		catchUp string
+		hours   string
+		started []string
+	}{
+		{"ONCE", "0", []string{"ORCL 2020-01-01 12:00", "TEST 2020-01-01 12:00"}},
+		{"ONCE", "24", []string{"TEST 2020-01-01 12:00"}},
+		{"NONE", "0", nil},
+	}
+
+	for _, c := range cases {
+		_, started, cleanup := useTestSchedule(t, schedule, state, "2020-01-01 12:00")
+
+		config.ConfigValues["ScheduleCatchUp"] = c.catchUp
+		config.ConfigValues["ScheduleCatchUpHours"] = c.hours
+
+		startSchedule()
+		tick()
+
+		if !reflect.DeepEqual(*started, c.started) {
+			t.Fatalf("bad %s %s: %#v", c.catchUp, c.hours, *started)
+		}
+
+		// Caught up runs are not repeated
+		if !entries[0].Next.Equal(testTime(t, "2020-01-02 01:00")) {
+			t.Fatalf("bad next run: %s", entries[0].Next)
+		}
+
+		cleanup()
+	}
+}
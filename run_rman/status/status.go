@@ -0,0 +1,138 @@
+package status
+
+// Standard imports
+
+import "fmt"
+import "os"
+import "path/filepath"
+import "strings"
+import "text/tabwriter"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/coordinator"
+
+// local Variables
+
+const ownerPrefix = "#"
+
+// Local functions
+
+func describeEntry(setName string, processEntry string) (string, string, string, string) {
+	logger.Debugf("Describing entry %s ...", processEntry)
+
+	pid, _, hostName, _ := utils.SplitProcessEntry(processEntry)
+
+	state := "dead"
+
+	if pidAlive, pidIsName := coordinator.Current.CheckProcess(processEntry, setup.BaseName); pidAlive {
+		if pidIsName {
+			state = "running"
+		} else {
+			state = "reused"
+		}
+	}
+
+	heartbeat := "-"
+
+	if leaseAge, expired := coordinator.LeaseExpired(processEntry); leaseAge > 0 {
+		heartbeat = leaseAge.Truncate(time.Second).String()
+
+		if expired && state == "running" {
+			state = "expired"
+
+			coordinator.ReportExpiredLease(setName, processEntry, leaseAge)
+		}
+	}
+
+	description := ""
+
+	if hostName == setup.HostName {
+		description = utils.GetProcessDescription(pid)
+	}
+
+	return fmt.Sprintf("%d@%s", pid, hostName), state, heartbeat, description
+}
+
+func showLocks(output *tabwriter.Writer, lockFileName string) {
+	logger.Infof("Showing locks in %s ...", lockFileName)
+
+	fmt.Fprintf(output, "\nLocks in %s\n", lockFileName)
+	fmt.Fprintf(output, "PROCESS\tLOCK\tSTATE\tHEARTBEAT\tCOMMAND\n")
+
+	for _, lockEntry := range coordinator.Current.ReadEntries(lockFileName) {
+		variableTokens := strings.SplitN(lockEntry, " ", 2)
+
+		lockName := ""
+		if len(variableTokens) > 1 {
+			lockName = variableTokens[1]
+		}
+
+		process, state, heartbeat, description := describeEntry(lockFileName, variableTokens[0])
+
+		fmt.Fprintf(output, "%s\t%s\t%s\t%s\t%s\n", process, lockName, state, heartbeat, description)
+	}
+
+	logger.Debug("Process complete")
+}
+
+func showResources(output *tabwriter.Writer) {
+	logger.Info("Showing resources ...")
+
+	fmt.Fprintf(output, "\nResources in %s\n", setup.ResourceFileName)
+	fmt.Fprintf(output, "PROCESS\tRESOURCES\tSTATE\tHEARTBEAT\tCOMMAND\n")
+
+	obtainedPrefix := strings.Join( []string{ setup.ResourceFileName, setup.ObtainedResSuffix, "" }, ".")
+
+	for _, setName := range coordinator.Current.ListSets(obtainedPrefix) {
+		var resourceList []string
+
+		processEntry := ""
+
+		for _, resEntry := range coordinator.Current.ReadEntries(setName) {
+			if strings.HasPrefix(resEntry, ownerPrefix) {
+				processEntry = strings.TrimPrefix(resEntry, ownerPrefix)
+			} else {
+				resourceList = append(resourceList, resEntry)
+			}
+		}
+
+		if processEntry == "" {
+			logger.Warnf("No owner found for %s.  Ignoring ...", setName)
+			continue
+		}
+
+		process, state, heartbeat, description := describeEntry(setName, processEntry)
+
+		fmt.Fprintf(output, "%s\t%s\t%s\t%s\t%s\n", process, strings.Join(resourceList, ","), state, heartbeat, description)
+	}
+
+	logger.Debug("Process complete")
+}
+
+// Global functions
+
+func ShowStatus() {
+	logger.Info("Showing status ...")
+
+	output := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	showLocks(output, setup.LockFileName)
+
+	if config.ConfigValues["RMANConfig"] != "" {
+		resetLockFileName := filepath.Join(filepath.Dir(config.ConfigValues["RMANConfig"]), filepath.Base(config.ConfigValues["RMANConfig"]) + ".lock")
+
+		showLocks(output, resetLockFileName)
+	}
+
+	showResources(output)
+
+	output.Flush()
+
+	logger.Info("Process complete")
+}
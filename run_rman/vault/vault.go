@@ -0,0 +1,401 @@
+package vault
+
+// Standard imports
+
+import "bufio"
+import "crypto/aes"
+import "crypto/cipher"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/base64"
+import "encoding/binary"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "regexp"
+import "runtime"
+import "sort"
+import "strings"
+
+// Local imports
+
+import "github.com/daviesluke/filelock"
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/utils"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/credential"
+
+// Global Variables
+
+// PassphraseEnv names the environment variable used for the key when there is no key file
+
+const PassphraseEnv = "RUN_RMAN_VAULT_PASSPHRASE"
+
+// ErrNoKey is returned when neither a key file nor a passphrase is available
+
+var ErrNoKey = errors.New("no vault key file or " + PassphraseEnv + " found")
+
+// Provider resolves vault:NAME connection references
+
+type Provider struct{}
+
+// local Variables
+
+const vaultFileName    = "run_rman.vault"
+const keyFileName      = "run_rman.vault.key"
+
+const vaultVersion     = 1
+const keyIterations    = 100000
+const saltLength       = 16
+
+var nameRegEx = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// vaultFile is the layout on disk. Only the salt and encrypted data are stored
+
+type vaultFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Data       string `json:"data"`
+}
+
+// Local functions
+
+func init() {
+	credential.Register("vault", Provider{})
+}
+
+func getVaultFileName() string {
+	if config.ConfigValues["VaultFile"] != "" {
+		return config.ConfigValues["VaultFile"]
+	}
+
+	return filepath.Join(setup.ConfigDir, vaultFileName)
+}
+
+func checkPermissions(fileName string) error {
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+
+	// Windows does not have unix permissions so rely on the file ACLs there
+
+	if runtime.GOOS != "windows" && fileInfo.Mode().Perm() & 0077 != 0 {
+		return fmt.Errorf("%s has permissions %04o and must only be readable by its owner", fileName, fileInfo.Mode().Perm())
+	}
+
+	return nil
+}
+
+func getPassphrase() ([]byte, error) {
+	logger.Debug("Getting vault key ...")
+
+	keyFile := config.ConfigValues["VaultKeyFile"]
+
+	if keyFile == "" {
+		keyFile = filepath.Join(setup.ConfigDir, keyFileName)
+	}
+
+	if _, err := os.Stat(keyFile); err == nil {
+		if err := checkPermissions(keyFile); err != nil {
+			return nil, err
+		}
+
+		passphrase, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read vault key file %s", keyFile)
+		}
+
+		logger.Debugf("Using vault key file %s", keyFile)
+
+		return []byte(strings.TrimSpace(string(passphrase))), nil
+	}
+
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		logger.Debugf("Using vault passphrase from %s", PassphraseEnv)
+
+		return []byte(passphrase), nil
+	}
+
+	return nil, ErrNoKey
+}
+
+func deriveKey(passphrase []byte, salt []byte, iterations int) []byte {
+	// PBKDF2 with HMAC-SHA256 producing a single block i.e. an AES-256 key
+
+	prf := hmac.New(sha256.New, passphrase)
+
+	prf.Write(salt)
+	binary.Write(prf, binary.BigEndian, uint32(1))
+
+	block := prf.Sum(nil)
+	key   := append([]byte(nil), block...)
+
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(block)
+		block = prf.Sum(block[:0])
+
+		for j := range key {
+			key[j] ^= block[j]
+		}
+	}
+
+	return key
+}
+
+func getCipher(passphrase []byte, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(passphrase, salt, iterations))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func readVault() (map[string]string, error) {
+	fileName := getVaultFileName()
+
+	logger.Debugf("Reading vault %s ...", fileName)
+
+	credentials := make(map[string]string)
+
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		logger.Debug("Vault does not exist yet")
+		return credentials, nil
+	}
+
+	if err := checkPermissions(fileName); err != nil {
+		return nil, err
+	}
+
+	vaultContents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read vault %s", fileName)
+	}
+
+	var contents vaultFile
+
+	if err := json.Unmarshal(vaultContents, &contents); err != nil || contents.Version != vaultVersion {
+		return nil, fmt.Errorf("vault %s is not a version %d vault", fileName, vaultVersion)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(contents.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s has a corrupt salt", fileName)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(contents.Data)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s has corrupt data", fileName)
+	}
+
+	passphrase, err := getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := getCipher(passphrase, salt, contents.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("vault %s has corrupt data", fileName)
+	}
+
+	plainText, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt vault %s - wrong key or the file has been changed", fileName)
+	}
+
+	if err := json.Unmarshal(plainText, &credentials); err != nil {
+		return nil, fmt.Errorf("vault %s has corrupt data", fileName)
+	}
+
+	logger.Debugf("Read %d credentials", len(credentials))
+
+	return credentials, nil
+}
+
+func writeVault(credentials map[string]string) error {
+	fileName := getVaultFileName()
+
+	logger.Debugf("Writing vault %s ...", fileName)
+
+	passphrase, err := getPassphrase()
+	if err != nil {
+		return err
+	}
+
+	// A new salt and nonce each time the vault is written
+
+	salt := make([]byte, saltLength)
+
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	aead, err := getCipher(passphrase, salt, keyIterations)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	plainText, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+
+	contents := vaultFile{
+		Version    : vaultVersion,
+		Iterations : keyIterations,
+		Salt       : base64.StdEncoding.EncodeToString(salt),
+		Data       : base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plainText, nil)),
+	}
+
+	vaultContents, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a new file and move it into place so a failure never leaves a partial vault
+
+	tempFileName := fileName + "." + setup.CurrentPID
+
+	if err := ioutil.WriteFile(tempFileName, vaultContents, 0600); err != nil {
+		return fmt.Errorf("unable to write vault %s", tempFileName)
+	}
+
+	if err := os.Rename(tempFileName, fileName); err != nil {
+		os.Remove(tempFileName)
+		return fmt.Errorf("unable to replace vault %s", fileName)
+	}
+
+	logger.Debug("Process complete")
+
+	return nil
+}
+
+func changeVault(change func(map[string]string) error) error {
+	// Lock the vault so concurrent changes are not lost
+
+	filelock.LockFile(getVaultFileName(), 20)
+	defer filelock.UnlockFile(getVaultFileName())
+
+	credentials, err := readVault()
+	if err != nil {
+		return err
+	}
+
+	if err := change(credentials); err != nil {
+		return err
+	}
+
+	return writeVault(credentials)
+}
+
+// Global functions
+
+func (p Provider) Resolve(name string) (string, error) {
+	return Get(name)
+}
+
+func Get(name string) (string, error) {
+	credentials, err := readVault()
+	if err != nil {
+		return "", err
+	}
+
+	connection, found := credentials[name]
+	if !found {
+		return "", fmt.Errorf("credential %s not found in vault %s", name, getVaultFileName())
+	}
+
+	return connection, nil
+}
+
+func Add(name string, connection string) error {
+	logger.Infof("Adding credential %s to vault ...", name)
+
+	if !nameRegEx.MatchString(name) {
+		return fmt.Errorf("invalid credential name %s - use letters, numbers and _ only", name)
+	}
+
+	if connection == "" {
+		return fmt.Errorf("no connection given for %s", name)
+	}
+
+	utils.AddConnectionSecret(connection)
+
+	err := changeVault(func(credentials map[string]string) error {
+		credentials[name] = connection
+		return nil
+	})
+
+	logger.Info("Process complete")
+
+	return err
+}
+
+func Remove(name string) error {
+	logger.Infof("Removing credential %s from vault ...", name)
+
+	err := changeVault(func(credentials map[string]string) error {
+		if _, found := credentials[name]; !found {
+			return fmt.Errorf("credential %s not found in vault %s", name, getVaultFileName())
+		}
+
+		delete(credentials, name)
+
+		return nil
+	})
+
+	logger.Info("Process complete")
+
+	return err
+}
+
+func List() ([]string, error) {
+	logger.Info("Listing vault credentials ...")
+
+	credentials, err := readVault()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for name := range credentials {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	logger.Info("Process complete")
+
+	return names, nil
+}
+
+func ReadConnection(input io.Reader) (string, error) {
+	// Connections are read from stdin so they never appear in the process arguments
+
+	connection, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimSpace(connection), nil
+}
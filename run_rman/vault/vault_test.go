@@ -0,0 +1,201 @@
+package vault
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/run_rman/credential"
+)
+
+func testVault(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config.ConfigValues["VaultFile"] = filepath.Join(dir, "test.vault")
+	config.ConfigValues["VaultKeyFile"] = filepath.Join(dir, "test.key")
+
+	os.Setenv(PassphraseEnv, "correct horse")
+
+	return dir, func() {
+		os.Unsetenv(PassphraseEnv)
+		config.ConfigValues["VaultFile"] = ""
+		config.ConfigValues["VaultKeyFile"] = ""
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	// RFC 7914 PBKDF2-HMAC-SHA256 test vector
+	key := deriveKey([]byte("passwd"), []byte("salt"), 1)
+
+	if hex.EncodeToString(key) != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" {
+		t.Fatalf("bad: %x", key)
+	}
+}
+
+func TestVault(t *testing.T) {
+	_, cleanup := testVault(t)
+	defer cleanup()
+
+	if names, err := List(); err != nil || len(names) != 0 {
+		t.Fatalf("bad: %v %s", names, err)
+	}
+
+	if err := Add("PRODCAT", "rman/Secret1@cat"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := Add("TARGET", "sys/Secret2@prod"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := Add("bad name", "x/y@z"); err == nil {
+		t.Fatal("should error")
+	}
+
+	names, err := List()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"PRODCAT", "TARGET"}) {
+		t.Fatalf("bad: %v", names)
+	}
+
+	// Nothing is stored in clear text
+	contents, err := ioutil.ReadFile(config.ConfigValues["VaultFile"])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if strings.Contains(string(contents), "Secret1") || strings.Contains(string(contents), "PRODCAT") {
+		t.Fatalf("bad: %s", contents)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(config.ConfigValues["VaultFile"])
+
+		if info.Mode().Perm() != 0600 {
+			t.Fatalf("bad: %v", info.Mode())
+		}
+	}
+
+	// Connections resolve through the credential package
+	connection, err := credential.Resolve("vault:PRODCAT")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if connection != "rman/Secret1@cat" {
+		t.Fatalf("bad: %s", connection)
+	}
+
+	if _, err := credential.Resolve("vault:MISSING"); err == nil {
+		t.Fatal("should error")
+	}
+
+	if err := Remove("TARGET"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := Remove("TARGET"); err == nil {
+		t.Fatal("should error")
+	}
+
+	if _, err := Get("TARGET"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestVaultKey(t *testing.T) {
+	dir, cleanup := testVault(t)
+	defer cleanup()
+
+	if err := Add("PRODCAT", "rman/Secret1@cat"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The wrong passphrase cannot read the vault
+	os.Setenv(PassphraseEnv, "wrong")
+
+	if _, err := Get("PRODCAT"); err == nil {
+		t.Fatal("should error")
+	}
+
+	os.Unsetenv(PassphraseEnv)
+
+	if _, err := Get("PRODCAT"); err != ErrNoKey {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// A key file takes precedence over the passphrase
+	keyFile := filepath.Join(dir, "test.key")
+
+	if err := ioutil.WriteFile(keyFile, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	os.Setenv(PassphraseEnv, "wrong")
+
+	if connection, err := Get("PRODCAT"); err != nil || connection != "rman/Secret1@cat" {
+		t.Fatalf("bad: %s %v", connection, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// A key file others can read is refused
+	os.Chmod(keyFile, 0640)
+
+	if _, err := Get("PRODCAT"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestVaultTampered(t *testing.T) {
+	_, cleanup := testVault(t)
+	defer cleanup()
+
+	if err := Add("PRODCAT", "rman/Secret1@cat"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	contents, err := ioutil.ReadFile(config.ConfigValues["VaultFile"])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Flip a character in the encrypted data
+	dataStart := strings.Index(string(contents), `"data": "`) + len(`"data": "`)
+
+	if contents[dataStart+4] == 'A' {
+		contents[dataStart+4] = 'B'
+	} else {
+		contents[dataStart+4] = 'A'
+	}
+
+	if err := ioutil.WriteFile(config.ConfigValues["VaultFile"], contents, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := Get("PRODCAT"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestReadConnection(t *testing.T) {
+	connection, err := ReadConnection(strings.NewReader("rman/pass@cat\n"))
+	if err != nil || connection != "rman/pass@cat" {
+		t.Fatalf("bad: %s %v", connection, err)
+	}
+}
@@ -0,0 +1,339 @@
+package watch
+
+// Standard imports
+
+import "flag"
+import "fmt"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Local imports
+
+import "github.com/daviesluke/logger"
+import "github.com/daviesluke/setup"
+import "github.com/daviesluke/run_rman/config"
+import "github.com/daviesluke/run_rman/general"
+import "github.com/daviesluke/run_rman/oracle"
+import "github.com/daviesluke/run_rman/oratab"
+
+// local Variables
+
+// thresholds are the limits that fire the script. Zero turns a check off
+
+type thresholds struct {
+	FRAPct       float64
+	ArchiveBytes int64
+}
+
+type usage struct {
+	HasFRA       bool
+	FRAPct       float64
+	ArchiveBytes int64
+}
+
+var scriptFile    string
+var defaultConfig = make(map[string]string)
+
+var lastRun       = make(map[string]time.Time)
+var running       = make(map[string]bool)
+var runMutex      sync.Mutex
+
+// Local functions
+
+func getConfigInt(configName string) (int, error) {
+	// Values can be set per database so a bad one skips that database rather than stopping the watch
+
+	configValue, err := strconv.Atoi(config.ConfigValues[configName])
+	if err != nil || configValue < 0 {
+		return 0, fmt.Errorf("%s must be a whole number not %s", configName, config.ConfigValues[configName])
+	}
+
+	return configValue, nil
+}
+
+func getDatabases() []string {
+	logger.Info("Getting databases to watch ...")
+
+	var databases []string
+
+	switch {
+	case setup.Database != "":
+		databases = []string{ setup.Database }
+	case config.ConfigValues["WatchDatabases"] != "":
+		databases = strings.Split(config.ConfigValues["WatchDatabases"], ",")
+	default:
+		entries, err := oratab.ReadAll(config.ConfigValues["OraTabPath"])
+		if err != nil {
+			logger.Errorf("Unable to read oratab - %s", err)
+		}
+
+		for _, entry := range oratab.Databases(entries) {
+			databases = append(databases, entry.SID)
+		}
+	}
+
+	// The same SID may be listed more than once
+
+	var watchList []string
+
+	found := make(map[string]bool)
+
+	for _, database := range databases {
+		database = strings.TrimSpace(database)
+
+		if database != "" && !found[database] {
+			found[database] = true
+			watchList = append(watchList, database)
+		}
+	}
+
+	if len(watchList) == 0 {
+		logger.Errorf("No databases to watch. Use -d, WatchDatabases or list them in OraTabPath")
+	}
+
+	logger.Infof("Watching %s", strings.Join(watchList, ", "))
+
+	logger.Debug("Process complete")
+
+	return watchList
+}
+
+func setDatabase(database string) error {
+	// Start from the file values each time so one database's settings are not kept for the next
+
+	for configName, configValue := range defaultConfig {
+		config.ConfigValues[configName] = configValue
+	}
+
+	return general.CheckEnvironment(database)
+}
+
+func getThresholds() (thresholds, error) {
+	var limits thresholds
+
+	fraPct, err := getConfigInt("WatchFRAPct")
+	if err != nil {
+		return limits, err
+	}
+
+	archiveMB, err := getConfigInt("WatchArchiveMB")
+	if err != nil {
+		return limits, err
+	}
+
+	limits = thresholds{
+		FRAPct:       float64(fraPct),
+		ArchiveBytes: int64(archiveMB) * 1024 * 1024,
+	}
+
+	return limits, nil
+}
+
+func getUsage() (usage, error) {
+	var current usage
+
+	recoveryArea, hasFRA, err := oracle.GetRecoveryArea()
+	if err != nil {
+		return current, err
+	}
+
+	current.HasFRA = hasFRA
+	current.FRAPct = recoveryArea.UsedPct()
+
+	if current.ArchiveBytes, err = oracle.GetUnbackedArchiveBytes(); err != nil {
+		return current, err
+	}
+
+	return current, nil
+}
+
+func checkThresholds(current usage, limits thresholds) []string {
+	var reasons []string
+
+	if limits.FRAPct > 0 && current.HasFRA && current.FRAPct >= limits.FRAPct {
+		reasons = append(reasons, fmt.Sprintf("recovery area %.1f%% used", current.FRAPct))
+	}
+
+	if limits.ArchiveBytes > 0 && current.ArchiveBytes >= limits.ArchiveBytes {
+		reasons = append(reasons, fmt.Sprintf("%d MB of archived logs not backed up", current.ArchiveBytes / 1024 / 1024))
+	}
+
+	return reasons
+}
+
+func isDue(database string, now time.Time, minInterval time.Duration) bool {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	if running[database] {
+		return false
+	}
+
+	return now.Sub(lastRun[database]) >= minInterval
+}
+
+func childArgs(database string) []string {
+	// The script runs with the same options as the watch so takes the same locks and resources
+
+	var args []string
+
+	flag.Visit(func(flagParam *flag.Flag) {
+		if flagParam.Name != "db" && flagParam.Name != "d" {
+			args = append(args, strings.Join( []string{ "-", flagParam.Name, "=", flagParam.Value.String() }, ""))
+		}
+	})
+
+	return append(args, "-d", database, scriptFile)
+}
+
+func startScript(database string, now time.Time) {
+	command, err := general.StartChild(childArgs(database))
+	if err != nil {
+		logger.Warnf("Unable to run %s for %s - %s", scriptFile, database, err)
+		return
+	}
+
+	logger.Infof("Started %s for %s (PID %d)", scriptFile, database, command.Process.Pid)
+
+	runMutex.Lock()
+	running[database] = true
+	lastRun[database] = now
+	runMutex.Unlock()
+
+	// The run logs, records its history and mails as normal so only the outcome is noted here
+
+	go func() {
+		if err := command.Wait(); err != nil {
+			logger.Warnf("Run of %s for %s failed - %s. See its log for details", scriptFile, database, err)
+		} else {
+			logger.Infof("Run of %s for %s complete", scriptFile, database)
+		}
+
+		runMutex.Lock()
+		running[database] = false
+		runMutex.Unlock()
+	}()
+}
+
+func pollDatabase(database string, now time.Time) {
+	logger.Infof("Checking database %s ...", database)
+
+	if err := setDatabase(database); err != nil {
+		logger.Warnf("Unable to set the environment for %s - %s. Skipping ...", database, err)
+		return
+	}
+
+	minInterval, err := getConfigInt("WatchMinIntervalMins")
+	if err != nil {
+		logger.Warnf("Unable to check %s - %s. Skipping ...", database, err)
+		return
+	}
+
+	limits, err := getThresholds()
+	if err != nil {
+		logger.Warnf("Unable to check %s - %s. Skipping ...", database, err)
+		return
+	}
+
+	if !isDue(database, now, time.Duration(minInterval) * time.Minute) {
+		logger.Infof("Script for %s is running or ran less than %s minutes ago", database, config.ConfigValues["WatchMinIntervalMins"])
+		return
+	}
+
+	current, err := getUsage()
+	if err != nil {
+		logger.Warnf("Unable to check %s - %s", database, err)
+		return
+	}
+
+	logger.Infof("Recovery area %.1f%% used. %d MB of archived logs not backed up", current.FRAPct, current.ArchiveBytes / 1024 / 1024)
+
+	reasons := checkThresholds(current, limits)
+
+	if len(reasons) == 0 {
+		logger.Debug("Process complete")
+		return
+	}
+
+	logger.Infof("Running %s for %s as %s", scriptFile, database, strings.Join(reasons, " and "))
+
+	startScript(database, now)
+
+	logger.Debug("Process complete")
+}
+
+// Global functions
+
+func Run() {
+	logger.Info("Starting watch ...")
+
+	if len(general.CommandArgs) != 1 {
+		logger.Errorf("Usage: %s [options] watch <script>", setup.BaseName)
+	}
+
+	var err error
+
+	if scriptFile, err = filepath.Abs(general.CommandArgs[0]); err != nil {
+		logger.Errorf("Unable to get absolute pathname for %s", general.CommandArgs[0])
+	}
+
+	if _, err := os.Stat(scriptFile); err != nil {
+		logger.Errorf("Unable to find RMAN script %s", scriptFile)
+	}
+
+	config.RMANScriptBase = "watch"
+
+	// Read the config file
+	config.GetConfig(setup.ConfigFileName)
+
+	for configName, configValue := range config.ConfigValues {
+		defaultConfig[configName] = configValue
+	}
+
+	// Settings for the watch itself are not database specific
+	config.SetAllConfig("")
+
+	pollSecs, err := getConfigInt("WatchPollSecs")
+	if err != nil {
+		logger.Errorf("%s", err)
+	}
+
+	pollInterval := time.Duration(pollSecs) * time.Second
+
+	if pollInterval == 0 {
+		logger.Errorf("WatchPollSecs must be more than 0")
+	}
+
+	// Check each environment now and skip any that cannot be used rather than stopping the watch
+
+	var databases []string
+
+	for _, database := range getDatabases() {
+		if err := setDatabase(database); err != nil {
+			logger.Warnf("Unable to set the environment for %s - %s. Not watching", database, err)
+			continue
+		}
+
+		databases = append(databases, database)
+	}
+
+	if len(databases) == 0 {
+		logger.Errorf("No databases with a usable environment to watch")
+	}
+
+	logger.Infof("Polling every %s", pollInterval)
+
+	for {
+		now := time.Now()
+
+		for _, database := range databases {
+			pollDatabase(database, now)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,129 @@
+package watch
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daviesluke/run_rman/config"
+	"github.com/daviesluke/setup"
+)
+
+func TestCheckThresholds(t *testing.T) {
+	limits := thresholds{FRAPct: 80, ArchiveBytes: 100 * 1024 * 1024}
+
+	if reasons := checkThresholds(usage{HasFRA: true, FRAPct: 79.9, ArchiveBytes: 1024}, limits); len(reasons) != 0 {
+		t.Fatalf("bad: %#v", reasons)
+	}
+
+	reasons := checkThresholds(usage{HasFRA: true, FRAPct: 85, ArchiveBytes: 200 * 1024 * 1024}, limits)
+
+	if !reflect.DeepEqual(reasons, []string{"recovery area 85.0% used", "200 MB of archived logs not backed up"}) {
+		t.Fatalf("bad: %#v", reasons)
+	}
+
+	// Zero turns a check off and no recovery area never fires
+	if reasons := checkThresholds(usage{FRAPct: 95, ArchiveBytes: 200 * 1024 * 1024}, thresholds{FRAPct: 80}); len(reasons) != 0 {
+		t.Fatalf("bad: %#v", reasons)
+	}
+}
+
+func TestGetThresholds(t *testing.T) {
+	config.ConfigValues["WatchFRAPct"] = "80"
+	config.ConfigValues["WatchArchiveMB"] = "100"
+
+	if limits, err := getThresholds(); err != nil || limits != (thresholds{FRAPct: 80, ArchiveBytes: 100 * 1024 * 1024}) {
+		t.Fatalf("bad: %#v %v", limits, err)
+	}
+
+	// A bad value for one database is returned rather than ending the watch
+	config.ConfigValues["WatchArchiveMB"] = "lots"
+
+	if _, err := getThresholds(); err == nil {
+		t.Fatal("should error")
+	}
+
+	config.ConfigValues["WatchArchiveMB"] = "-1"
+
+	if _, err := getConfigInt("WatchArchiveMB"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestIsDue(t *testing.T) {
+	now := time.Now()
+
+	if !isDue("ORCL", now, time.Hour) {
+		t.Fatal("first run should be due")
+	}
+
+	lastRun["ORCL"] = now.Add(-30 * time.Minute)
+
+	if isDue("ORCL", now, time.Hour) || !isDue("ORCL", now.Add(30*time.Minute), time.Hour) {
+		t.Fatal("bad minimum interval")
+	}
+
+	running["ORCL"] = true
+	defer delete(running, "ORCL")
+
+	if isDue("ORCL", now.Add(2*time.Hour), time.Hour) {
+		t.Fatal("should not run while running")
+	}
+}
+
+func TestChildArgs(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet("run_rman", flag.ContinueOnError)
+
+	flag.String("lock", "", "Lock name")
+	flag.String("d", "", "Database name")
+	flag.String("resource", "", "Resource name")
+
+	flag.Set("lock", "arch")
+	flag.Set("d", "ORCL")
+
+	scriptFile = "/opt/run_rman/rman_scripts/arch_del_backup.rman"
+
+	expected := []string{"-lock=arch", "-d", "TEST", scriptFile}
+
+	if args := childArgs("TEST"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("bad: %#v", args)
+	}
+}
+
+func TestSetDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	goodHome := filepath.Join(dir, "good")
+	os.MkdirAll(filepath.Join(goodHome, "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(goodHome, "bin", "rman"), nil, 0755)
+
+	oratabFile := filepath.Join(dir, "oratab")
+	ioutil.WriteFile(oratabFile, []byte("GOOD:"+goodHome+":N\nBAD:"+filepath.Join(dir, "bad")+":N\n"), 0644)
+
+	setup.PathDelimiter = ":"
+	defaultConfig = map[string]string{"OraTabPath": oratabFile}
+	defer func() { defaultConfig = make(map[string]string) }()
+
+	for configName, configValue := range defaultConfig {
+		config.ConfigValues[configName] = configValue
+	}
+
+	os.Unsetenv("ORACLE_HOME")
+
+	// A database without rman is reported rather than ending the watch
+	if err := setDatabase("BAD"); err == nil {
+		t.Fatal("should error")
+	}
+
+	if err := setDatabase("GOOD"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
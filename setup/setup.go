@@ -2,6 +2,8 @@ package setup
 
 // Standard imports
 
+import "crypto/rand"
+import "fmt"
 import "os"
 import "path/filepath"
 import "runtime"
@@ -11,6 +13,7 @@ import "strconv"
 // Local imports
 
 import "github.com/daviesluke/logger"
+import "github.com/daviesluke/utils"
 
 // Global Variables
 
@@ -51,6 +54,9 @@ var HistFileName             string
 // Misc variables 
 
 var CurrentPID               string
+var HostName                 string
+var RunID                    string
+var ProcessEntry             string
 
 var DirDelimiter             string
 var PathDelimiter            string
@@ -80,6 +86,53 @@ func setPID () {
 	logger.Tracef("Current PID set to %s", CurrentPID)
 }
 
+func setHostName () {
+	var err error
+
+	//
+	// Get the host name to record against locks and resources
+	//
+	logger.Trace("Getting host name ...")
+
+	HostName, err = os.Hostname()
+	if err != nil {
+		logger.Error("Unable to get the host name. Exiting with errors ...")
+	}
+
+	logger.Tracef("Host name set to %s", HostName)
+}
+
+func setRunID () {
+	//
+	// Generate a random (version 4) UUID to identify this run
+	//
+	logger.Trace("Generating run ID ...")
+
+	uuid := make([]byte, 16)
+
+	if _, err := rand.Read(uuid); err != nil {
+		logger.Error("Unable to generate a run ID. Exiting with errors ...")
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	RunID = fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+
+	logger.Tracef("Run ID set to %s", RunID)
+}
+
+func setProcessEntry () {
+	//
+	// Process entry written to lock, resource and reset files
+	//
+	logger.Trace("Setting process entry ...")
+
+	ProcessEntry = utils.MakeProcessEntry(os.Getpid(), HostName, RunID)
+
+	logger.Tracef("Process entry set to %s", ProcessEntry)
+}
+
 func setDelimiter () {
 	//
 	// Get current OS
@@ -208,7 +261,7 @@ func setResourceFile () {
 
 	ResourceUsageFileName    = strings.Join([]string{ResourceFileName, UsedResSuffix}, ".")
 
-	ResourceObtainedFileName = strings.Join([]string{ResourceFileName, ObtainedResSuffix, CurrentPID}, ".")
+	ResourceObtainedFileName = strings.Join([]string{ResourceFileName, ObtainedResSuffix, RunID}, ".")
 }
 
 func setRMANDir () {
@@ -237,6 +290,12 @@ func setHistFile () {
 func Initialize() {
 	setPID()
 
+	setHostName()
+
+	setRunID()
+
+	setProcessEntry()
+
 	setDelimiter()
 
 	setBase()
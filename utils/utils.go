@@ -9,7 +9,9 @@ import "os"
 import "os/signal"
 import "path/filepath"
 import "regexp"
+import "strconv"
 import "strings"
+import "sync"
 import "syscall"
 import "time"
 
@@ -18,6 +20,20 @@ import "time"
 import "github.com/daviesluke/logger"
 import "github.com/daviesluke/mitchellh/go-ps"
 
+// Local variables
+
+// Connect commands and user/password@alias strings found in free text such as RMAN output
+
+var connectRegEx    = regexp.MustCompile(`(?i)(connect\s+(?:target|catalog|auxiliary)\s+)([^\s;]+)`)
+var credentialRegEx = regexp.MustCompile(`([A-Za-z0-9_$#]+)/("[^"]*"|[^\s/@"']+)@`)
+
+// Registered secrets are only masked as the password of a connect string so that a password
+// which is also an ordinary word does not mangle the rest of the log
+
+var secretList      []string
+var secretRegExList []*regexp.Regexp
+var secretMutex     sync.Mutex
+
 // Local functions 
 
 type fn func() 
@@ -70,12 +86,30 @@ func CheckRegExGroup(checkString string, regEx string, ignoreRegEx string, regEx
 	return found
 }
 
-func RemovePassword(checkString string, printWarn bool) string {
-	logger.Debug("Removing any passwords found ...")
+func stripPassword(checkString string) string {
+	// Keeps the user name and any TNS alias from user/password@alias
+	// Does not log so it can be used when masking log messages
+
+	upTokens := strings.SplitN(checkString, "/", 2)
+
+	userName := upTokens[0]
+	passWord := ""
+
+	if len(upTokens) != 1 {
+		passWord = upTokens[1]
+	}
+
+	if strings.Index(passWord,"@") != -1 {
+		passTokens := strings.SplitN(passWord, "@", 2)
+
+		userName = strings.Join([]string{userName, passTokens[1]},"@")
+	}
 
-	var userName        string
+	return userName
+}
 
-	logger.Trace("Checking string for passwords ...")
+func RemovePassword(checkString string, printWarn bool) string {
+	logger.Debug("Removing any passwords found ...")
 
 	if CheckRegEx(checkString, ".+/[^@]+") {
 		if printWarn {
@@ -85,35 +119,62 @@ func RemovePassword(checkString string, printWarn bool) string {
 		logger.Debug("Removing password for display ...")
 	}
 
-	upTokens := strings.SplitN(checkString, "/", 2)
-	logger.Debugf("Split string using / delimiter into %d tokens.  Username is %s", len(upTokens), upTokens[0])
+	userName := stripPassword(checkString)
 
-	passWord := ""
+	logger.Debugf("Process complete - returning %s", userName)
 
-	userName = upTokens[0]
-	logger.Tracef("Username set to %s", userName)
+	return userName
+}
 
-	if len(upTokens) != 1 {
-		passWord = upTokens[1]
-		logger.Trace("Password set")
+func AddSecret(secret string) {
+	// Secrets registered here are masked where they follow user/ in the logs
+
+	if secret == "" {
+		return
 	}
 
-	if strings.Index(passWord,"@") != -1 {
-		logger.Debug("Password portion contains a TNS Alias")
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
 
-		passTokens := strings.SplitN(passWord, "@", 2)
-		logger.Debugf("Split string using @ delimiter into %d tokens.  TNS Alias is %s", len(passTokens), passTokens[1])
+	for _, knownSecret := range secretList {
+		if knownSecret == secret {
+			return
+		}
+	}
+
+	secretList      = append(secretList, secret)
+	secretRegExList = append(secretRegExList, regexp.MustCompile(strings.Join( []string{ `/`, regexp.QuoteMeta(secret), `([@\s;"']|$)` }, "")))
+}
 
-		tnsAlias := passTokens[1]
-		logger.Tracef("TNS Alias set to %s", tnsAlias)
+func AddConnectionSecret(connection string) {
+	// Registers the password part of user/password@alias
 
-		userName = strings.Join([]string{userName, tnsAlias},"@")
-		logger.Tracef("Username now set to %s", userName)
+	upTokens := strings.SplitN(connection, "/", 2)
+
+	if len(upTokens) == 2 && upTokens[0] != "" {
+		AddSecret(strings.SplitN(upTokens[1], "@", 2)[0])
 	}
+}
 
-	logger.Debugf("Process complete - returning %s", userName)
+func MaskPasswords(text string) string {
+	// Removes passwords from connect commands, user/password@alias strings and registered secrets after user/
 
-	return userName
+	text = connectRegEx.ReplaceAllStringFunc(text, func(connectString string) string {
+		connectTokens := connectRegEx.FindStringSubmatch(connectString)
+
+		return connectTokens[1] + stripPassword(connectTokens[2])
+	})
+
+	text = credentialRegEx.ReplaceAllString(text, "${1}@")
+
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+
+	for _, secretRegEx := range secretRegExList {
+		text = secretRegEx.ReplaceAllString(text, "/*****${1}")
+	}
+
+	return text
 }
 
 func TrapSignal(runFunction fn) {
@@ -248,12 +309,159 @@ func CopyFileContents( fromFileName string , toFileName string , regEx string )
         logger.Debug("Process complete")
 }
 
-func CheckProcess (pid int, processName string) (bool, bool) {
-	logger.Debugf("Checking PID %d is running process %s", pid, processName)
+func GetProcessStartTime (pid int) string {
+	logger.Debugf("Getting start time for PID %d ...", pid)
+
+	startTime := ""
+
+	if checkProcess , err := ps.FindProcess(pid); checkProcess != nil && err == nil {
+		// Start time is only available on some platforms
+
+		if startProcess, ok := checkProcess.(interface{ StartTicks() uint64 }); ok {
+			startTime = strconv.FormatUint(startProcess.StartTicks(), 10)
+		} else {
+			logger.Debug("Process start time not available on this platform")
+		}
+	} else {
+		logger.Debugf("Pid %d not found running", pid)
+	}
+
+	logger.Debugf("Returning %s", startTime)
+
+	return startTime
+}
+
+func GetProcessDescription (pid int) string {
+	logger.Debugf("Getting description of PID %d ...", pid)
+
+	description := ""
+
+	if checkProcess , err := ps.FindProcess(pid); checkProcess != nil && err == nil {
+		// Details are not available on all platforms so only report what we can
+
+		description = checkProcess.Executable()
+
+		if commandLine, err := checkProcess.CommandLine(); err == nil && len(commandLine) > 0 {
+			description = strings.Join(commandLine, " ")
+		}
+
+		if userName, err := checkProcess.Username(); err == nil {
+			description = fmt.Sprintf("%s, user %s", description, userName)
+		}
+
+		if startTime, err := checkProcess.StartTime(); err == nil {
+			description = fmt.Sprintf("%s, started %s", description, startTime.Format("2006/01/02:15:04:05"))
+		}
+
+		if ancestors, err := ps.Ancestors(pid); err == nil && len(ancestors) > 0 {
+			var parentList []string
+
+			for _, parent := range ancestors {
+				parentList = append(parentList, fmt.Sprintf("%s(%d)", parent.Executable(), parent.Pid()))
+			}
+
+			description = fmt.Sprintf("%s, parents %s", description, strings.Join(parentList, " <- "))
+		}
+	} else {
+		logger.Debugf("Pid %d not found running", pid)
+	}
+
+	logger.Debugf("Returning %s", description)
+
+	return description
+}
+
+func MakeProcessEntry (pid int, hostName string, runID string) string {
+	logger.Debugf("Making process entry for PID %d ...", pid)
+
+	processEntry := strings.Join( []string{ strconv.Itoa(pid), GetProcessStartTime(pid), hostName, runID }, ":")
+
+	logger.Debugf("Returning %s", processEntry)
+
+	return processEntry
+}
+
+func SplitProcessEntry (processEntry string) (int, string, string, string) {
+	logger.Tracef("Splitting process entry %s ...", processEntry)
+
+	// Entries are PID:STARTTIME:HOSTNAME:RUNID@HEARTBEAT but older files only hold the PID
+
+	entryParts := strings.SplitN(strings.SplitN(processEntry, "@", 2)[0], ":", 4)
+
+	for len(entryParts) < 4 {
+		entryParts = append(entryParts, "")
+	}
+
+	pid, err := strconv.Atoi(entryParts[0])
+	if err != nil {
+		logger.Errorf("Process entry is corrupted %s, PID should be a number", processEntry)
+	}
+
+	logger.Tracef("PID %d, start time %s, host %s, run ID %s", pid, entryParts[1], entryParts[2], entryParts[3])
+
+	return pid, entryParts[1], entryParts[2], entryParts[3]
+}
+
+func SameProcessEntry (firstEntry string, secondEntry string) bool {
+	// Entries are the same process whatever their heartbeat
+
+	return strings.SplitN(firstEntry, "@", 2)[0] == strings.SplitN(secondEntry, "@", 2)[0]
+}
+
+func SetEntryHeartbeat (entry string, heartbeat time.Time) string {
+	logger.Tracef("Setting heartbeat in entry %s ...", entry)
+
+	// The heartbeat is the first @ followed by seconds since the epoch in the entry
+	// If there is not one yet then the entry must be a bare process entry
+
+	heartbeatString := strings.Join( []string{ "@", strconv.FormatInt(heartbeat.Unix(), 10) }, "")
+
+	newEntry := strings.Join( []string{ entry, heartbeatString }, "")
+
+	if heartbeatIndex := regexp.MustCompile("@[0-9]+").FindStringIndex(entry); heartbeatIndex != nil {
+		newEntry = strings.Join( []string{ entry[:heartbeatIndex[0]], heartbeatString, entry[heartbeatIndex[1]:] }, "")
+	}
+
+	logger.Tracef("Returning %s", newEntry)
+
+	return newEntry
+}
+
+func GetEntryHeartbeat (entry string) (time.Time, bool) {
+	logger.Tracef("Getting heartbeat from entry %s ...", entry)
+
+	heartbeatString := regexp.MustCompile("@([0-9]+)").FindStringSubmatch(entry)
+
+	if heartbeatString == nil {
+		logger.Trace("Entry has no heartbeat")
+		return time.Time{}, false
+	}
+
+	heartbeat, err := strconv.ParseInt(heartbeatString[1], 10, 64)
+	if err != nil {
+		logger.Tracef("Invalid heartbeat %s", heartbeatString[1])
+		return time.Time{}, false
+	}
+
+	return time.Unix(heartbeat, 0), true
+}
+
+func CheckProcess (processEntry string, processName string) (bool, bool) {
+	logger.Debugf("Checking process entry %s is running process %s", processEntry, processName)
 
 	pidAlive  := false
 	pidIsName := false
 
+	pid, startTime, hostName, _ := SplitProcessEntry(processEntry)
+
+	// A process on another host cannot be checked from here so must be assumed to be alive
+
+	if currentHost, err := os.Hostname(); err == nil && hostName != "" && hostName != currentHost {
+		logger.Infof("PID %d is running on host %s not %s. Assuming it is still running", pid, hostName, currentHost)
+
+		return true, true
+	}
+
 	if checkProcess , err := ps.FindProcess(pid); checkProcess != nil && err == nil {
 		logger.Debugf("Pid %d is running", pid)
 
@@ -271,6 +479,21 @@ func CheckProcess (pid int, processName string) (bool, bool) {
 			logger.Debugf("Pid %d matches name %s", pid, processName)
 			pidIsName = true
 		}
+
+		// The PID may have been reused by a later process so the start time must match as well
+
+		if pidIsName && startTime != "" {
+			if pidStartTime := GetProcessStartTime(pid); pidStartTime != startTime {
+				logger.Warnf("PID %d started at %s not %s. PID has been reused", pid, pidStartTime, startTime)
+				pidIsName = false
+			} else {
+				logger.Debugf("Pid %d start time matches %s", pid, startTime)
+			}
+		}
+
+		if pidIsName {
+			logger.Infof("PID %d is running %s", pid, GetProcessDescription(pid))
+		}
 	} else {
 		logger.Debugf("Pid %d not found running", pid)
 	}
@@ -371,6 +594,32 @@ func FindInFile( fileName string, regEx string, ignoreRegEx string, regGroup int
 	return found
 }
 
+func CountInFile( fileName string, regEx string, ignoreRegEx string, regGroup int ) int {
+	logger.Debug("Counting lines in file matching regular expression ...")
+
+	count := 0
+
+	if file, err := os.Open(fileName); err == nil {
+		fileScanner := bufio.NewScanner(file)
+
+		for fileScanner.Scan() {
+			if ignoreRegEx == "" {
+				if CheckRegEx(fileScanner.Text(),regEx) {
+					count++
+				}
+			} else if CheckRegExGroup(fileScanner.Text(),regEx,ignoreRegEx,regGroup) {
+				count++
+			}
+		}
+
+		file.Close()
+	}
+
+	logger.Debugf("Returning %d", count)
+
+	return count
+}
+
 func ReplaceString( inString string, regEx string, replaceString string ) string {
 	logger.Debug("Replacing regex by string ...")
 